@@ -5,9 +5,14 @@ import (
 	"github.com/ssp4599815/beat/libbeat/common"
 	"github.com/ssp4599815/beat/libbeat/publisher"
 	"os"
+	"reflect"
+	"sync"
+	"time"
 
 	"github.com/ssp4599815/beat/libbeat/beat"
 	"github.com/ssp4599815/beat/libbeat/cfgfile"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/paths"
 
 	cfg "github.com/ssp4599815/beat/filebeat/config"
 	. "github.com/ssp4599815/beat/filebeat/crawler"
@@ -20,29 +25,113 @@ type Filebeat struct {
 	publisherChan chan []*FileEvent // 是一个channel， 把从 harvesters 读取到的日志发送到 spooler
 	Spooler       *Spooler          // 把从 通道里读取日志缓存起来，等待 publisher来拉取
 	registrar     *Registrar        // 记录每次读取文件的状态信息
+	crawler       *Crawler          // 管理所有的 prospector
+	reloader      *ConfigReloader   // 监听 config_dir 的变化，为空表示没有开启
+
+	prospectorConfigs []cfg.ProspectorConfig // 主配置文件中的 prospector，Reload 时用来判断配置是否有变化
+	mutex             sync.Mutex             // 保护 running 和 prospectorConfigs，Reload 和 Stop 会在信号处理的 goroutine 中调用
+	running           bool                   // 所有的 prospector 都启动之后，Stop 之前为 true
+	reloadMutex       sync.Mutex             // 保证同一时间只有一个 Reload 在重启 prospector，Stop 不需要等待它
 }
 
 // 加载所有的配置文件
 // Config setup up the filebeat configuration by fetch all additional config files
 func (fb *Filebeat) Config(b *beat.Beat) error {
-	// Load Base config  加载基础的配置文件
-	err := cfgfile.Read(&fb.FbConfig, "")
+	err := fb.ReadConfig()
 	if err != nil {
-		return fmt.Errorf("Error reading config file: %v\n", err)
+		return err
 	}
 
 	// 如果 config_dir 指定的话，就拉取所有的配置文件
 	// Check if optional config_dir is set to fetch additional prospecrot config file
-	fb.FbConfig.FetchConfigs()
+	return fb.FbConfig.FetchConfigs()
+}
+
+// ReadConfig 只读取 -c 指定的配置文件和 -E 参数，不读取 config_dir 中的 prospector。
+// 只需要 registry 文件位置的子命令使用它，不要求配置了 prospector
+// ReadConfig reads the main config without the config_dir prospectors
+func (fb *Filebeat) ReadConfig() error {
+	fb.FbConfig = &cfg.Config{}
+	err := readConfig(fb.FbConfig)
+	if err != nil {
+		return err
+	}
+	fb.prospectorConfigs = append([]cfg.ProspectorConfig{}, fb.FbConfig.Filebeat.Prospectors...)
+
+	config := &fb.FbConfig.Filebeat
+
+	// registry 文件的相对路径相对于 -path.data 目录
+	if config.RegistryFile == "" {
+		config.RegistryFile = cfg.DefaultRegistryFile
+	}
+	config.RegistryFile = paths.Resolve(paths.Data, config.RegistryFile)
+
+	// 设置检查 config_dir 变化的时间间隔
+	if config.ReloadFrequency == "" {
+		config.ReloadFrequencyDuration = cfg.DefaultReloadFrequency
+	} else {
+		config.ReloadFrequencyDuration, err = time.ParseDuration(config.ReloadFrequency)
+		if err != nil {
+			return fmt.Errorf("Failed to parse reload_frequency '%s': %v", config.ReloadFrequency, err)
+		}
+	}
 
 	return nil
 }
 
-// 启动程序时要做的操作
+// readConfig 严格校验并读取所有 -c 指定的配置文件和 -E 参数，不包括 config_dir 中的配置文件
+func readConfig(config *cfg.Config) error {
+	// 严格校验配置文件，不认识的配置项、错误的时间间隔和编码格式都会报错
+	// Validate the config file up front, before any prospector is started
+	for _, file := range cfgfile.ConfigFiles() {
+		if err := cfg.ValidateFile(file); err != nil {
+			return fmt.Errorf("Invalid config file:\n%v", err)
+		}
+	}
+
+	// 命令行中的 -E 参数同样需要校验
+	overrides, err := cfgfile.Overrides()
+	if err == nil && overrides != nil {
+		err = cfg.Validate("-E", overrides)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid -E settings:\n%v", err)
+	}
+
+	// Load Base config  加载基础的配置文件
+	err = cfgfile.Read(config, "")
+	if err != nil {
+		return fmt.Errorf("Error reading config file: %v\n", err)
+	}
+	return nil
+}
+
+// 启动程序时要做的操作，创建 registrar 和 crawler，HTTP 监控接口在 Run 之前就会读取 crawler 的状态
 func (fb *Filebeat) Setup(b *beat.Beat) error {
+	var err error
+
+	// 开启一个 registrar 来持久化 文件状态
+	// setup registrar to persist state
+	fb.registrar, err = NewRegistrar(fb.FbConfig.Filebeat.RegistryFile)
+	if err != nil {
+		logp.Err("Could not init registrar: %v", err)
+		return err
+	}
+
+	// 开启一个爬虫，来抓取 日志文件
+	fb.crawler = &Crawler{
+		Registrar: fb.registrar,
+	}
 	return nil
 }
 
+// State 返回所有 prospector 和 harvester 的状态，由 HTTP 监控接口的 /state 输出
+func (fb *Filebeat) State() interface{} {
+	return common.MapStr{
+		"prospectors": fb.crawler.State(),
+	}
+}
+
 // 正式运行filebeat
 func (fb *Filebeat) Run(b *beat.Beat) error {
 	// 处理异常情况
@@ -52,7 +141,7 @@ func (fb *Filebeat) Run(b *beat.Beat) error {
 			return
 		}
 
-		fmt.Printf("recovered panic:%v", p)
+		logp.Critical("Fatal panic: %v", p)
 		os.Exit(1)
 	}()
 
@@ -61,19 +150,6 @@ func (fb *Filebeat) Run(b *beat.Beat) error {
 	// 初始化通道，该通道是将获取到的 event 发送到 publisher
 	fb.publisherChan = make(chan []*FileEvent, 1)
 
-	// 开启一个 registrar 来持久化 文件状态
-	// setup registrar to persist state
-	fb.registrar, err = NewRegistrar(fb.FbConfig.Filebeat.RegistryFile)
-	if err != nil {
-		fmt.Printf("Could not init registrar: %v", err)
-		return err
-	}
-
-	// 开启一个爬虫，来抓取 日志文件
-	crawl := &Crawler{
-		Registrar: fb.registrar,
-	}
-
 	// 启动 crawer 的时候，从 持久化文件中 加载 当前日志文件的状态信息， 后续给 prospector 使用
 	// Load the previous log file locations now ,for use in prospector
 	fb.registrar.LoadState()
@@ -84,7 +160,7 @@ func (fb *Filebeat) Run(b *beat.Beat) error {
 	// 配置 spooler的相关信息
 	err = fb.Spooler.Config()
 	if err != nil {
-		fmt.Printf("Could not init spooler ：%v", err)
+		logp.Err("Could not init spooler: %v", err)
 		return err
 	}
 
@@ -94,12 +170,23 @@ func (fb *Filebeat) Run(b *beat.Beat) error {
 
 	// 准备开始探测文件（从 配置文件的 input 中获取的所有要收集的日志）
 	// Prospectors 为所有的 prospect
-	crawl.Start(fb.FbConfig.Filebeat.Prospectors, fb.Spooler.Channel)
+	fb.crawler.Start(fb.FbConfig.Filebeat.Prospectors, fb.Spooler.Channel)
+
+	// 监听 config_dir 的变化，在运行时启动、重启或停止对应的 prospector
+	// Watch config_dir to reload prospectors without restarting filebeat
+	if fb.FbConfig.Filebeat.ConfigDir != "" && fb.FbConfig.Filebeat.ReloadConfigDir {
+		fb.reloader = NewConfigReloader(fb.crawler, fb.FbConfig.Filebeat.ConfigDir, fb.FbConfig.Filebeat.ReloadFrequencyDuration)
+		go fb.reloader.Run()
+	}
 
 	// 处理通道中的 日志事件信息 然后交给 output
 	// Publishes event to output
 	go Publish(b, fb)
 
+	fb.mutex.Lock()
+	fb.running = true
+	fb.mutex.Unlock()
+
 	// 开启 registrar，用来记录所有监听文件的 最后一次确认的位置。
 	// registrar records last acknowledged positions in all files.
 	fb.registrar.Run()
@@ -116,9 +203,19 @@ func (fb *Filebeat) Cleanup(b *beat.Beat) error {
 // Stop is called on exit for cleanup
 func (fb *Filebeat) Stop() {
 	// 主要做一些停止时候的清理工作
+	fb.mutex.Lock()
+	fb.running = false
+	fb.mutex.Unlock()
 
-	// Stop harvesters
-	// Stop prospectors
+	// Stop watching config_dir
+	if fb.reloader != nil {
+		fb.reloader.Stop()
+	}
+
+	// Stop prospectors and harvesters
+	if fb.crawler != nil {
+		fb.crawler.Stop()
+	}
 
 	// Stopping spooler will flush items
 	fb.Spooler.Stop()
@@ -130,9 +227,69 @@ func (fb *Filebeat) Stop() {
 	//close(fb.publisherChan)
 }
 
+// Reload 在收到 SIGHUP 时重新读取配置文件，只有 prospector 的配置会被重新加载:
+// 主配置文件中的 prospector 有变化时全部重启，config_dir 中的配置文件交给 ConfigReloader 立即检查一次。
+// 重启的 prospector 从 registrar 中获取每个文件已经发送成功的 offset，不会重复或者丢失日志
+// Reload re-reads the config files and restarts the prospectors if their configuration changed
+func (fb *Filebeat) Reload(b *beat.Beat) error {
+	// 停止 prospector 需要等待它的 harvester 退出，期间不能持有 fb.mutex，否则 Stop 会被阻塞
+	fb.reloadMutex.Lock()
+	defer fb.reloadMutex.Unlock()
+
+	fb.mutex.Lock()
+	running := fb.running
+	oldConfigs := fb.prospectorConfigs
+	fb.mutex.Unlock()
+
+	if !running {
+		logp.Info("Filebeat is not running, ignoring reload")
+		return nil
+	}
+
+	config := &cfg.Config{}
+	if err := readConfig(config); err != nil {
+		return err
+	}
+
+	if fb.reloader != nil {
+		fb.reloader.Trigger()
+	}
+
+	prospectorConfigs := config.Filebeat.Prospectors
+	if reflect.DeepEqual(prospectorConfigs, oldConfigs) {
+		logp.Info("Prospectors in the config files didn't change")
+		return nil
+	}
+
+	// 先检查新的配置，避免停止了旧的 prospector 之后新的 prospector 无法启动
+	for _, prospectorConfig := range prospectorConfigs {
+		if err := (&Prospector{ProspectorConfig: prospectorConfig}).Init(); err != nil {
+			return fmt.Errorf("Invalid prospector config: %v", err)
+		}
+	}
+
+	logp.Info("Prospectors in the config files changed, restarting them")
+	for _, prospector := range fb.crawler.Prospectors() {
+		// config_dir 中的 prospector 由 ConfigReloader 管理
+		if prospector.ProspectorConfig.ConfigFile == "" {
+			fb.crawler.StopProspector(prospector)
+		}
+	}
+	for _, prospectorConfig := range prospectorConfigs {
+		if _, err := fb.crawler.StartProspector(prospectorConfig); err != nil {
+			logp.Err("Error in initing prospector: %s", err)
+		}
+	}
+
+	fb.mutex.Lock()
+	fb.prospectorConfigs = append([]cfg.ProspectorConfig{}, prospectorConfigs...)
+	fb.mutex.Unlock()
+	return nil
+}
+
 // 将收集的日志事件信息传递出去
 func Publish(beat *beat.Beat, fb *Filebeat) {
-	fmt.Println("Start sending events to output")
+	logp.Info("Start sending events to output")
 
 	// 从 spool 中获取日志的事件信息，并刷新到output中
 	// Receives events from spool during flush
@@ -142,9 +299,13 @@ func Publish(beat *beat.Beat, fb *Filebeat) {
 		for _, event := range events {
 			pubEvents = append(pubEvents, event.ToMapStr())
 		}
-		beat.Events.PublishEvents(pubEvents, publisher.Sync)
+		// 一直重试直到发送成功，只有 publisher 停止时才会返回 false
+		if !beat.Events.PublishEvents(pubEvents, publisher.Sync, publisher.Guaranteed) {
+			logp.Warn("Publisher stopped, events not sent: %d", len(events))
+			continue
+		}
 
-		fmt.Println("Events sent: ", len(events))
+		logp.Debug("publish", "Events sent: %d", len(events))
 
 		// 告诉 registrar 我们已经成功的发送了这些事件信息， 没发送一次 event 就持久化一次 registrar
 		// Tell the registrar that we've successfully sent these events
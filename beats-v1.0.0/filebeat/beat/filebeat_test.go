@@ -0,0 +1,140 @@
+package beat
+
+import (
+	cfg "github.com/ssp4599815/beat/filebeat/config"
+	. "github.com/ssp4599815/beat/filebeat/crawler"
+	"github.com/ssp4599815/beat/libbeat/cfgfile"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeConfig 写入只有一个 prospector 的主配置文件，并让 cfgfile 读取它
+func writeConfig(t *testing.T, path string, logPath string) {
+	content := "filebeat:\n  prospectors:\n    - paths:\n        - " + logPath + "\n      scan_frequency: 10ms\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfgfile.Flags.ConfigFiles = []string{path}
+	cfgfile.Flags.Overwrites = nil
+}
+
+// newRunningFilebeat 按照 cfgfile 中的配置文件启动 prospector，返回的 Filebeat 和 Run 之后的状态一样。
+// 返回的函数停止所有的 prospector 和 registrar
+func newRunningFilebeat(t *testing.T, dir string) (*Filebeat, func()) {
+	registrar, err := NewRegistrar(filepath.Join(dir, "registry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registrar.LoadState()
+	registrarDone := make(chan struct{})
+	go func() {
+		defer close(registrarDone)
+		registrar.Run()
+	}()
+
+	config := &cfg.Config{}
+	if err := readConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	crawler := &Crawler{Registrar: registrar}
+	for _, prospectorConfig := range config.Filebeat.Prospectors {
+		if _, err := crawler.StartProspector(prospectorConfig); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fb := &Filebeat{
+		FbConfig:          config,
+		registrar:         registrar,
+		crawler:           crawler,
+		prospectorConfigs: append([]cfg.ProspectorConfig{}, config.Filebeat.Prospectors...),
+		running:           true,
+	}
+	stop := func() {
+		crawler.Stop()
+		registrar.Stop()
+		<-registrarDone
+	}
+	return fb, stop
+}
+
+func onlyProspector(t *testing.T, fb *Filebeat) *Prospector {
+	prospectors := fb.crawler.Prospectors()
+	if len(prospectors) != 1 {
+		t.Fatalf("expected 1 prospector, got %d", len(prospectors))
+	}
+	return prospectors[0]
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "filebeat.yml")
+	oldPath, newPath := filepath.Join(dir, "old.log"), filepath.Join(dir, "new.log")
+	writeConfig(t, configFile, oldPath)
+	fb, stop := newRunningFilebeat(t, dir)
+	defer stop()
+	prospector := onlyProspector(t, fb)
+
+	// 配置没有变化时 prospector 不会重启
+	if err := fb.Reload(nil); err != nil {
+		t.Fatal(err)
+	}
+	if onlyProspector(t, fb) != prospector {
+		t.Fatal("unchanged prospector was restarted")
+	}
+
+	// 错误的配置不会停止正在运行的 prospector
+	if err := ioutil.WriteFile(configFile, []byte("filebeat:\n  prospectors:\n    - pahts: [/var/log/a.log]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fb.Reload(nil); err == nil {
+		t.Error("expected error for invalid config")
+	}
+	if onlyProspector(t, fb) != prospector {
+		t.Fatal("invalid config stopped the running prospector")
+	}
+
+	// 修改过的 prospector 按照新的配置重启
+	writeConfig(t, configFile, newPath)
+	if err := fb.Reload(nil); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := onlyProspector(t, fb)
+	if reloaded == prospector || reloaded.State().Paths[0] != newPath {
+		t.Errorf("changed prospector not restarted: %v", reloaded.State().Paths)
+	}
+	if len(fb.prospectorConfigs) != 1 || fb.prospectorConfigs[0].Paths[0] != newPath {
+		t.Errorf("prospector configs not updated: %v", fb.prospectorConfigs)
+	}
+}
+
+func TestReload_NotRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "filebeat.yml")
+	writeConfig(t, configFile, filepath.Join(dir, "old.log"))
+	fb, stop := newRunningFilebeat(t, dir)
+	defer stop()
+	prospector := onlyProspector(t, fb)
+
+	// Run 之前或者 Stop 之后收到的 SIGHUP 被忽略
+	fb.running = false
+	writeConfig(t, configFile, filepath.Join(dir, "new.log"))
+	if err := fb.Reload(nil); err != nil {
+		t.Fatal(err)
+	}
+	if onlyProspector(t, fb) != prospector {
+		t.Error("prospector restarted while filebeat is not running")
+	}
+}
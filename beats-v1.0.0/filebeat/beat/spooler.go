@@ -1,12 +1,20 @@
 package beat
 
 import (
-	"fmt"
 	cfg "github.com/ssp4599815/beat/filebeat/config"
 	"github.com/ssp4599815/beat/filebeat/input"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/monitoring"
 	"time"
 )
 
+// spooler 的内部指标
+var (
+	spoolFlushes       = monitoring.NewCounter("filebeat.spooler.flushes")
+	spoolFlushedEvents = monitoring.NewCounter("filebeat.spooler.events_flushed")
+	spoolDepth         = monitoring.NewGauge("filebeat.spooler.depth")
+)
+
 // Spooler 负责从 harvester 中获取信息 然后 发送给 publisher
 type Spooler struct {
 	Filebeat      *Filebeat             // 将filebeat的相关信息传递进来
@@ -48,7 +56,7 @@ func (s *Spooler) Config() error {
 	// 设置空闲的时间，默认为 5秒
 	// set default idle timeout if not set
 	if config.IdleTimeout == "" {
-		fmt.Printf("Set idleTimeoutDuration to %s\n", cfg.DefaultUdleTimeout)
+		logp.Info("Set idleTimeoutDuration to %s", cfg.DefaultUdleTimeout)
 		// set it to default
 		config.IdleTimeoutDuration = cfg.DefaultUdleTimeout
 	} else {
@@ -56,7 +64,7 @@ func (s *Spooler) Config() error {
 		config.IdleTimeoutDuration, err = time.ParseDuration(config.IdleTimeout)
 
 		if err != nil {
-			fmt.Printf("Failed to parse idle timeout duration '%s'. Error was: %v", config.IdleTimeout, err)
+			logp.Err("Failed to parse idle timeout duration '%s'. Error was: %v", config.IdleTimeout, err)
 			return err
 		}
 	}
@@ -84,7 +92,7 @@ func (s *Spooler) Run() {
 	// 初始化一个 spool 用来 存放 从通道中获取的 日志文件信息
 	s.spool = make([]*input.FileEvent, 0, config.SpoolSize)
 
-	fmt.Printf("starting spooler: spool_size :%v, idle_timeout: %s", config.SpoolSize, config.IdleTimeoutDuration)
+	logp.Info("Starting spooler: spool_size: %v; idle_timeout: %s", config.SpoolSize, config.IdleTimeoutDuration)
 
 	// Loops until running is set to false
 	for {
@@ -97,23 +105,24 @@ func (s *Spooler) Run() {
 		// 从通道中获取 日志信息
 		case event := <-s.Channel:
 			s.spool = append(s.spool, event)
+			spoolDepth.Set(int64(len(s.spool)))
 
 			// Spooler if full -> flush  ， 通道满了 就发送
 			if len(s.spool) == cap(s.spool) {
-				fmt.Printf("Flushing spooler because spooler full, Events flushed: %v", len(s.spool))
+				logp.Debug("spooler", "Flushing spooler because spooler full. Events flushed: %v", len(s.spool))
 				// 执行刷新操作
 				s.flush()
 			}
 		case <-ticker.C: // 周期性的检查
 			// Flush periodically 周期性的进行刷新
 			if time.Now().After(s.nextFlushTime) {
-				fmt.Printf("Flush spooler because of timeout, Events flushed: %v", len(s.spool))
+				logp.Debug("spooler", "Flushing spooler because of timeout. Events flushed: %v", len(s.spool))
 				s.flush()
 			}
 		}
 	}
 
-	fmt.Println("Stopping spooler")
+	logp.Info("Stopping spooler")
 
 	// 退出之前也执行一次刷新操作
 	// Flush again before exiting spooler and closes channel
@@ -138,6 +147,9 @@ func (s *Spooler) flush() {
 
 		// 发送数据给 publisher 通道
 		s.Filebeat.publisherChan <- tmpCopy
+		spoolFlushes.Inc()
+		spoolFlushedEvents.Add(int64(len(tmpCopy)))
+		spoolDepth.Set(0)
 	}
 	// 然后 将 下次刷新事件 增加！
 	s.nextFlushTime = time.Now().Add(s.Filebeat.FbConfig.Filebeat.IdleTimeoutDuration)
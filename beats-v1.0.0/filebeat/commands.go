@@ -0,0 +1,358 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	filebeat "github.com/ssp4599815/beat/filebeat/beat"
+	"github.com/ssp4599815/beat/filebeat/crawler"
+	"github.com/ssp4599815/beat/filebeat/input"
+	"github.com/ssp4599815/beat/libbeat/beat"
+	"github.com/ssp4599815/beat/libbeat/cfgfile"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"gopkg.in/yaml.v2"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// command 是 filebeat 的一个子命令，例如 "test config"。
+// 子命令自己加载需要的配置，只有 run 会初始化日志、output 和 publisher
+type command struct {
+	name  []string
+	usage string
+	run   func(b *beat.Beat, fb *filebeat.Filebeat, args []string) error
+}
+
+// 没有指定子命令时执行 run
+var commands = []*command{
+	{[]string{"run"}, "Run filebeat (default)", runCommand},
+	{[]string{"test", "config"}, "Test the configuration and exit", testConfigCommand},
+	{[]string{"test", "output"}, "Connect to the configured outputs and report the result", testOutputCommand},
+	{[]string{"export", "config"}, "Print the effective configuration, including the config_dir prospectors", exportConfigCommand},
+	{[]string{"registry", "show"}, "Print the files and offsets stored in the registry file", registryShowCommand},
+	{[]string{"registry", "reset"}, "Remove the given files (all files if none given) from the registry file. Filebeat must not be running", registryResetCommand},
+}
+
+// parseCommand 从命令行参数中找出子命令，子命令前后都可以有参数:
+//
+//	filebeat -c filebeat.yml test config
+//	filebeat registry reset -c filebeat.yml /var/log/messages
+//
+// 返回的参数中只包含 flag 和子命令自己的参数，子命令的参数放在 "--" 之后
+func parseCommand(args []string) (*command, []string, error) {
+	var flags, positional []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+
+		flags = append(flags, arg)
+
+		// the value of a non boolean flag is the next argument, unless given as -flag=value
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		if f := flag.Lookup(name); f != nil && !isBoolFlag(f) && i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
+		}
+	}
+
+	if len(positional) == 0 {
+		return commands[0], flags, nil
+	}
+
+	for _, cmd := range commands {
+		if len(positional) < len(cmd.name) {
+			continue
+		}
+		if strings.Join(positional[:len(cmd.name)], " ") == strings.Join(cmd.name, " ") {
+			rest := append(flags, "--")
+			return cmd, append(rest, positional[len(cmd.name):]...), nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unknown command: %s", strings.Join(positional, " "))
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface {
+		IsBoolFlag() bool
+	})
+	return ok && b.IsBoolFlag()
+}
+
+// usage 打印所有的子命令和参数
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", Name)
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", strings.Join(cmd.name, " "), cmd.usage)
+	}
+	w.Flush()
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// runCommand 启动 filebeat，指定了 -configtest 时只检查配置文件
+func runCommand(b *beat.Beat, fb *filebeat.Filebeat, args []string) error {
+	// -configtest 只检查配置文件
+	if cfgfile.IsTestConfig() {
+		return testConfigCommand(b, fb, args)
+	}
+
+	// Loads base config 加载基础的配置文件，并初始化日志、output 和 publisher
+	b.LoadConfig()
+
+	// 读取 filebeat 的配置文件
+	if err := fb.Config(b); err != nil {
+		return fmt.Errorf("Config error: %v", err)
+	}
+
+	// Run beat. this calls first beater.Setup,
+	// then beater.Run and Beater.Cleanup in the end
+	b.Run()
+	return nil
+}
+
+// testConfigCommand 读取并校验所有的配置文件，不会连接 output
+func testConfigCommand(b *beat.Beat, fb *filebeat.Filebeat, args []string) error {
+	if err := readConfig(b, fb); err != nil {
+		return err
+	}
+	fmt.Println("Config OK")
+	return nil
+}
+
+// readConfig 读取 beat 和 filebeat 的所有配置，包括 config_dir 中的 prospector，没有任何副作用
+func readConfig(b *beat.Beat, fb *filebeat.Filebeat) error {
+	// filebeat 的配置会被严格校验，先读取它可以得到更准确的错误信息
+	if err := fb.Config(b); err != nil {
+		return fmt.Errorf("Config error: %v", err)
+	}
+	if err := b.ReadConfig(); err != nil {
+		return fmt.Errorf("Loading config file error: %v", err)
+	}
+	return nil
+}
+
+// testOutputCommand 使用每个 output 自己的 client 连接配置的节点 (包括 TLS、认证和协议)，
+// 并报告每个节点是否可用，不会发送任何事件
+func testOutputCommand(b *beat.Beat, fb *filebeat.Filebeat, args []string) error {
+	if err := b.ReadConfig(); err != nil {
+		return fmt.Errorf("Loading config file error: %v", err)
+	}
+	if len(b.Config.Output) == 0 {
+		return fmt.Errorf("No output configured")
+	}
+
+	names := make([]string, 0, len(b.Config.Output))
+	for name := range b.Config.Output {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := 0
+	for _, name := range names {
+		config := b.Config.Output[name]
+		if !config.IsEnabled() {
+			fmt.Printf("%s: disabled, skipped\n", name)
+			continue
+		}
+		n, err := testOutput(b, name, config)
+		if err != nil {
+			failed++
+			fmt.Printf("%s: ERROR %v\n", name, err)
+			continue
+		}
+		failed += n
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d output host(s) could not be reached", failed)
+	}
+	return nil
+}
+
+// testOutput 通过 output 插件初始化一个 output 并测试它的每个节点，返回连接失败的节点数
+func testOutput(b *beat.Beat, name string, config outputs.MothershipConfig) (int, error) {
+	builder := outputs.FindOutputPlugin(name)
+	if builder == nil {
+		return 0, fmt.Errorf("unknown output type")
+	}
+	output := builder()
+	tester, ok := output.(outputs.ConnectionTester)
+	if !ok {
+		fmt.Printf("%s: connection test not supported, skipped\n", name)
+		return 0, nil
+	}
+	if err := output.Init(b.Name, &config, b.Config.Shipper.TopologyExpire); err != nil {
+		return 0, err
+	}
+	defer output.Close()
+
+	failed := 0
+	for _, result := range tester.TestConnection() {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("%s: %s... ERROR %v\n", name, result.Host, result.Err)
+			continue
+		}
+		fmt.Printf("%s: %s... OK\n", name, result.Host)
+	}
+	return failed, nil
+}
+
+// exportConfigCommand 打印合并了所有 -c 文件、-E 参数和 config_dir 中 prospector 的配置。
+// config_dir 中的 prospector 已经包含在输出中了，所以输出中不再包含 config_dir
+func exportConfigCommand(b *beat.Beat, fb *filebeat.Filebeat, args []string) error {
+	if err := readConfig(b, fb); err != nil {
+		return err
+	}
+
+	filebeatConfig := fb.FbConfig.Filebeat
+	filebeatConfig.ConfigDir = ""
+	filebeatConfig.ReloadConfigDir = false
+
+	exported := map[string]interface{}{
+		"filebeat": filebeatConfig,
+		"output":   b.Config.Output,
+		"shipper":  b.Config.Shipper,
+		"logging":  b.Config.Logging,
+		"http":     b.Config.HTTP,
+	}
+
+	// 去掉所有没有设置的配置项，false 和 0 是有效的配置，需要保留
+	tmp, err := yaml.Marshal(exported)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err = yaml.Unmarshal(tmp, &doc); err != nil {
+		return err
+	}
+
+	out, err := yaml.Marshal(pruneEmpty(doc))
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// pruneEmpty 递归的删除值为 nil、空字符串、空 map 和空列表的配置项
+func pruneEmpty(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		for key, item := range v {
+			item = pruneEmpty(item)
+			if item == nil {
+				delete(v, key)
+			} else {
+				v[key] = item
+			}
+		}
+		if len(v) == 0 {
+			return nil
+		}
+	case []interface{}:
+		items := v[:0]
+		for _, item := range v {
+			if item = pruneEmpty(item); item != nil {
+				items = append(items, item)
+			}
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		return items
+	case string:
+		if v == "" {
+			return nil
+		}
+	}
+	return value
+}
+
+// loadRegistrar 加载 registry 文件中保存的状态，registry 文件的位置来自配置文件。
+// 只需要读取主配置文件，不需要配置 output 和 prospector
+func loadRegistrar(fb *filebeat.Filebeat) (*crawler.Registrar, error) {
+	if err := fb.ReadConfig(); err != nil {
+		return nil, fmt.Errorf("Config error: %v", err)
+	}
+
+	registryFile := fb.FbConfig.Filebeat.RegistryFile
+	if _, err := os.Stat(registryFile); err != nil {
+		return nil, fmt.Errorf("Could not open registry file: %v", err)
+	}
+
+	registrar, err := crawler.NewRegistrar(registryFile)
+	if err != nil {
+		return nil, err
+	}
+	registrar.LoadState()
+	return registrar, nil
+}
+
+// registryShowCommand 打印 registry 文件中每个文件的 offset
+func registryShowCommand(b *beat.Beat, fb *filebeat.Filebeat, args []string) error {
+	registrar, err := loadRegistrar(fb)
+	if err != nil {
+		return err
+	}
+
+	sources := make([]string, 0, len(registrar.State))
+	for source := range registrar.State {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "OFFSET\tINODE\tDEVICE\tSOURCE")
+	for _, source := range sources {
+		state := registrar.State[source]
+		var inode, device uint64
+		if state.FileStateOS != nil {
+			inode, device = state.FileStateOS.Inode, state.FileStateOS.Device
+		}
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\n", state.Offset, inode, device, source)
+	}
+	return w.Flush()
+}
+
+// registryResetCommand 从 registry 文件中删除指定的文件，filebeat 下次启动时会把它们当做新文件处理
+func registryResetCommand(b *beat.Beat, fb *filebeat.Filebeat, args []string) error {
+	registrar, err := loadRegistrar(fb)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	if len(args) == 0 {
+		removed = len(registrar.State)
+		registrar.State = map[string]*input.FileState{}
+	} else {
+		for _, source := range args {
+			if _, ok := registrar.State[source]; !ok {
+				return fmt.Errorf("File not found in registry: %s", source)
+			}
+			delete(registrar.State, source)
+			removed++
+		}
+	}
+
+	if err := registrar.Save(); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d file(s) from registry %s\n", removed, fb.FbConfig.Filebeat.RegistryFile)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"gopkg.in/yaml.v2"
+	"testing"
+)
+
+func TestPruneEmpty(t *testing.T) {
+	var doc interface{}
+	input := `
+output:
+  elasticsearch:
+    hosts: []
+    index: ""
+    max_retries: 0
+    tls: {insecure: false, certificate: ""}
+  file: {path: ""}
+shipper:
+  name: null
+  tags: ["", a]
+`
+	if err := yaml.Unmarshal([]byte(input), &doc); err != nil {
+		t.Fatal(err)
+	}
+	out, err := yaml.Marshal(pruneEmpty(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `output:
+  elasticsearch:
+    max_retries: 0
+    tls:
+      insecure: false
+shipper:
+  tags:
+  - a
+`
+	if string(out) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out)
+	}
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"fmt"
+	"github.com/ssp4599815/beat/filebeat/harvester/encoding"
+	"github.com/ssp4599815/beat/libbeat/cfgfile"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError 描述配置文件中的一个错误，Line 为 0 表示无法确定错误所在的行
+// ValidationError is a single error found in a config file
+type ValidationError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e ValidationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+// ValidationErrors 包含一个配置文件中找到的所有错误，每个错误一行
+// ValidationErrors collects all errors found in a config file
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// fileConfig 是配置文件的完整结构。filebeat 部分会被严格的校验，其他部分由 libbeat 负责解析
+type fileConfig struct {
+	Filebeat FilebeatConfig
+	Output   interface{}
+	Shipper  interface{}
+	Logging  interface{}
+	HTTP     interface{}
+}
+
+// yaml 的错误信息格式为 "line 12: field foo not found in type ..." 或者 "yaml: line 3: ..."
+var yamlLineError = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// ValidateFile 读取并严格校验一个配置文件，文件中的变量会先被替换
+// ValidateFile reads and validates the given config file. See Validate
+func ValidateFile(file string) error {
+	content, err := cfgfile.Load(file)
+	if err != nil {
+		return err
+	}
+	return Validate(file, content)
+}
+
+// 校验配置文件的内容:
+// - 不认识的配置项 (比如拼错的配置项) 会被当做错误，而不是直接忽略掉
+// - 所有的时间间隔和编码格式都会在 prospector 启动之前进行检查
+// Validate checks the content of a config file. Unknown keys, invalid
+// durations and encodings are reported with the file and line they are found at
+func Validate(file string, content []byte) error {
+	var errs ValidationErrors
+
+	config := &fileConfig{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		errs = append(errs, yamlErrors(file, err)...)
+
+		// syntax errors leave nothing to check the values of
+		if _, ok := err.(*yaml.TypeError); !ok {
+			return errs
+		}
+	}
+
+	v := newValidator(file, content)
+	v.validateFilebeat(&config.Filebeat)
+	errs = append(errs, v.errs...)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// yamlErrors 将 yaml 的错误信息转换为 ValidationError
+func yamlErrors(file string, err error) ValidationErrors {
+	var msgs []string
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		msgs = typeErr.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+
+	errs := make(ValidationErrors, 0, len(msgs))
+	for _, msg := range msgs {
+		verr := ValidationError{File: file, Msg: msg}
+		if match := yamlLineError.FindStringSubmatch(msg); match != nil {
+			verr.Line, _ = strconv.Atoi(match[1])
+			verr.Msg = match[2]
+		}
+		errs = append(errs, verr)
+	}
+	return errs
+}
+
+// validator 检查配置项的值，并在原始文件中查找出错的配置项所在的行
+type validator struct {
+	file  string
+	lines []string
+	last  map[string]int // key:value -> line index of the last match, so repeated values map to successive lines
+	errs  ValidationErrors
+}
+
+func newValidator(file string, content []byte) *validator {
+	return &validator{
+		file:  file,
+		lines: strings.Split(string(content), "\n"),
+		last:  map[string]int{},
+	}
+}
+
+func (v *validator) validateFilebeat(config *FilebeatConfig) {
+	v.checkDuration("idle_timeout", config.IdleTimeout)
+	v.checkDuration("reload_frequency", config.ReloadFrequency)
+
+	for i := range config.Prospectors {
+		v.validateProspector(i, &config.Prospectors[i])
+	}
+}
+
+func (v *validator) validateProspector(index int, config *ProspectorConfig) {
+	if len(config.Paths) == 0 {
+		v.errs = append(v.errs, ValidationError{
+			File: v.file,
+			Msg:  fmt.Sprintf("prospector %d has no paths", index),
+		})
+	}
+
+	v.checkDuration("ignore_older", config.IgnoreOlder)
+	v.checkDuration("scan_frequency", config.ScanFrequency)
+
+	harvester := &config.Harvester
+	v.checkDuration("backoff", harvester.Backoff)
+	v.checkDuration("max_backoff", harvester.MaxBackoff)
+	v.checkDuration("partial_line_waiting", harvester.PartialLineWating)
+
+	if harvester.BackoffFactor < 0 {
+		v.addError("backoff_factor", strconv.Itoa(harvester.BackoffFactor),
+			"backoff_factor must be at least 1, got %d", harvester.BackoffFactor)
+	}
+
+	switch harvester.InputType {
+	case "", "log", "stdin":
+	default:
+		v.addError("input_type", harvester.InputType,
+			"unknown input_type '%s', must be one of log, stdin", harvester.InputType)
+	}
+
+	if codec, ok := encoding.FindEncoding(harvester.Encoding); !ok || codec == nil {
+		v.addError("encoding", harvester.Encoding, "unknown encoding '%s'", harvester.Encoding)
+	}
+}
+
+// checkDuration 检查时间间隔是否能被解析，并且不能是负数
+func (v *validator) checkDuration(key, value string) {
+	if value == "" {
+		return
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		v.addError(key, value, "invalid duration for %s '%s': %v", key, value, err)
+	} else if duration < 0 {
+		v.addError(key, value, "%s must not be negative, got %s", key, value)
+	}
+}
+
+func (v *validator) addError(key, value, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{
+		File: v.file,
+		Line: v.findLine(key, value),
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+// findLine 返回 "key: value" 在文件中所在的行号 (从 1 开始)，找不到返回 0。
+// 同样的配置项出现多次时，依次返回后面的行
+func (v *validator) findLine(key, value string) int {
+	id := key + ":" + value
+	start, ok := v.last[id]
+	if ok {
+		start++
+	}
+
+	for i := start; i < len(v.lines); i++ {
+		line := strings.TrimSpace(v.lines[i])
+		line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+		if !strings.HasPrefix(line, key+":") {
+			continue
+		}
+		if strings.Contains(line[len(key)+1:], value) {
+			v.last[id] = i
+			return i + 1
+		}
+	}
+
+	if ok {
+		// no more occurrences, report the last one found
+		return v.last[id] + 1
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate_ValidConfig(t *testing.T) {
+	content := `
+filebeat:
+  idle_timeout: 5s
+  prospectors:
+    - paths:
+        - /var/log/*.log
+      document_type: syslog
+      encoding: utf-8
+      ignore_older: 24h
+      partial_line_waiting: 2s
+output:
+  elasticsearch:
+    hosts: ["localhost:9200"]
+`
+	if err := Validate("filebeat.yml", []byte(content)); err != nil {
+		t.Fatalf("expected valid config, got: %v", err)
+	}
+}
+
+func TestValidate_UnknownKey(t *testing.T) {
+	content := `filebeat:
+  prospectors:
+    - paths:
+        - /var/log/*.log
+      ducoment_type: syslog
+`
+	err := Validate("filebeat.yml", []byte(content))
+	errs, ok := err.(ValidationErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("expected one validation error, got: %v", err)
+	}
+	if errs[0].Line != 5 || !strings.Contains(errs[0].Msg, "ducoment_type") {
+		t.Errorf("unexpected error: %v", errs[0])
+	}
+}
+
+func TestValidate_InvalidValues(t *testing.T) {
+	content := `filebeat:
+  prospectors:
+    - paths: [/var/log/a.log]
+      scan_frequency: 10
+    - paths: [/var/log/b.log]
+      scan_frequency: 10
+      encoding: no-such-encoding
+    - input_type: socket
+`
+	err := Validate("filebeat.yml", []byte(content))
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("expected validation errors, got: %v", err)
+	}
+
+	expected := []string{
+		"filebeat.yml:4: invalid duration for scan_frequency",
+		"filebeat.yml:6: invalid duration for scan_frequency",
+		"filebeat.yml:7: unknown encoding 'no-such-encoding'",
+		"filebeat.yml: prospector 2 has no paths",
+		"filebeat.yml:8: unknown input_type 'socket'",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got:\n%v", len(expected), errs)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(errs[i].Error(), prefix) {
+			t.Errorf("error %d: expected prefix %q, got %q", i, prefix, errs[i].Error())
+		}
+	}
+}
+
+func TestValidate_SyntaxError(t *testing.T) {
+	content := "filebeat:\n  prospectors: [\n"
+	err := Validate("filebeat.yml", []byte(content))
+	if _, ok := err.(ValidationErrors); !ok {
+		t.Fatalf("expected validation errors, got: %v", err)
+	}
+}
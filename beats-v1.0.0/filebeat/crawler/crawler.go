@@ -1,11 +1,12 @@
 package crawler
 
 import (
-	"fmt"
+	"errors"
 	"github.com/ssp4599815/beat/filebeat/config"
 	"github.com/ssp4599815/beat/filebeat/input"
-	"log"
+	"github.com/ssp4599815/beat/libbeat/logp"
 	"os"
+	"sync"
 )
 
 /*
@@ -22,8 +23,33 @@ import (
 
 // 负责具体的日志收集工作
 type Crawler struct {
-	Registrar *Registrar // Registrar object to parsist the stat  持久化文件的状态信息
-	running   bool       // 判断当前  crawer 是否正在运行，为后期 Stop() 操作留了一个 入口
+	Registrar   *Registrar            // Registrar object to parsist the stat  持久化文件的状态信息
+	running     bool                  // 判断当前  crawer 是否正在运行，为后期 Stop() 操作留了一个 入口
+	eventChan   chan *input.FileEvent // harvester 发送日志事件的通道，运行时新启动的 prospector 也使用它
+	prospectors []*Prospector         // 所有正在运行的 prospector
+	stopped     bool                  // Stop 之后不能再启动新的 prospector，否则它们不会被停止
+	mutex       sync.Mutex            // 保护 running、stopped 和 prospectors，配置重新加载时会并发的修改
+}
+
+var errCrawlerStopped = errors.New("crawler is stopped")
+
+// Prospectors 返回所有正在运行的 prospector
+func (crawler *Crawler) Prospectors() []*Prospector {
+	crawler.mutex.Lock()
+	defer crawler.mutex.Unlock()
+	return append([]*Prospector{}, crawler.prospectors...)
+}
+
+// State 返回所有正在运行的 prospector 的状态
+func (crawler *Crawler) State() []ProspectorState {
+	crawler.mutex.Lock()
+	defer crawler.mutex.Unlock()
+
+	states := make([]ProspectorState, 0, len(crawler.prospectors))
+	for _, prospector := range crawler.prospectors {
+		states = append(states, prospector.State())
+	}
+	return states
 }
 
 // 启动一个 crawler 来抓取日志信息
@@ -32,35 +58,30 @@ func (crawler *Crawler) Start(files []config.ProspectorConfig, eventChan chan *i
 	pendingProspectorCnt := 0
 
 	// Enable running
+	crawler.mutex.Lock()
 	crawler.running = true
+	crawler.eventChan = eventChan
+	crawler.mutex.Unlock()
 
 	// 探测 所有的prospect中定义的日志文件，并为其 启动一个 harvester
 	// Prospect the glob/paths given on the command line and launch harvesters
 	for _, fileconfig := range files {
-		fmt.Println("prospector", "File Configs: %v", fileconfig.Paths)
+		logp.Debug("prospector", "File Configs: %v", fileconfig.Paths)
 
-		// 初始化一个 Prospector
-		prospector := &Prospector{
-			ProspectorConfig: fileconfig,        // 这个是 每一个 要抓取的 日志文件信息
-			registrar:        crawler.Registrar, // 每一个要持久化的 registrar 信息
-		}
-
-		// 对每一个要收集的日志文件，来初始化一个 prospector，并初始化配置信息
-		err := prospector.Init()
+		// 初始化并启动一个 Prospector
+		_, err := crawler.StartProspector(fileconfig)
 		if err != nil {
-			fmt.Printf("Error in initing prospector: %s", err)
+			logp.Critical("Error in initing prospector: %s", err)
 			os.Exit(1)
 		}
 
-		// 每一个 prospector 启动一个 gorotion，并将读取到的日志 放到 eventChan 通道中，publisher会从 eventChan 中读取 fileevents
-		go prospector.Run(eventChan)
 		// 记录启动的 prospecter的个数
 		pendingProspectorCnt++
 	}
 
 	// Now determine which states we need to persist by pulling the events from the prospectors
 	// When we hit a nil source a prospector had finished so we decrease the expected events
-	log.Printf("prospector, Waiting for %d prospector to initialise", pendingProspectorCnt)
+	logp.Info("Waiting for %d prospectors to initialise", pendingProspectorCnt)
 
 	// 从通道中 获取要持久化的文件信息
 	for event := range crawler.Registrar.Persist {
@@ -69,20 +90,87 @@ func (crawler *Crawler) Start(files []config.ProspectorConfig, eventChan chan *i
 			pendingProspectorCnt--
 			// 如果要监听的日志文件为空，也就是没有要收集的日志了
 			if pendingProspectorCnt == 0 {
-				log.Printf("prospector, No pending prospectors.Finishing setup")
+				logp.Info("No pending prospectors. Finishing setup")
 				break
 			}
 			continue
 		}
-		crawler.Registrar.State[*event.Source] = event
-		log.Println("prospector, Registrar will re-save state for", *event.Source)
+		crawler.Registrar.setFileState(*event.Source, event)
+		logp.Debug("prospector", "Registrar will re-save state for %s", *event.Source)
 
 		// 如果 crawler 已经不再运行了，就退出
-		if !crawler.running {
+		if !crawler.isRunning() {
+			break
+		}
+	}
+}
+
+// StartProspector 根据配置初始化一个 prospector，并在一个新的 goroutine 中运行它。
+// crawler 启动之后调用时，prospector 完成首次扫描的信号由 registrar 接收
+// StartProspector inits and runs a prospector for the given config. The
+// prospector is tracked by the crawler until it is stopped again
+func (crawler *Crawler) StartProspector(prospectorConfig config.ProspectorConfig) (*Prospector, error) {
+	// 初始化一个 Prospector
+	prospector := &Prospector{
+		ProspectorConfig: prospectorConfig,  // 这个是 每一个 要抓取的 日志文件信息
+		registrar:        crawler.Registrar, // 每一个要持久化的 registrar 信息
+	}
+
+	// 对每一个要收集的日志文件，来初始化一个 prospector，并初始化配置信息
+	err := prospector.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	crawler.mutex.Lock()
+	if crawler.stopped {
+		crawler.mutex.Unlock()
+		return nil, errCrawlerStopped
+	}
+	crawler.prospectors = append(crawler.prospectors, prospector)
+	crawler.mutex.Unlock()
+
+	// 每一个 prospector 启动一个 gorotion，并将读取到的日志 放到 eventChan 通道中，publisher会从 eventChan 中读取 fileevents
+	// prospector.Stop() 会等待 Run 退出
+	prospector.wg.Add(1)
+	go func() {
+		defer prospector.wg.Done()
+		prospector.Run(crawler.eventChan)
+	}()
+	return prospector, nil
+}
+
+// StopProspector 停止 prospector 及其所有的 harvester，并且不再跟踪它
+// StopProspector stops the given prospector and waits for its harvesters to finish
+func (crawler *Crawler) StopProspector(prospector *Prospector) {
+	crawler.mutex.Lock()
+	for i, p := range crawler.prospectors {
+		if p == prospector {
+			crawler.prospectors = append(crawler.prospectors[:i], crawler.prospectors[i+1:]...)
 			break
 		}
 	}
+	crawler.mutex.Unlock()
+
+	prospector.Stop()
+}
+
+func (crawler *Crawler) isRunning() bool {
+	crawler.mutex.Lock()
+	defer crawler.mutex.Unlock()
+	return crawler.running
 }
+
+// Stop stops all running prospectors and their harvesters
 func (crawler *Crawler) Stop() {
-	// TODO: Properly stop prospectors and harvesters
+	crawler.mutex.Lock()
+	crawler.running = false
+	crawler.stopped = true
+	prospectors := crawler.prospectors
+	crawler.prospectors = nil
+	crawler.mutex.Unlock()
+
+	for _, prospector := range prospectors {
+		prospector.Stop()
+	}
 }
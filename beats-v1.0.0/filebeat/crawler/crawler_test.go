@@ -0,0 +1,18 @@
+package crawler
+
+import (
+	"testing"
+)
+
+func TestCrawler_StartProspectorAfterStop(t *testing.T) {
+	crawler := &Crawler{}
+	crawler.Stop()
+
+	// 配置重新加载和 Stop 同时进行时，Stop 之后启动的 prospector 不会再被停止，所以不能启动
+	if _, err := crawler.StartProspector(prospectorConfig("/var/log/*.log")); err != errCrawlerStopped {
+		t.Fatalf("expected errCrawlerStopped, got %v", err)
+	}
+	if prospectors := crawler.Prospectors(); len(prospectors) != 0 {
+		t.Errorf("unexpected prospectors %v", prospectors)
+	}
+}
@@ -5,8 +5,11 @@ import (
 	cfg "github.com/ssp4599815/beat/filebeat/config"
 	"github.com/ssp4599815/beat/filebeat/harvester"
 	"github.com/ssp4599815/beat/filebeat/input"
+	"github.com/ssp4599815/beat/libbeat/logp"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -19,6 +22,19 @@ type Prospector struct {
 	registrar        *Registrar             // 要持久化的文件信息
 	missingFiles     map[string]os.FileInfo // 要忽略的文件
 	running          bool                   // prospector是否运行的标志位，用于后续 Stop()操作
+	done             chan struct{}          // 关闭后 prospector 和它启动的 harvester 都会退出
+	wg               sync.WaitGroup         // 等待 Run 和所有 harvester 退出
+
+	paths           []string                          // 配置的路径，Run 会修改 ProspectorConfig.Paths
+	harvesters      map[*harvester.Harvester]struct{} // 正在运行的 harvester
+	harvestersMutex sync.Mutex
+}
+
+// ProspectorState 是 prospector 当前的状态，由 HTTP 监控接口输出
+type ProspectorState struct {
+	Paths      []string          `json:"paths"`
+	ConfigFile string            `json:"config_file,omitempty"`
+	Harvesters []harvester.State `json:"harvesters"`
 }
 
 type prospectorFileStat struct {
@@ -66,6 +82,9 @@ func (p *Prospector) setupProspectorConfig() error {
 
 	// Init file stat list
 	p.prospectorList = make(map[string]prospectorFileStat)
+	p.done = make(chan struct{})
+	p.paths = append([]string{}, config.Paths...)
+	p.harvesters = map[*harvester.Harvester]struct{}{}
 	return nil
 }
 
@@ -112,6 +131,12 @@ func (p *Prospector) setupHarvesterConfig() error {
 	if err != nil {
 		return err
 	}
+
+	// 等待一行写完整的最长时间，默认 5s
+	config.PartialLineWatingDuration, err = getConfigDuration(config.PartialLineWating, cfg.DefaultPartialLineWaiting, "partial_line_waiting")
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -124,20 +149,20 @@ func (p *Prospector) Run(spoolChan chan *input.FileEvent) {
 	// 首先 操作 所有的 标准输入
 	// Handle any "-" (stdin) paths ，处理任何文件，包括 标准输入
 	for i, path := range p.ProspectorConfig.Paths { // 遍历所有的 日志路径信息 path
-		fmt.Println("prospector Harvest path: ", path)
+		logp.Debug("prospector", "Harvest path: %s", path)
 
 		// 如果 是一个 标准输入
 		if path == "-" {
 			// Offset and Initial never get used when path is "-"
 			// 初始化 一个 harvestr
-			h, err := harvester.NewHarvester(p.ProspectorConfig, &p.ProspectorConfig.Harvester, path, nil, spoolChan)
+			h, err := harvester.NewHarvester(p.ProspectorConfig, &p.ProspectorConfig.Harvester, path, nil, spoolChan, p.done)
 			if err != nil {
-				fmt.Println("Error initializing harvester: ", err)
+				logp.Err("Error initializing harvester: %v", err)
 				return
 			}
 
 			// 开启一个 goroutine 进行日志收集
-			h.Start()
+			p.startHarvester(h)
 
 			// Remove it from the file list
 			p.ProspectorConfig.Paths = append(p.ProspectorConfig.Paths[:i], p.ProspectorConfig.Paths[i+1:]...)
@@ -171,8 +196,13 @@ func (p *Prospector) Run(spoolChan chan *input.FileEvent) {
 		p.lastscan = newlastscan
 
 		// Defer next scan for the defined scanFrequency
-		time.Sleep(p.ProspectorConfig.ScanFrequencyDuration)
-		fmt.Println("prospector, Start next scan")
+		select {
+		case <-time.After(p.ProspectorConfig.ScanFrequencyDuration):
+		case <-p.done:
+			logp.Info("Prospector stopped: %v", p.ProspectorConfig.Paths)
+			return
+		}
+		logp.Debug("prospector", "Start next scan")
 
 		// Clear out files that disappeared and we've stopped harvesting
 		for file, lastinfo := range p.prospectorList {
@@ -187,16 +217,73 @@ func (p *Prospector) Run(spoolChan chan *input.FileEvent) {
 	}
 }
 
+// 停止 prospector，并等待它启动的所有 harvester 退出。
+// harvester 退出前记录到 registrar 中的 offset 会被重新启动的 prospector 使用
+// Stop stops the prospector and waits until all its harvesters are finished
+func (p *Prospector) Stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// newHarvester 创建一个收集 file 的 harvester，退出时将 offset 发送到 signal 中
+func (p *Prospector) newHarvester(file string, signal chan int64, output chan *input.FileEvent) (*harvester.Harvester, error) {
+	h, err := harvester.NewHarvester(
+		p.ProspectorConfig, &p.ProspectorConfig.Harvester,
+		file, signal, output, p.done)
+	if err != nil {
+		logp.Err("Error initializing harvester: %v", err)
+	}
+	return h, err
+}
+
+// startHarvester 在一个新的 goroutine 中启动 harvester，Stop() 会等待它退出
+func (p *Prospector) startHarvester(h *harvester.Harvester) {
+	p.harvestersMutex.Lock()
+	p.harvesters[h] = struct{}{}
+	p.harvestersMutex.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() {
+			p.harvestersMutex.Lock()
+			delete(p.harvesters, h)
+			p.harvestersMutex.Unlock()
+		}()
+		h.Harvest()
+	}()
+}
+
+// State 返回 prospector 和它正在运行的 harvester 的状态，harvester 按照文件路径排序
+func (p *Prospector) State() ProspectorState {
+	state := ProspectorState{
+		Paths:      p.paths,
+		ConfigFile: p.ProspectorConfig.ConfigFile,
+		Harvesters: []harvester.State{},
+	}
+
+	p.harvestersMutex.Lock()
+	for h := range p.harvesters {
+		state.Harvesters = append(state.Harvesters, h.State())
+	}
+	p.harvestersMutex.Unlock()
+
+	sort.Slice(state.Harvesters, func(i, j int) bool {
+		return state.Harvesters[i].Source < state.Harvesters[j].Source
+	})
+	return state
+}
+
 // 扫描指定的李静，找出所有的要收集的日志文件，然后进行核查，并启动一个 harvester 来收集日志
 // Scans the specific path which can be a glob (/**/**/*.log)
 // For all found files it is checked if a harvester should be started
 func (p *Prospector) scan(path string, output chan *input.FileEvent) {
-	fmt.Println("prospector,scan path ", path)
+	logp.Debug("prospector", "scan path %s", path)
 	// 获取path 下面的所有文件
 	// Evaluate（评估） the path as a wildcards(通配符)/shell glob
 	matches, err := filepath.Glob(path)
 	if err != nil {
-		fmt.Printf("prospector, glob(%s) failed: %v", path, err)
+		logp.Err("glob(%s) failed: %v", path, err)
 		return
 	}
 	p.missingFiles = map[string]os.FileInfo{}
@@ -204,13 +291,13 @@ func (p *Prospector) scan(path string, output chan *input.FileEvent) {
 	//  检测通配符下面的文件是否需要启动一个 harvester
 	// check any matched files to see if we need to start a harvester
 	for _, file := range matches {
-		fmt.Println("prospector, Check file for harvesting: ", file)
+		logp.Debug("prospector", "Check file for harvesting: %s", file)
 
 		// 获取要收集日志文件的状态
 		// Stat the file, following any symlinks
 		fileinfo, err := os.Stat(file)
 		if err != nil {
-			fmt.Printf("prospector, stat(%s) failed: %s", file, err)
+			logp.Err("stat(%s) failed: %s", file, err)
 			continue
 		}
 
@@ -221,7 +308,7 @@ func (p *Prospector) scan(path string, output chan *input.FileEvent) {
 
 		// 跳过目录文件
 		if newFile.FileInfo.IsDir() {
-			fmt.Println("prospector, Skipping directory: ", file)
+			logp.Info("Skipping directory: %s", file)
 			continue
 		}
 
@@ -261,14 +348,11 @@ func (p *Prospector) scan(path string, output chan *input.FileEvent) {
 // Check if harvester for new file has to be started
 // For a new file the following options exist:
 func (p *Prospector) checkNewFile(newinfo *prospectorFileStat, file string, output chan *input.FileEvent) {
-	fmt.Println("prospector, Start harvesting unknown file: ", file)
+	logp.Debug("prospector", "Start harvesting unknown file: %s", file)
 
 	// Init harvester with info
-	h, err := harvester.NewHarvester(
-		p.ProspectorConfig, &p.ProspectorConfig.Harvester,
-		file, newinfo.Harvester, output)
+	h, err := p.newHarvester(file, newinfo.Harvester, output)
 	if err != nil {
-		fmt.Println("Error initializing harvester: ", err)
 		return
 	}
 
@@ -277,7 +361,7 @@ func (p *Prospector) checkNewFile(newinfo *prospectorFileStat, file string, outp
 	// This ensures we don't skip genuine creations with dead times lass than 10s
 
 	if newinfo.Fileinfo.ModTime().Before(p.lastscan) && time.Since(newinfo.Fileinfo.ModTime()) > p.ProspectorConfig.IgnoreOlderDruation {
-		fmt.Println("prospector, Fetching old state of file to resume: ", file)
+		logp.Debug("prospector", "Fetching old state of file to resume: %s", file)
 
 		// Call crawler if there exists a state for the given file
 		offset, resuming := p.registrar.fetchState(file, newinfo.Fileinfo)
@@ -286,20 +370,20 @@ func (p *Prospector) checkNewFile(newinfo *prospectorFileStat, file string, outp
 		// This is safe as the harvester,once it hits the EOF and a timeout, will stop harvesting
 		// Once we detect changes again we can resume another harvester again -this keep number of go routines to a minimum
 		if resuming { // 如果是一个老文件
-			fmt.Println("prospector, Resuming harvester on a previously harvested file: ", file)
+			logp.Debug("prospector", "Resuming harvester on a previously harvested file: %s", file)
 
 			h.Offset = offset
-			h.Start()
+			p.startHarvester(h)
 		} else {
-			fmt.Printf("prospector, Skipping file (older than ignore older of %v, %v) : %s",
+			logp.Debug("prospector", "Skipping file (older than ignore older of %v, %v): %s",
 				p.ProspectorConfig.IgnoreOlderDruation,
 				time.Since(newinfo.Fileinfo.ModTime()),
 				file)
 			newinfo.Harvester <- newinfo.Fileinfo.Size()
 		}
-	} else if previousFile, err := p.getPreviousFile(file, newinfo.Fileinfo); err != nil {
-		fmt.Printf("Prospector, File rename wo detected: %s -> %s", previousFile, file)
-		fmt.Printf("prospector, Launching harvester on renamed file: %s", file)
+	} else if previousFile, err := p.getPreviousFile(file, newinfo.Fileinfo); err == nil {
+		logp.Debug("prospector", "File rename was detected: %s -> %s", previousFile, file)
+		logp.Debug("prospector", "Launching harvester on renamed file: %s", file)
 		newinfo.Harvester = p.prospectorList[previousFile].Harvester
 
 	} else {
@@ -309,14 +393,14 @@ func (p *Prospector) checkNewFile(newinfo *prospectorFileStat, file string, outp
 
 		// Are we resuming a file or is this a completely new file?
 		if resuming {
-			fmt.Println("prospector, Resuming harvester on a previously harvested file: ", file)
+			logp.Debug("prospector", "Resuming harvester on a previously harvested file: %s", file)
 		} else {
-			fmt.Println("prospector, Launching harvester on new file: ", file)
+			logp.Debug("prospector", "Launching harvester on new file: %s", file)
 		}
 
 		// Launch the harvester
 		h.Offset = offset
-		h.Start()
+		p.startHarvester(h)
 	}
 }
 
@@ -353,30 +437,26 @@ func (p *Prospector) getPreviousFile(file string, info os.FileInfo) (string, err
 // ** New file is actually really a new file ,start a new harvester
 // ** Renamed file has a state, continue there
 func (p *Prospector) checkExistingFile(newinfo *prospectorFileStat, newFile *input.File, oldFile *input.File, file string, output chan *input.FileEvent) {
-	fmt.Println("prospector, Update existing file for harvesting: ", file)
+	logp.Debug("prospector", "Update existing file for harvesting: %s", file)
 
-	h, err := harvester.NewHarvester(
-		p.ProspectorConfig, &p.ProspectorConfig.Harvester,
-		file, newinfo.Harvester, output)
-	if err != nil {
-		fmt.Println("Error initializing harvester: ", err)
-		return
-	}
 	if !oldFile.IsSameFile(newFile) {
-		if previousFile, err := p.getPreviousFile(file, newinfo.Fileinfo); err != nil {
-			fmt.Printf("prospector, File rename was detected: %s -> %s", previousFile, file)
-			fmt.Printf("prospector, Launching harvester on renamed file: %s", file)
+		if previousFile, err := p.getPreviousFile(file, newinfo.Fileinfo); err == nil {
+			logp.Debug("prospector", "File rename was detected: %s -> %s", previousFile, file)
+			logp.Debug("prospector", "Launching harvester on renamed file: %s", file)
 
 			newinfo.Harvester = p.prospectorList[previousFile].Harvester
 		} else {
 			// File is not the same file we saw previously, it must have rotated and is a new file
-			fmt.Println("prospector, Launching harvester on rotated file: ", file)
+			logp.Debug("prospector", "Launching harvester on rotated file: %s", file)
 
 			// Forget about the previous harvester and let it continue on the old file - so start a new channel to use with the new harvester
 			newinfo.Harvester = make(chan int64, 1)
 
 			// Start a new harvester on the path
-			h.Start()
+			// harvester 需要在替换 channel 之后创建，不能和旧的 harvester 共用一个 channel
+			if h, err := p.newHarvester(file, newinfo.Harvester, output); err == nil {
+				p.startHarvester(h)
+			}
 		}
 
 		// Keep the old file in missingFiles so we don't rescan it if it was renamed and we've not yetreached the new filename
@@ -384,14 +464,18 @@ func (p *Prospector) checkExistingFile(newinfo *prospectorFileStat, newFile *inp
 		p.missingFiles[file] = oldFile.FileInfo
 	} else if len(newinfo.Harvester) != 0 && oldFile.FileInfo.ModTime() != newinfo.Fileinfo.ModTime() {
 		// Resume harvesting of an old file we've stopped harvesting from
-		fmt.Println("prospector, Resumeing harvester on an old file that was just modified: ", file)
+		logp.Debug("prospector", "Resuming harvester on an old file that was just modified: %s", file)
 
 		// Start a harvester on the path; an old file was just modified and it donen't hava a harvester
 		// The offset to continue from will be stroed in the harvester channel - so take that to use and also clear the channel
+		h, err := p.newHarvester(file, newinfo.Harvester, output)
+		if err != nil {
+			return
+		}
 		h.Offset = <-newinfo.Harvester
-		h.Start()
+		p.startHarvester(h)
 	} else {
-		fmt.Println("prospector, Not harvesting, file didn't change: ", file)
+		logp.Debug("prospector", "Not harvesting, file didn't change: %s", file)
 	}
 }
 
@@ -406,10 +490,10 @@ func getConfigDuration(config string, duration time.Duration, name string) (time
 		var err error
 		duration, err = time.ParseDuration(config)
 		if err != nil {
-			fmt.Printf("Failed to parse %s value '%s'. Error was： %s\n", name, config, err)
+			logp.Err("Failed to parse %s value '%s'. Error was: %s", name, config, err)
 			return 0, err
 		}
 	}
-	fmt.Printf("prospector  Set %s duration to %s", name, duration)
+	logp.Info("Set %s duration to %s", name, duration)
 	return duration, nil
 }
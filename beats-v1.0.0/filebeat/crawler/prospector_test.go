@@ -0,0 +1,149 @@
+package crawler
+
+import (
+	"github.com/ssp4599815/beat/filebeat/config"
+	"github.com/ssp4599815/beat/filebeat/input"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCrawler 创建一个 crawler，它的 registrar 在 dir 中保存状态并且已经在运行了。
+// 返回的函数停止所有的 prospector 和 registrar，并等待 registry 文件写入完成
+func newTestCrawler(t *testing.T, dir string) (*Crawler, chan *input.FileEvent, func()) {
+	registrar, err := NewRegistrar(filepath.Join(dir, "registry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registrar.LoadState()
+
+	registrarDone := make(chan struct{})
+	go func() {
+		defer close(registrarDone)
+		registrar.Run()
+	}()
+
+	events := make(chan *input.FileEvent, 100)
+	crawler := &Crawler{Registrar: registrar, eventChan: events}
+	stop := func() {
+		crawler.Stop()
+		registrar.Stop()
+		<-registrarDone
+	}
+	return crawler, events, stop
+}
+
+func prospectorConfig(paths ...string) config.ProspectorConfig {
+	return config.ProspectorConfig{Paths: paths, ScanFrequency: "10ms"}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nextEvent 等待 harvester 读取到的下一行
+func nextEvent(t *testing.T, events chan *input.FileEvent) *input.FileEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return nil
+	}
+}
+
+// stopWithin 在 timeout 之内停止 prospector，否则测试失败
+func stopWithin(t *testing.T, stop func(), timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		t.Fatal("prospector did not stop")
+	}
+}
+
+func TestProspector_StopAfterRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	crawler, events, stop := newTestCrawler(t, dir)
+	defer stop()
+
+	logFile := filepath.Join(dir, "test.log")
+	writeFile(t, logFile, "before rotation\n")
+	prospector, err := crawler.StartProspector(prospectorConfig(filepath.Join(dir, "*.log")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, events); *event.Text != "before rotation" {
+		t.Fatalf("unexpected line %q", *event.Text)
+	}
+
+	// 旧的 harvester 继续读取重命名之后的文件，新的文件由另一个 harvester 读取
+	if err := os.Rename(logFile, logFile+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, logFile, "after rotation\n")
+	if event := nextEvent(t, events); *event.Text != "after rotation" {
+		t.Fatalf("unexpected line %q", *event.Text)
+	}
+	if harvesters := prospector.State().Harvesters; len(harvesters) != 2 {
+		t.Fatalf("expected 2 harvesters, got %v", harvesters)
+	}
+
+	stopWithin(t, func() { crawler.StopProspector(prospector) }, 5*time.Second)
+}
+
+func TestProspector_ResumeFromRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prospector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "test.log")
+	writeFile(t, logFile, "first\nsecond\n")
+
+	// 第一次运行只有第一行被发送成功
+	crawler, events, stop := newTestCrawler(t, dir)
+	if _, err := crawler.StartProspector(prospectorConfig(logFile)); err != nil {
+		t.Fatal(err)
+	}
+	first := nextEvent(t, events)
+	if *first.Text != "first" {
+		t.Fatalf("unexpected line %q", *first.Text)
+	}
+	crawler.Registrar.Channel <- []*input.FileEvent{first}
+	nextEvent(t, events)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if state, ok := crawler.Registrar.GetFileState(logFile); ok && state.Offset == int64(len("first\n")) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("offset of the first line not persisted")
+		}
+	}
+	stopWithin(t, stop, 5*time.Second)
+
+	// 重新启动之后从 registry 中记录的 offset 继续读取
+	crawler, events, stop = newTestCrawler(t, dir)
+	defer stop()
+	if _, err := crawler.StartProspector(prospectorConfig(logFile)); err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, events)
+	if *event.Text != "second" || event.Offset != int64(len("first\n")) {
+		t.Errorf("expected to resume at second line, got %q at offset %d", *event.Text, event.Offset)
+	}
+}
@@ -6,8 +6,18 @@ import (
 	cfg "github.com/ssp4599815/beat/filebeat/config"
 	"github.com/ssp4599815/beat/filebeat/input"
 	. "github.com/ssp4599815/beat/filebeat/input"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/monitoring"
 	"os"
 	"path/filepath"
+	"sync"
+)
+
+// registrar 的内部指标
+var (
+	registryWrites      = monitoring.NewCounter("filebeat.registrar.writes")
+	registryWriteErrors = monitoring.NewCounter("filebeat.registrar.write_errors")
+	registryStates      = monitoring.NewGauge("filebeat.registrar.states")
 )
 
 // 用于记录日志读取时候的状态信息
@@ -15,7 +25,8 @@ type Registrar struct {
 	// Registry 文件的路径位置
 	registryFile string // path to the Registry file
 	// 文件路径：文件状态 的对应关系
-	State map[string]*FileState // map with all file paths inside and the corresponding（一致的）state
+	State      map[string]*FileState // map with all file paths inside and the corresponding（一致的）state
+	stateMutex sync.Mutex            // prospectors started by the config reloader read State while Run writes it
 	//持久化文件状态用的一个管道，获取从  prospector 和 crawler 通道中的信息，然后发给 FileStates 来进行持久化
 	Persist chan *input.FileState // channel used by the prospector and crawler to send FileStates to be persisted（持久化）
 	running bool                  // 用来判断当前 registrar 是否在运行
@@ -72,7 +83,7 @@ func (r *Registrar) Init() error {
 func (r *Registrar) LoadState() {
 	if existing, e := os.Open(r.registryFile); e == nil {
 		defer existing.Close()
-		fmt.Printf("Loading registrar data from %s", r.registryFile)
+		logp.Info("Loading registrar data from %s", r.registryFile)
 
 		// 将持久化的文件状态信息（json格式） 解析为 map 对象
 		decoder := json.NewDecoder(existing)
@@ -80,9 +91,95 @@ func (r *Registrar) LoadState() {
 	}
 }
 
+// 启动 registrar，持续的接收 prospector 和 publisher 发送过来的文件状态，并写入 registry 文件
+// Run persists the states sent by the prospectors and the offsets of the
+// published events until Stop is called
+func (r *Registrar) Run() {
+	logp.Info("Starting Registrar")
+
+	r.running = true
+
+	// Writes registry on shutdown
+	defer r.writeRegistry()
+
+	for {
+		select {
+		case <-r.done:
+			logp.Info("Ending Registrar")
+			return
+		// Treats new log files to persist with higher priority then new events
+		case state := <-r.Persist:
+			// 新启动的 prospector 完成首次扫描时会发送一个空的 state，这里直接忽略
+			// prospectors (re)started after setup signal their first scan with an empty state
+			if state.Source == nil {
+				continue
+			}
+			r.setFileState(*state.Source, state)
+			logp.Debug("registrar", "Registrar will re-save state for %s", *state.Source)
+		case events := <-r.Channel:
+			r.processEvents(events)
+		}
+
+		if e := r.writeRegistry(); e != nil {
+			logp.Err("Writing of registry returned error: %v. Continuing..", e)
+		}
+	}
+}
+
 // 停止 registrar
 func (r *Registrar) Stop() {
+	logp.Info("Stopping Registrar")
+	r.running = false
+	close(r.done)
+	// Note: don't block using waitGroup, cause this method is run by async signal handler
+}
+
+// Save 将当前的状态写入 registry 文件，用于在 filebeat 没有运行时离线修改 registry
+// Save writes the current state to the registry file
+func (r *Registrar) Save() error {
+	return r.writeRegistry()
+}
+
+// processEvents 将已经发送成功的日志事件的 offset 更新到 State 中
+func (r *Registrar) processEvents(events []*FileEvent) {
+	for _, event := range events {
+		// skip stdin and partial lines, their offset can't be resumed from
+		if *event.Source == "-" || event.IsPartial {
+			continue
+		}
+		r.setFileState(*event.Source, event.GetState())
+	}
+}
+
+// writeRegistry 将当前所有文件的状态写入到 registry 文件中。
+// 先写入一个临时文件，然后再重命名，防止写入一半时进程退出导致文件损坏
+func (r *Registrar) writeRegistry() (err error) {
+	r.stateMutex.Lock()
+	defer r.stateMutex.Unlock()
+
+	registryStates.Set(int64(len(r.State)))
+	defer func() {
+		if err != nil {
+			registryWriteErrors.Inc()
+		} else {
+			registryWrites.Inc()
+		}
+	}()
 
+	tempfile := r.registryFile + ".new"
+	file, err := os.Create(tempfile)
+	if err != nil {
+		return fmt.Errorf("Failed to create tempfile (%s) for writing: %v", tempfile, err)
+	}
+
+	encoder := json.NewEncoder(file)
+	err = encoder.Encode(r.State)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("Failed to encode registry state: %v", err)
+	}
+
+	return os.Rename(tempfile, r.registryFile)
 }
 
 // 获取文件的状态 offset
@@ -93,18 +190,18 @@ func (r *Registrar) fetchState(filePath string, fileInfo os.FileInfo) (int64, bo
 	lastState, isFound := r.GetFileState(filePath)
 
 	if isFound && input.IsSameFile(filePath, fileInfo) {
-		fmt.Println("registar, Same file as before found, Fetch the state and persist it.")
+		logp.Debug("registrar", "Same file as before found. Fetch the state and persist it.")
 		// We're resuming - throw the last state back downstaream so wo resave it
 		// And retuen the offset - also force harvest in case the file is old and we're about to skip it
 		r.Persist <- lastState
 		return lastState.Offset, true
 	}
 
-	if previous, err := r.getPreviousFile(filePath, fileInfo); err != nil {
+	if previous, err := r.getPreviousFile(filePath, fileInfo); err == nil {
 		// File has rotated betewwn shutdown and startup
 		// We return last state downstream, with a modified event source with the new file name
 		// And return the offset - also force harvest in case the file is old and we're about to skip it
-		fmt.Printf("Detected rename of a previously harvested file: %s -> %s", previous, filePath)
+		logp.Info("Detected rename of a previously harvested file: %s -> %s", previous, filePath)
 
 		lastState, _ := r.GetFileState(previous)
 		lastState.Source = &filePath
@@ -113,23 +210,37 @@ func (r *Registrar) fetchState(filePath string, fileInfo os.FileInfo) (int64, bo
 	}
 
 	if isFound {
-		fmt.Println("Not resuming rotated file: ", filePath)
+		logp.Info("Not resuming rotated file: %s", filePath)
 	}
 	// New file so just start from an automatic position
 	return 0, false
 }
 
 func (r *Registrar) GetFileState(path string) (*FileState, bool) {
+	r.stateMutex.Lock()
+	defer r.stateMutex.Unlock()
+
 	state, exist := r.State[path]
 	return state, exist
 }
 
+func (r *Registrar) setFileState(path string, state *FileState) {
+	r.stateMutex.Lock()
+	defer r.stateMutex.Unlock()
+
+	r.State[path] = state
+}
+
 // 核查 registrar  是否一个新文件已经存在了，只是使用了不同的名称（也就是使用了同一个文件描述符）
 // 一旦一个老的文件被发现了，就直接返回该文件，如果不是就返回错误
 // getPreviousFile checks in the registrar if there is the newFile already exist with a different name
 // In case an old file is found, the path to the file is retuened, if not, an error is returned
 func (r *Registrar) getPreviousFile(newFilePath string, newFileInfo os.FileInfo) (string, error) {
 	newState := input.GetOSFileState(&newFileInfo)
+
+	r.stateMutex.Lock()
+	defer r.stateMutex.Unlock()
+
 	for oldFilePath, oldState := range r.State {
 
 		// skipping when path the same
@@ -139,7 +250,7 @@ func (r *Registrar) getPreviousFile(newFilePath string, newFileInfo os.FileInfo)
 
 		// Compare states
 		if newState.IsSame(oldState.FileStateOS) {
-			fmt.Printf("Old file with new name found: %s is no %s", oldFilePath, newFilePath)
+			logp.Debug("registrar", "Old file with new name found: %s is now %s", oldFilePath, newFilePath)
 			return oldFilePath, nil
 		}
 	}
@@ -0,0 +1,185 @@
+package crawler
+
+import (
+	"crypto/sha1"
+	"github.com/ssp4599815/beat/filebeat/config"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"io/ioutil"
+	"time"
+)
+
+/*
+ ConfigReloader 周期性的检查 config_dir 下的 *.yml 文件:
+ - 新增的文件: 启动文件中定义的 prospector
+ - 内容变化的文件: 停止旧的 prospector，按新的配置重新启动
+ - 删除的文件: 停止文件中定义的 prospector
+ 其他配置文件中的 prospector 不受影响。prospector 重启时会从 registrar 中
+ 获取每个文件已经发送成功的 offset，从而在正确的位置继续收集日志。
+*/
+
+// ConfigReloader watches config_dir and starts, restarts or stops the
+// prospectors of the files which were added, changed or removed
+type ConfigReloader struct {
+	crawler     *Crawler
+	configDir   string
+	frequency   time.Duration
+	configFiles map[string]*configFileState // config file path -> state
+	trigger     chan struct{}               // Trigger 发送的立即检查的请求
+	done        chan struct{}
+}
+
+// configFileState 记录一个配置文件的内容摘要和它启动的 prospector
+type configFileState struct {
+	hash        [sha1.Size]byte
+	prospectors []*Prospector
+}
+
+// NewConfigReloader 创建一个 ConfigReloader
+func NewConfigReloader(crawler *Crawler, configDir string, frequency time.Duration) *ConfigReloader {
+	return &ConfigReloader{
+		crawler:     crawler,
+		configDir:   configDir,
+		frequency:   frequency,
+		configFiles: map[string]*configFileState{},
+		trigger:     make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+// Run 接管 crawler 中已经从 config_dir 启动的 prospector，然后周期性的检查配置文件的变化，直到 Stop 被调用
+// Run takes over the prospectors started from config_dir and checks for
+// changes every frequency until Stop is called
+func (r *ConfigReloader) Run() {
+	logp.Info("Start watching config_dir %s for changes every %v", r.configDir, r.frequency)
+
+	// 记录启动时从 config_dir 加载的配置文件，它们的 prospector 已经由 crawler 启动了
+	r.crawler.mutex.Lock()
+	for _, prospector := range r.crawler.prospectors {
+		file := prospector.ProspectorConfig.ConfigFile
+		if file == "" {
+			continue
+		}
+		state, ok := r.configFiles[file]
+		if !ok {
+			hash, err := hashFile(file)
+			if err != nil {
+				logp.Err("Failed to read config file %s: %v", file, err)
+			}
+			state = &configFileState{hash: hash}
+			r.configFiles[file] = state
+		}
+		state.prospectors = append(state.prospectors, prospector)
+	}
+	r.crawler.mutex.Unlock()
+
+	for {
+		select {
+		case <-r.done:
+			logp.Info("Stop watching config_dir %s", r.configDir)
+			return
+		case <-time.After(r.frequency):
+		case <-r.trigger:
+		}
+
+		r.Reload()
+	}
+}
+
+// Trigger 让 Run 立即检查一次配置文件，而不是等到下一个检查周期。
+// Reload 只能在 Run 的 goroutine 中调用，其他 goroutine 需要使用 Trigger
+func (r *ConfigReloader) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+		// 已经有一个还没有处理的请求了
+	}
+}
+
+// Stop 停止检查配置文件，已经启动的 prospector 由 crawler 负责停止
+func (r *ConfigReloader) Stop() {
+	close(r.done)
+}
+
+// Reload 对比 config_dir 当前的配置文件和上一次加载的配置文件，只处理有变化的文件
+// Reload compares the files in config_dir with the last loaded state and
+// applies the changes
+func (r *ConfigReloader) Reload() {
+	files, err := config.GetConfigFiles(r.configDir)
+	if err != nil {
+		logp.Err("Could not read config_dir %s: %v", r.configDir, err)
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, file := range files {
+		seen[file] = true
+
+		hash, err := hashFile(file)
+		if err != nil {
+			// 文件可能正在被删除或替换，下一次检查时再处理
+			logp.Warn("Failed to read config file %s: %v", file, err)
+			continue
+		}
+
+		state, isKnown := r.configFiles[file]
+		if isKnown && state.hash == hash {
+			continue
+		}
+
+		prospectorConfigs, err := config.ReadProspectors(file)
+		if err != nil {
+			// 保留正在运行的 prospector，等待配置文件被修正
+			logp.Err("Keep running prospectors of %s, failed to load changed config: %v", file, err)
+			continue
+		}
+
+		if isKnown {
+			logp.Info("Config file changed, restarting its prospectors: %s", file)
+			r.stopProspectors(state)
+		} else {
+			logp.Info("New config file found, starting its prospectors: %s", file)
+		}
+
+		r.configFiles[file] = &configFileState{
+			hash:        hash,
+			prospectors: r.startProspectors(prospectorConfigs),
+		}
+	}
+
+	for file, state := range r.configFiles {
+		if seen[file] {
+			continue
+		}
+		logp.Info("Config file removed, stopping its prospectors: %s", file)
+		r.stopProspectors(state)
+		delete(r.configFiles, file)
+	}
+}
+
+func (r *ConfigReloader) startProspectors(configs []config.ProspectorConfig) []*Prospector {
+	prospectors := make([]*Prospector, 0, len(configs))
+	for _, prospectorConfig := range configs {
+		prospector, err := r.crawler.StartProspector(prospectorConfig)
+		if err != nil {
+			logp.Err("Error in initing prospector of %s: %s", prospectorConfig.ConfigFile, err)
+			continue
+		}
+		prospectors = append(prospectors, prospector)
+	}
+	return prospectors
+}
+
+func (r *ConfigReloader) stopProspectors(state *configFileState) {
+	for _, prospector := range state.prospectors {
+		r.crawler.StopProspector(prospector)
+	}
+}
+
+// hashFile 计算配置文件内容的摘要，用来判断文件是否被修改过
+func hashFile(file string) ([sha1.Size]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return [sha1.Size]byte{}, err
+	}
+	return sha1.Sum(content), nil
+}
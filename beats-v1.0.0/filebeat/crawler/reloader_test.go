@@ -0,0 +1,83 @@
+package crawler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, path string, logPath string) {
+	writeFile(t, path, "filebeat:\n  prospectors:\n    - paths:\n        - "+logPath+"\n      scan_frequency: 10ms\n")
+}
+
+// prospectorsByConfigFile 返回 crawler 中正在运行的 prospector，以配置文件为 key
+func prospectorsByConfigFile(t *testing.T, crawler *Crawler) map[string]*Prospector {
+	prospectors := map[string]*Prospector{}
+	for _, prospector := range crawler.Prospectors() {
+		file := prospector.ProspectorConfig.ConfigFile
+		if _, ok := prospectors[file]; ok {
+			t.Fatalf("more than one prospector of %s", file)
+		}
+		prospectors[file] = prospector
+	}
+	return prospectors
+}
+
+func TestConfigReloader_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	crawler, _, stop := newTestCrawler(t, dir)
+	defer stop()
+
+	configDir := filepath.Join(dir, "conf.d")
+	if err := os.Mkdir(configDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	a, b := filepath.Join(configDir, "a.yml"), filepath.Join(configDir, "b.yml")
+	reloader := NewConfigReloader(crawler, configDir, time.Hour)
+
+	// 新增的配置文件
+	writeConfig(t, a, "/var/log/a.log")
+	reloader.Reload()
+	writeConfig(t, b, "/var/log/b.log")
+	reloader.Reload()
+
+	prospectors := prospectorsByConfigFile(t, crawler)
+	if len(prospectors) != 2 || prospectors[a] == nil || prospectors[b] == nil {
+		t.Fatalf("unexpected prospectors %v", prospectors)
+	}
+	oldA, oldB := prospectors[a], prospectors[b]
+
+	// 没有变化的文件不会重启，修改过的文件按新的配置重启
+	writeConfig(t, a, "/var/log/a2.log")
+	reloader.Reload()
+	prospectors = prospectorsByConfigFile(t, crawler)
+	if prospectors[b] != oldB {
+		t.Error("unchanged config file restarted its prospector")
+	}
+	if prospectors[a] == oldA || prospectors[a].paths[0] != "/var/log/a2.log" {
+		t.Errorf("changed config file not reloaded: %v", prospectors[a].paths)
+	}
+
+	// 错误的配置不会停止正在运行的 prospector
+	writeFile(t, b, "filebeat:\n  prospectors:\n    - pahts: [/var/log/b.log]\n")
+	reloader.Reload()
+	if prospectors = prospectorsByConfigFile(t, crawler); prospectors[b] != oldB {
+		t.Error("invalid config file stopped its prospector")
+	}
+
+	// 删除的配置文件
+	if err := os.Remove(a); err != nil {
+		t.Fatal(err)
+	}
+	reloader.Reload()
+	if prospectors = prospectorsByConfigFile(t, crawler); len(prospectors) != 1 || prospectors[b] != oldB {
+		t.Errorf("unexpected prospectors after removing %s: %v", a, prospectors)
+	}
+}
@@ -1,11 +1,10 @@
-package harvester
+package encoding
 
 import (
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/encoding/htmlindex"
 	"golang.org/x/text/encoding/simplifiedchinese"
-	"golang.org/x/text/transform"
 	"strings"
 )
 
@@ -40,16 +39,17 @@ var Plain = encoding.Nop
 //// See: http://encoding.spec.whatwg.org/#replacement
 type utf8Encoding struct{}
 
-func (utf8Encoding) NewDecoder() transform.Transformer {
-	return encoding.Replacement.NewEncoder()
+func (utf8Encoding) NewDecoder() *encoding.Decoder {
+	return &encoding.Decoder{Transformer: encoding.Replacement.NewEncoder()}
 }
 
-func (utf8Encoding) NewEncoder() transform.Transformer {
+func (utf8Encoding) NewEncoder() *encoding.Encoder {
 	return encoding.Replacement.NewEncoder()
 }
 
-// Find returns
-func findEncoding(name string) (encoding.Encoding, bool) {
+// FindEncoding returns the encoding registered for name. The empty name
+// selects the plain (nop) encoding
+func FindEncoding(name string) (encoding.Encoding, bool) {
 	if name == "" {
 		return Plain, true
 	}
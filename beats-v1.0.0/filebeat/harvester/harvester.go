@@ -3,12 +3,25 @@ package harvester
 import (
 	"github.com/ssp4599815/beat/filebeat/config"
 	"github.com/ssp4599815/beat/filebeat/input"
+	"github.com/ssp4599815/beat/libbeat/monitoring"
 	"golang.org/x/text/encoding"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
+// harvester 的内部指标
+var (
+	harvesterOpenFiles = monitoring.NewGauge("filebeat.harvester.open_files")
+	harvesterLinesRead = monitoring.NewCounter("filebeat.harvester.lines_read")
+	harvesterBytesRead = monitoring.NewCounter("filebeat.harvester.bytes_read")
+)
+
 type Harvester struct {
+	// 供监控接口在其他 goroutine 中读取的状态，使用 atomic 访问，需要放在最前面保证 64 位对齐
+	startTime int64 // harvester 开始运行的时间 (UnixNano)，0 表示还没有运行
+	offset    int64 // Offset 的副本
+
 	Path             string                  // the file path to harvest
 	ProspectorConfig config.ProspectorConfig // prospector配置
 	Config           *config.HarvesterConfig // harvester配置
@@ -18,6 +31,28 @@ type Harvester struct {
 	encoding         encoding.Encoding       // 日志文件的编码格式
 	file             *os.File                // the file being watched  一个文件描述符，用于监听文件变化
 	backoff          time.Duration           // 定义Filebeat在达到EOF之后再次检查文件之间等待的时间
+	done             <-chan struct{}         // prospector 停止时关闭，harvester 随之退出
+}
+
+// State 是 harvester 当前的状态，由 HTTP 监控接口输出
+type State struct {
+	Source  string    `json:"source"`
+	Offset  int64     `json:"offset"`
+	Started time.Time `json:"started"`
+	Age     string    `json:"age"` // 已经运行的时间
+}
+
+// State 返回 harvester 当前的状态，可以在其他 goroutine 中调用
+func (h *Harvester) State() State {
+	state := State{
+		Source: h.Path,
+		Offset: atomic.LoadInt64(&h.offset),
+	}
+	if startTime := atomic.LoadInt64(&h.startTime); startTime != 0 {
+		state.Started = time.Unix(0, startTime)
+		state.Age = time.Since(state.Started).String()
+	}
+	return state
 }
 
 // Interface for the different harvester types
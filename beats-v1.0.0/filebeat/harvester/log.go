@@ -4,17 +4,21 @@ import (
 	"errors"
 	"fmt"
 	"github.com/ssp4599815/beat/filebeat/config"
+	"github.com/ssp4599815/beat/filebeat/harvester/encoding"
 	"github.com/ssp4599815/beat/filebeat/input"
+	"github.com/ssp4599815/beat/libbeat/logp"
 	"io"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
 // 创建一个新的 harvester,用来收集日志，并将收集到的日志 发动到 spooler 中
-func NewHarvester(prospectorCfg config.ProspectorConfig, cfg *config.HarvesterConfig, path string, signal chan int64, spooler chan *input.FileEvent) (*Harvester, error) {
+// done 被关闭时 harvester 会停止收集并退出
+func NewHarvester(prospectorCfg config.ProspectorConfig, cfg *config.HarvesterConfig, path string, signal chan int64, spooler chan *input.FileEvent, done <-chan struct{}) (*Harvester, error) {
 	// 获取日志的编码格式， utf-8 gbk....
-	encoding, ok := findEncoding(cfg.Encoding)
-	if !ok || encoding == nil {
+	codec, ok := encoding.FindEncoding(cfg.Encoding)
+	if !ok || codec == nil {
 		return nil, fmt.Errorf("unknown encoding('%v')", cfg.Encoding)
 	}
 
@@ -25,8 +29,9 @@ func NewHarvester(prospectorCfg config.ProspectorConfig, cfg *config.HarvesterCo
 		Config:           cfg,           // Harvester 配置
 		FinishChan:       signal,        // 接受关闭的信号的通道
 		SpoolerChan:      spooler,       // 将收集到的日志放到 spooler 中
-		encoding:         encoding,      // 文件的编码格式
+		encoding:         codec,         // 文件的编码格式
 		backoff:          prospectorCfg.Harvester.BackoffDuration,
+		done:             done,
 	}
 	return h, nil
 }
@@ -34,6 +39,9 @@ func NewHarvester(prospectorCfg config.ProspectorConfig, cfg *config.HarvesterCo
 // 一行一行的读取日志，并且将读取到的信息发送到 SpoolerChan 中
 // Log harvester reads files line by line and send events to the defined output
 func (h *Harvester) Harvest() {
+	atomic.StoreInt64(&h.startTime, time.Now().UnixNano())
+	atomic.StoreInt64(&h.offset, h.Offset)
+
 	// 打开 h.Path 下的文件，并获取该文件描述符给 h.file
 	err := h.open()
 
@@ -41,27 +49,38 @@ func (h *Harvester) Harvest() {
 	defer func() {
 		// on completion,push offset so we can continue where we left off if we relaunch on the same file
 		// 一旦完成，将当时文件的偏移量保存下来，使得重启后能读取到同样的文件位置
-		h.FinishChan <- h.Offset
+		// stdin harvesters have no finish channel
+		// prospector 停止后没有人再读取 FinishChan，不能阻塞在这里
+		if h.FinishChan != nil {
+			select {
+			case h.FinishChan <- h.Offset:
+			case <-h.done:
+			}
+		}
 		// Make sure file is closed as soon as harvester exits
 		_ = h.file.Close()
 	}()
 
 	if err != nil {
-		fmt.Println("Stop harvesting. Unexpected Error: ", err)
+		logp.Err("Stop Harvesting. Unexpected Error: %s", err)
 		return
 	}
+	harvesterOpenFiles.Inc()
+	defer harvesterOpenFiles.Dec()
+
 	// 获取文件的状态信息
 	info, err := h.file.Stat()
 	if err != nil {
-		fmt.Println(" Stop Harvesting. Unexpected Error: ", err)
+		logp.Err("Stop Harvesting. Unexpected Error: %s", err)
 		return
 	}
 
-	fmt.Println("Harvester started for file: ", h.Path)
+	logp.WithFields(logp.Fields{"source": h.Path, "offset": h.Offset}).Info("Harvester started")
 
 	// 每次启动的时候，都要初始化 offset 信息
 	// Load last offset from registrar
 	h.initOffset()
+	atomic.StoreInt64(&h.offset, h.Offset)
 
 	// 最近一次从 底层 reader (h.file) 读取字节的时间
 	// timeIn 实现了 io.Reader() 接口，可以使用 timeIn.Read() 来去读文件 h.file
@@ -70,7 +89,7 @@ func (h *Harvester) Harvest() {
 	// 创建一个 新的 LineReader 对象
 	reader, err := newLineReader(timeIn, h.encoding, h.Config.BufferSize)
 	if err != nil {
-		fmt.Printf("Stop Harvesting. Unexpected Error: %s", err)
+		logp.Err("Stop Harvesting. Unexpected Error: %s", err)
 		return
 	}
 
@@ -85,6 +104,12 @@ func (h *Harvester) Harvest() {
 	lastPartialLen := 0
 
 	for {
+		// prospector 已经停止了，harvester 也随之退出
+		if h.stopped() {
+			logp.WithFields(logp.Fields{"source": h.Path, "offset": h.Offset}).Info("Stop harvesting, prospector stopped")
+			return
+		}
+
 		// 获取 读取到的文本，读取到文本的大小
 		// isPartial 用来判断读取的文件是不是一个完整的文件
 		text, bytesRead, isPartial, err := readLine(reader, &timeIn.lastReadTime, h.Config.PartialLineWatingDuration)
@@ -93,7 +118,7 @@ func (h *Harvester) Harvest() {
 			// In case of err = io.EOF returns nil
 			err = h.handleReadlineError(lastReadTime, err)
 			if err != nil {
-				fmt.Printf("File reading error. Stopping harvester. Error: %s", err)
+				logp.WithFields(logp.Fields{"source": h.Path, "offset": h.Offset, "error": err}).Err("File reading error. Stopping harvester")
 				return
 			}
 			continue
@@ -131,10 +156,18 @@ func (h *Harvester) Harvest() {
 
 		if !isPartial {
 			h.Offset += int64(bytesRead) // Update offset if complete line has been processed
+			atomic.StoreInt64(&h.offset, h.Offset)
+			harvesterLinesRead.Inc()
+			harvesterBytesRead.Add(int64(bytesRead))
 		}
 
 		event.SetFieldsUnderRoot(h.Config.FieldsUnderRoot)
-		h.SpoolerChan <- event // ship the new event downstream
+
+		select {
+		case h.SpoolerChan <- event: // ship the new event downstream
+		case <-h.done:
+			return
+		}
 	}
 }
 
@@ -156,8 +189,12 @@ func (h *Harvester) open() error {
 		if err != nil {
 			// 如果打开失败，就 sleep 5秒后 继续打开文件，知道打开为止
 			// retry on failure
-			fmt.Printf("Failed opening %s: %s", h.Path, err)
-			time.Sleep(5 * time.Second)
+			logp.Err("Failed opening %s: %s", h.Path, err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-h.done:
+				return fmt.Errorf("harvester stopped before %s could be opened", h.Path)
+			}
 		} else {
 			break
 		}
@@ -199,11 +236,11 @@ func (h *Harvester) initOffset() {
 	offset, _ := h.file.Seek(0, io.SeekCurrent) // 获取当前位置的偏移量
 
 	if h.Offset > 0 {
-		fmt.Printf("harvester, harvest: %q position: %d (offset snapshot: %d)", h.Path, h.Offset, offset)
+		logp.Debug("harvester", "harvest: %q position: %d (offset snapshot: %d)", h.Path, h.Offset, offset)
 	} else if h.Config.TailFiles {
-		fmt.Printf("harvester, harvest: (tailing) %q (offset snapshot: %d)", h.Path, offset)
+		logp.Debug("harvester", "harvest: (tailing) %q (offset snapshot: %d)", h.Path, offset)
 	} else {
-		fmt.Printf("harvester, harvest: %q (offset snapshot:%d)", h.Path, offset)
+		logp.Debug("harvester", "harvest: %q (offset snapshot: %d)", h.Path, offset)
 	}
 	h.Offset = offset // 将当前文件的偏移量 复制到  harvester.Offset 中,后面再读取的时候会使用
 }
@@ -215,6 +252,16 @@ func (h *Harvester) handleReadlineError(lastReadTime time.Time, e error) error {
 func (h *Harvester) Stop() {
 }
 
+// stopped 检查 prospector 是否已经要求 harvester 退出
+func (h *Harvester) stopped() bool {
+	select {
+	case <-h.done:
+		return true
+	default:
+		return false
+	}
+}
+
 // 公共函数
 /*** Utility Functions ***/
 
@@ -267,7 +314,7 @@ func isLine(line []byte) bool {
 // lineEndingChars returns the number of lines ending chars the given by array has
 // In case of Unix/Linux files, it is -1, incase of Windows mostly -2
 func lineEndingChars(line []byte) int {
-	if isLine(line) {
+	if !isLine(line) {
 		return 0
 	}
 	if line[len(line)-1] == '\n' { // Unix/Linux 每一行的结尾是 '\n'
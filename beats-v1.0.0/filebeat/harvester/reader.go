@@ -42,8 +42,8 @@ func (r *timeReader) Read(p []byte) (int, error) {
 	n := 0
 	// 用来读取空行的
 	for i := maxConsecutiveEmptyReads; i > 0; i-- {
-		n, err := r.reader.Read(p) // 将读取到的文件 放到 p 里面
-		if n > 0 { // 如果读取到文件 就跳出循环
+		n, err = r.reader.Read(p) // 将读取到的文件 放到 p 里面
+		if n > 0 {                // 如果读取到文件 就跳出循环
 			r.lastReadTime = time.Now()
 			break
 		}
@@ -163,6 +163,18 @@ func (l *lineReader) advance() error {
 	// found encoded byte sequence for '\n' in buffer
 	// -> decode input sequence into outBuffer
 	sz, err := l.decode(idx + len(l.nl))
+
+	// consume transformed bytes from input buffer
+	l.inBuffer.Advance(sz)
+	l.inBuffer.Reset()
+
+	// continue scanning input buffer from last position + 1
+	l.inOffset = idx + 1 - sz
+	if l.inOffset < 0 {
+		// fix inOffset if '\n' has encoding > 8bits + fill line has been decoded
+		l.inOffset = 0
+	}
+	return err
 }
 
 func (l *lineReader) decode(end int) (int, error) {
@@ -178,7 +190,18 @@ func (l *lineReader) decode(end int) (int, error) {
 		start += nSrc
 
 		l.outBuffer.Write(buffer[:nDst])
+		if err != nil {
+			// buffer 写满了，清空之后继续解码
+			if err == transform.ErrShortDst {
+				err = nil
+				continue
+			}
+			break
+		}
 	}
+
+	l.byteCount += start
+	return start, err
 }
 
 // partial returns current state of decoded input bytes and amount of bytes
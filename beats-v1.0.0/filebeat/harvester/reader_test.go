@@ -0,0 +1,40 @@
+package harvester
+
+import (
+	"github.com/ssp4599815/beat/filebeat/harvester/encoding"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLine(t *testing.T) {
+	// 缓冲区比一行小，一行需要多次读取
+	reader, err := newLineReader(strings.NewReader("first\nsecond\r\nthird"), encoding.Plain, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lastReadTime time.Time
+	expected := []struct {
+		text string
+		size int
+	}{
+		{"first", 6},
+		{"second", 8},
+	}
+	for _, e := range expected {
+		text, size, partial, err := readLine(reader, &lastReadTime, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != e.text || size != e.size || partial {
+			t.Errorf("expected %q (%d bytes), got %q (%d bytes, partial %v)", e.text, e.size, text, size, partial)
+		}
+	}
+
+	// 没有换行的最后一行要等文件继续写入，harvester 收到 EOF 之后重试
+	if _, _, _, err := readLine(reader, &lastReadTime, time.Hour); err != io.EOF {
+		t.Errorf("expected EOF for the unfinished line, got %v", err)
+	}
+}
@@ -1,8 +1,8 @@
 package input
 
 import (
-	"fmt"
 	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
 	"os"
 	"time"
 )
@@ -41,6 +41,16 @@ func (f *FileEvent) SetFieldsUnderRoot(fieldsUnderRoot bool) {
 	f.fieldsUnderRoot = fieldsUnderRoot
 }
 
+// GetState 返回该事件发送成功后文件应该记录的状态，offset 指向该行的末尾
+// GetState returns the file state to persist once the event has been published
+func (f *FileEvent) GetState() *FileState {
+	return &FileState{
+		Source:      f.Source,
+		Offset:      f.Offset + int64(f.Bytes),
+		FileStateOS: GetOSFileState(f.Fileinfo),
+	}
+}
+
 func (f *FileEvent) ToMapStr() common.MapStr {
 	events := common.MapStr{
 
@@ -52,17 +62,17 @@ func (f *FileEvent) ToMapStr() common.MapStr {
 // Check that the file isn't a symlink, mode is regular or file is nil
 func (f *File) IsRegularFile() bool {
 	if f.File == nil {
-		fmt.Println("Harvester: BUG: f arg is nil")
+		logp.Critical("Harvester: BUG: f arg is nil")
 		return false
 	}
 
 	info, err := f.File.Stat()
 	if err != nil {
-		fmt.Println("File check fault: stat error: ", err.Error())
+		logp.Critical("File check fault: stat error: %s", err.Error())
 		return false
 	}
 	if !info.Mode().IsRegular() {
-		fmt.Printf("Harvester: not a regular file: %q %s", info.Mode(), info.Name())
+		logp.Warn("Harvester: not a regular file: %q %s", info.Mode(), info.Name())
 		return false
 	}
 	return true
@@ -78,7 +88,7 @@ func (f1 *File) IsSameFile(f2 *File) bool {
 func IsSameFile(path string, info os.FileInfo) bool {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
-		fmt.Printf("Error during file comparison: %s with %s - Error: %s", path, info.Name(), err)
+		logp.Err("Error during file comparison: %s with %s - Error: %s", path, info.Name(), err)
 		return false
 	}
 	return os.SameFile(fileInfo, info)
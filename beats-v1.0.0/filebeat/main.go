@@ -1,10 +1,11 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	filebeat "github.com/ssp4599815/beat/filebeat/beat"
 	"github.com/ssp4599815/beat/libbeat/beat"
-	"log"
+	"os"
 )
 
 var Version = "1.0.0"
@@ -20,24 +21,29 @@ var Name = "filebeat"
 // determine where in each file to restart a harvester.
 
 func main() {
-
-	fmt.Println("开始启动 filebeat")
 	// 创建一个 beat 对象
 	fb := &filebeat.Filebeat{}
 
 	// Initi bead objectfile
 	b := beat.NewBeat(Name, Version, fb)
 
-	// Loads base config 加载基础的配置文件
-	b.LoadConfig()
-
-	// 读取配置文件
-	err := fb.Config(b)
+	// 找出子命令，剩下的参数交给 flag 解析
+	cmd, args, err := parseCommand(os.Args[1:])
 	if err != nil {
-		log.Fatalf("Config error: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		usage()
+		os.Exit(2)
 	}
+	os.Args = append(os.Args[:1], args...)
+
+	// 解析命令行参数
+	flag.Usage = usage
+	b.CommandLineSetup()
 
-	// Run beat. this calls first beater.Setup,
-	// then beater.Run and Beater.Cleanup in the end
-	b.Run()
+	// 每个子命令只加载自己需要的配置
+	err = cmd.run(b, fb, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
@@ -1,13 +1,19 @@
 package beat
 
 import (
+	"flag"
 	"fmt"
 	"github.com/ssp4599815/beat/libbeat/cfgfile"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/monitoring"
 	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/paths"
 	"github.com/ssp4599815/beat/libbeat/publisher"
 	"github.com/ssp4599815/beat/libbeat/service"
-	"log"
 	"os"
+	"runtime"
+	"time"
 )
 
 // 定义了一个公共的接口，只要所有的 beat 实现了这几个接口就可以收集日志了，也很方便的进行后期扩展
@@ -18,6 +24,13 @@ type Beater interface {
 	Run(*Beat) error     // beat运行时调用
 	Cleanup(*Beat) error // beat退出时执行清理工作
 	Stop()               // 停止beat
+	Reload(*Beat) error  // 收到 SIGHUP 时重新加载配置，不支持重新加载的配置保持不变
+}
+
+// StateReporter 是 beater 可以选择实现的接口，返回的状态由 HTTP 监控接口的 /state 输出，
+// 会在 Setup 之后的任何时候在其他 goroutine 中调用
+type StateReporter interface {
+	State() interface{}
 }
 
 // 定义一个 beat所需要的信息
@@ -28,74 +41,232 @@ type Beat struct {
 	Config  *BeatConfig // beat的配置,解析出来的配置文件会放在这里
 	BT      Beater      // 这里就是每一个要实现的beat接口
 	Events  publisher.Client
+
+	CmdLine *cfgfile.CmdLineFlags  // 解析后的命令行参数，所有的 beat 都使用同样的参数
+	Paths   *paths.Path            // beat 使用的 home/data/logs 目录
+	Outputs []outputs.OutputPlugin // 根据配置初始化的所有启用了的 output
+
+	Publisher *publisher.PublisherType // 将 Events 发送的事件转发到所有的 output
+
+	metrics   *monitoring.LogReporter // 定期输出内部指标，没有开启时为 nil
+	monitor   *monitoring.Server      // HTTP 监控接口，没有开启时为 nil
+	startTime time.Time
 }
 
 // 针对每一个 beat的基础配置
 // Basic configuration of every beat
 type BeatConfig struct {
-	Output map[string]outputs.MothershipConfig // 日志的输出
-	// Logging logp.Logging                        // 记录log
-	Shipper publisher.ShipperConfig // 消费者
+	Output  map[string]outputs.MothershipConfig // 日志的输出
+	Logging logp.Logging                        // 记录log
+	Shipper publisher.ShipperConfig             // 消费者
+	HTTP    monitoring.HTTPConfig               // 本地的 HTTP 监控接口
 }
 
 // 初始化一个 beat 对象
 // Initiates a new beat object
 func NewBeat(name string, version string, bt Beater) *Beat {
 	b := Beat{
-		Version: version,
-		Name:    name,
-		BT:      bt, // 传进来的 beat
+		Version:   version,
+		Name:      name,
+		BT:        bt, // 传进来的 beat
+		startTime: time.Now(),
 	}
 	return &b
 }
 
+// 解析所有 beat 共用的命令行参数，-c 的默认值需要用到 beat 的名称，所以要在这里单独处理。
+// 指定了 -version 时打印版本信息后直接退出
+// CommandLineSetup reads and parses the default command line params
+func (b *Beat) CommandLineSetup() {
+	// The -c flag is treated separately because it needs the Beat name
+	err := cfgfile.ChangeDefaultCfgfileFlag(b.Name)
+	if err != nil {
+		fmt.Printf("Failed to fix the -c flag: %v\n", err)
+		os.Exit(1)
+	}
+
+	flag.Parse()
+	b.CmdLine = cfgfile.Flags
+
+	if b.CmdLine.Version {
+		fmt.Printf("%s version %s (%s)\n", b.Name, b.Version, runtime.GOARCH)
+		os.Exit(0)
+	}
+
+	// 初始化 home/data/logs 目录
+	err = paths.InitPaths(b.CmdLine.HomePath, b.CmdLine.DataPath, b.CmdLine.LogsPath)
+	if err != nil {
+		fmt.Printf("Failed to initialize the paths: %v\n", err)
+		os.Exit(1)
+	}
+	b.Paths = paths.Paths
+}
+
+// ReadConfig 只读取配置文件到 Beat.Config 中，不会初始化日志、output 和 publisher。
+// 只需要检查或者打印配置的子命令使用它
+// ReadConfig reads the config files into Beat.Config without side effects
+func (b *Beat) ReadConfig() error {
+	config := &BeatConfig{}
+	if err := cfgfile.Read(config, ""); err != nil {
+		return err
+	}
+	b.Config = config
+	return nil
+}
+
 // 初始化配置文件，并从 Beat.Config 读取 默认的配置信息
 // LoadConfig inits the config file and reads the default config information
 // into Beat.Config. It exists the processes in case of errors
 func (b *Beat) LoadConfig() {
 	// 读取配置文件
-	err := cfgfile.Read(&b.Config, "")
+	err := b.ReadConfig()
 	if err != nil {
 		fmt.Printf("Loading config file error: %v\n", err)
 		os.Exit(1)
 	}
-	// 初始化log
+	// 初始化log，-e 和 -d 参数会覆盖配置文件中的设置
+	err = logp.Init(b.Name, &b.Config.Logging, b.CmdLine.ToStderr, b.CmdLine.Selectors)
+	if err != nil {
+		fmt.Printf("Error initializing logging: %v\n", err)
+		os.Exit(1)
+	}
 
-	// 初始化 publisher
+	b.metrics, err = newMetricsReporter(b.Config.Logging.Metrics)
+	if err != nil {
+		fmt.Printf("Error initializing metrics logging: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 初始化所有启用了的 output
+	b.Outputs, err = outputs.InitOutputs(b.Name, b.Config.Output, b.Config.Shipper.TopologyExpire)
+	if err != nil {
+		fmt.Printf("Initializing outputs error: %v\n", err)
+		os.Exit(1)
+	}
 
+	// 初始化 publisher
+	b.Publisher, err = publisher.New(b.Config.Shipper, b.Outputs)
+	if err != nil {
+		fmt.Printf("Initializing publisher error: %v\n", err)
+		os.Exit(1)
+	}
+	b.Events = b.Publisher.Client()
 }
 
 func (b *Beat) Run() {
+	// -httpprof, -cpuprofile 和 -memprofile
+	err := service.StartProfiling(b.CmdLine.HTTPProf, b.CmdLine.CPUProfile)
+	if err != nil {
+		logp.Critical("%v", err)
+		os.Exit(1)
+	}
+	defer service.StopProfiling(b.CmdLine.MemProfile)
+
 	// Setup beater object
-	err := b.BT.Setup(b)
+	err = b.BT.Setup(b)
 	if err != nil {
-		log.Fatal("Setup returned an error: ", err)
+		logp.Critical("Setup returned an error: %v", err)
+		os.Exit(1)
 	}
 
 	// 截获退出信号并执行相应的退出函数
 	// callback is called if the processes is asked to stop
 	// this needs to be called before the main loop is started so that
 	// it can register tie signals that stop or query the loop
-	service.HandleSignals(b.BT.Stop)
+	service.HandleSignals(b.BT.Stop, b.reload)
+
+	logp.Info("%s successfully setup. Start running.", b.Name)
 
-	fmt.Printf("%s successfully setup. Start running.", b.Name)
+	if b.metrics != nil {
+		b.metrics.Start()
+	}
+	if b.Config.HTTP.Enabled {
+		b.monitor = monitoring.NewServer(monitoring.Default, b.state)
+		if err := b.monitor.Start(b.Config.HTTP); err != nil {
+			// 监控接口不可用时 beat 仍然继续运行
+			logp.Err("%v", err)
+			b.monitor = nil
+		}
+	}
 
 	// Run beater specific stuff  运行指定的 beater
 	err = b.BT.Run(b)
 	if err != nil {
-		log.Fatal("Run returned an error: ", err)
+		logp.Critical("Run returned an error: %v", err)
+		os.Exit(1)
 	}
 
-	fmt.Printf("Cleaning up %s before shutting down.", b.Name)
+	logp.Info("Cleaning up %s before shutting down.", b.Name)
 
 	// Call beater cleanup function
 	err = b.BT.Cleanup(b)
 	if err != nil {
-		log.Fatal(err)
+		logp.Critical("Cleanup returned an error: %v", err)
+		os.Exit(1)
+	}
+
+	// 先停止 publisher，再关闭所有的 output
+	b.Publisher.Stop()
+	for _, plugin := range b.Outputs {
+		if err := plugin.Output.Close(); err != nil {
+			logp.Err("Closing %s output error: %v", plugin.Name, err)
+		}
 	}
+
+	if b.monitor != nil {
+		b.monitor.Stop()
+	}
+	if b.metrics != nil {
+		b.metrics.Stop()
+	}
+}
+
+// state 返回 beat 的基本信息，beater 实现了 StateReporter 时以 beat 的名称为 key 加上它的状态
+func (b *Beat) state() interface{} {
+	state := common.MapStr{
+		"beat": common.MapStr{
+			"name":    b.Name,
+			"version": b.Version,
+			"uptime":  time.Since(b.startTime).String(),
+		},
+	}
+	if reporter, ok := b.BT.(StateReporter); ok {
+		state[b.Name] = reporter.State()
+	}
+	return state
+}
+
+// reload 在收到 SIGHUP 时调用 beater 的 Reload，失败时继续使用原来的配置
+func (b *Beat) reload() {
+	logp.Info("Reloading %s configuration", b.Name)
+	if err := b.BT.Reload(b); err != nil {
+		logp.Err("Reloading configuration failed, keep running with the previous configuration: %v", err)
+		return
+	}
+	logp.Info("Reloaded %s configuration", b.Name)
 }
 
 // Stop calls the beater Stop action
 func (b *Beat) Stop() {
 	b.BT.Stop()
 }
+
+// newMetricsReporter 根据 logging.metrics 的配置创建 LogReporter，没有开启时返回 nil
+func newMetricsReporter(config logp.MetricsConfig) (*monitoring.LogReporter, error) {
+	if config.Enabled != nil && !*config.Enabled {
+		return nil, nil
+	}
+
+	period := monitoring.DefaultLogPeriod
+	if config.Period != "" {
+		var err error
+		period, err = time.ParseDuration(config.Period)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse metrics period '%s': %v", config.Period, err)
+		}
+		if period <= 0 {
+			return nil, fmt.Errorf("metrics period must be greater than 0, got '%s'", config.Period)
+		}
+	}
+	return monitoring.NewLogReporter(monitoring.Default, period), nil
+}
@@ -0,0 +1,12 @@
+package beat
+
+// 导入所有的 output 插件，插件在 init 函数中注册自己
+import (
+	_ "github.com/ssp4599815/beat/libbeat/outputs/console"
+	_ "github.com/ssp4599815/beat/libbeat/outputs/elasticsearch"
+	_ "github.com/ssp4599815/beat/libbeat/outputs/fileout"
+	_ "github.com/ssp4599815/beat/libbeat/outputs/httpout"
+	_ "github.com/ssp4599815/beat/libbeat/outputs/kafka"
+	_ "github.com/ssp4599815/beat/libbeat/outputs/logstash"
+	_ "github.com/ssp4599815/beat/libbeat/outputs/redis"
+)
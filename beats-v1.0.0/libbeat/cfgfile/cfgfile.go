@@ -6,23 +6,99 @@ import (
 	"io/ioutil"
 )
 
-// Command line flags
-var configfile *string
+// 读取并解析配置文件。在解析之前会先替换文件中的 ${...} 变量。
+// path 为空时，按顺序读取所有 -c 指定的配置文件，后面文件中的配置会覆盖前面的，
+// 最后命令行中的 -E 参数会覆盖所有文件中的配置。
+// 所有的配置先合并成一棵树再解析到 out 中，这样只会覆盖指定了的配置，
+// map 类型的配置 (比如 output) 中其他的配置项也会保留下来
+// Read reads the config file at path into out. If path is empty all config
+// files given by -c are merged in order and the -E settings are merged on top
+func Read(out interface{}, path string) error {
+	files := []string{path}
+	if path == "" {
+		files = ConfigFiles()
+	}
 
+	config := map[interface{}]interface{}{}
+	for _, file := range files {
+		fileConfig, err := readFile(file)
+		if err != nil {
+			return err
+		}
+		mergeConfig(config, fileConfig)
+	}
 
-func Read(out interface{}, path string) error {
 	if path == "" {
-		path = *configfile
+		overrides, err := buildOverrides(Flags.Overwrites)
+		if err != nil {
+			return err
+		}
+		mergeConfig(config, overrides)
+	}
+
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err = yaml.Unmarshal(content, out); err != nil {
+		return fmt.Errorf("YAML config parsing failed on %v: %v. Exiting", files, err)
 	}
+	return nil
+}
 
+// readFile 将一个配置文件解析为一棵树，空的文件返回空的树
+func readFile(path string) (map[interface{}]interface{}, error) {
 	// 读取配置文件
-	filecontent, err := ioutil.ReadFile(path)
+	filecontent, err := Load(path)
 	if err != nil {
-		return fmt.Errorf("Failed to read %s :%v . Exiting.\n", path, err)
+		return nil, err
 	}
 	// 验证是否能够解析配置文件
-	if err = yaml.Unmarshal(filecontent, out); err != nil {
-		return fmt.Errorf("YAML config parsing failed on :%s %v. Exiting", path, err)
+	config := map[interface{}]interface{}{}
+	if err = yaml.Unmarshal(filecontent, &config); err != nil {
+		return nil, fmt.Errorf("YAML config parsing failed on :%s %v. Exiting", path, err)
 	}
-	return nil
+	return config, nil
+}
+
+// mergeConfig 将 src 合并到 dst 中。两边都是 map 的配置递归合并，其他的配置 (包括列表) 使用 src 中的值
+func mergeConfig(dst, src map[interface{}]interface{}) {
+	for key, value := range src {
+		srcMap, ok := value.(map[interface{}]interface{})
+		if !ok {
+			dst[key] = value
+			continue
+		}
+		dstMap, ok := dst[key].(map[interface{}]interface{})
+		if !ok {
+			dstMap = map[interface{}]interface{}{}
+			dst[key] = dstMap
+		}
+		mergeConfig(dstMap, srcMap)
+	}
+}
+
+// Load 读取配置文件，并替换其中的 ${...} 变量
+// Load returns the content of the config file with all variables expanded
+func Load(path string) ([]byte, error) {
+	filecontent, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s :%v . Exiting.\n", path, err)
+	}
+
+	filecontent, err = expandEnv(filecontent)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to expand variables in %s: %v. Exiting", path, err)
+	}
+	return filecontent, nil
+}
+
+// Overrides 返回命令行中 -E 参数组成的 yaml 文档，没有 -E 参数时返回空
+// Overrides returns the -E settings as yaml document
+func Overrides() ([]byte, error) {
+	overrides, err := buildOverrides(Flags.Overwrites)
+	if err != nil || len(overrides) == 0 {
+		return nil, err
+	}
+	return yaml.Marshal(overrides)
 }
@@ -1,9 +1,218 @@
 package cfgfile
 
 import (
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
+type testBeatConfig struct {
+	Output struct {
+		Hosts []string
+		Index string
+		Port  int
+	}
+	Name string
+}
+
+// testOutputsConfig 和 beat.BeatConfig 中的 Output 使用同样的类型
+type testOutputsConfig struct {
+	Output map[string]outputs.MothershipConfig
+}
+
+func writeTestConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "cfgfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "beat.yml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
 func TestRead(t *testing.T) {
+	os.Setenv("CFGFILE_TEST_HOST", "es.example.com")
+	defer os.Unsetenv("CFGFILE_TEST_HOST")
+
+	path := writeTestConfig(t, `
+output:
+  hosts: ["${CFGFILE_TEST_HOST}:9200"]
+  index: ${CFGFILE_TEST_INDEX:filebeat}
+  port: 9200
+name: beat
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	config := &testBeatConfig{}
+	if err := Read(config, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(config.Output.Hosts) != 1 || config.Output.Hosts[0] != "es.example.com:9200" {
+		t.Errorf("unexpected hosts: %v", config.Output.Hosts)
+	}
+	if config.Output.Index != "filebeat" {
+		t.Errorf("expected default index, got %s", config.Output.Index)
+	}
+}
+
+func TestRead_Overrides(t *testing.T) {
+	path := writeTestConfig(t, "output:\n  index: filebeat\n  port: 9200\nname: beat\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	oldFlags := *Flags
+	defer func() { *Flags = oldFlags }()
+
+	Flags.ConfigFiles = []string{path}
+	Flags.Overwrites = []string{"output.port=5044", "output.hosts=[a, b]", "name=override"}
+
+	config := &testBeatConfig{}
+	if err := Read(config, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Output.Port != 5044 || config.Output.Index != "filebeat" || config.Name != "override" {
+		t.Errorf("overrides not merged: %+v", config)
+	}
+	if len(config.Output.Hosts) != 2 {
+		t.Errorf("expected 2 hosts, got %v", config.Output.Hosts)
+	}
+
+	// -E settings only apply to the main config file
+	config = &testBeatConfig{}
+	if err := Read(config, path); err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "beat" {
+		t.Errorf("overrides applied to explicit path: %+v", config)
+	}
+}
+
+func TestRead_OverridesMapSection(t *testing.T) {
+	path := writeTestConfig(t, `
+output:
+  elasticsearch:
+    hosts: [a]
+    index: filebeat
+    username: beat
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	oldFlags := *Flags
+	defer func() { *Flags = oldFlags }()
+
+	Flags.ConfigFiles = []string{path}
+	Flags.Overwrites = []string{"output.elasticsearch.hosts=[b]"}
+
+	config := &testOutputsConfig{}
+	if err := Read(config, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	es := config.Output["elasticsearch"]
+	if len(es.Hosts) != 1 || es.Hosts[0] != "b" {
+		t.Errorf("override not applied: %v", es.Hosts)
+	}
+	if es.Index != "filebeat" || es.Username != "beat" {
+		t.Errorf("other settings of the section lost: %+v", es)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("CFGFILE_TEST_SET", "value")
+	defer os.Unsetenv("CFGFILE_TEST_SET")
+
+	tests := []struct {
+		in, out string
+	}{
+		{"a: ${CFGFILE_TEST_SET}", "a: value"},
+		{"a: ${CFGFILE_TEST_UNSET}", "a: "},
+		{"a: ${CFGFILE_TEST_UNSET:default}", "a: default"},
+		{"a: ${CFGFILE_TEST_SET:default}", "a: value"},
+		{"a: ${CFGFILE_TEST_SET:?missing}", "a: value"},
+		{"a: $${CFGFILE_TEST_SET}", "a: ${CFGFILE_TEST_SET}"},
+		{"a: $HOME", "a: $HOME"},
+	}
+
+	for _, test := range tests {
+		out, err := expandEnv([]byte(test.in))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.in, err)
+			continue
+		}
+		if string(out) != test.out {
+			t.Errorf("%s: expected %q, got %q", test.in, test.out, out)
+		}
+	}
+}
+
+func TestExpandEnv_Errors(t *testing.T) {
+	inputs := []string{
+		"a: 1\nb: ${CFGFILE_TEST_UNSET:?must be set}",
+		"a: ${CFGFILE_TEST_UNSET",
+		"a: ${}",
+	}
+
+	for _, in := range inputs {
+		if _, err := expandEnv([]byte(in)); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+
+	_, err := expandEnv([]byte(inputs[0]))
+	if err.Error() != "line 2: required variable CFGFILE_TEST_UNSET: must be set" {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestRead_MergeConfigFiles(t *testing.T) {
+	base := writeTestConfig(t, "output:\n  index: filebeat\n  port: 9200\nname: beat\n")
+	defer os.RemoveAll(filepath.Dir(base))
+	local := writeTestConfig(t, "output:\n  port: 5044\n")
+	defer os.RemoveAll(filepath.Dir(local))
+
+	oldFlags := *Flags
+	defer func() { *Flags = oldFlags }()
+
+	// files given by -c are merged in order, later files win
+	Flags.ConfigFiles = []string{base, local}
+	Flags.Overwrites = nil
+
+	config := &testBeatConfig{}
+	if err := Read(config, ""); err != nil {
+		t.Fatal(err)
+	}
+	if config.Output.Port != 5044 || config.Output.Index != "filebeat" || config.Name != "beat" {
+		t.Errorf("config files not merged: %+v", config)
+	}
+}
+
+func TestRead_MergeConfigFilesMapSection(t *testing.T) {
+	base := writeTestConfig(t, "output:\n  logstash:\n    hosts: [a:5044]\n    index: filebeat\n    loadbalance: true\n")
+	defer os.RemoveAll(filepath.Dir(base))
+	local := writeTestConfig(t, "output:\n  logstash:\n    hosts: [b:5044]\n")
+	defer os.RemoveAll(filepath.Dir(local))
+
+	oldFlags := *Flags
+	defer func() { *Flags = oldFlags }()
+
+	Flags.ConfigFiles = []string{base, local}
+	Flags.Overwrites = nil
+
+	config := &testOutputsConfig{}
+	if err := Read(config, ""); err != nil {
+		t.Fatal(err)
+	}
 
+	logstash := config.Output["logstash"]
+	if len(logstash.Hosts) != 1 || logstash.Hosts[0] != "b:5044" {
+		t.Errorf("later file did not win: %v", logstash.Hosts)
+	}
+	if logstash.Index != "filebeat" || logstash.LoadBalance == nil || !*logstash.LoadBalance {
+		t.Errorf("settings of the earlier file lost: %+v", logstash)
+	}
 }
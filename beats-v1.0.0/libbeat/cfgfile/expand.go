@@ -0,0 +1,80 @@
+package cfgfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 在解析 yaml 之前替换配置文件中的变量:
+//   ${VAR}          环境变量 VAR 的值，没有设置时为空
+//   ${VAR:default}  VAR 没有设置或者为空时使用 default
+//   ${VAR:?message} VAR 没有设置或者为空时报错
+//   $${VAR}         不进行替换，输出 ${VAR}
+// expandEnv replaces all ${...} references in the config file content with
+// the values of the environment variables
+func expandEnv(content []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Grow(len(content))
+
+	for i := 0; i < len(content); {
+		// $${ escapes a reference
+		if bytes.HasPrefix(content[i:], []byte("$${")) {
+			out.WriteString("${")
+			i += 3
+			continue
+		}
+
+		if !bytes.HasPrefix(content[i:], []byte("${")) {
+			out.WriteByte(content[i])
+			i++
+			continue
+		}
+
+		end := bytes.IndexByte(content[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("line %d: unterminated variable reference", lineAt(content, i))
+		}
+
+		value, err := expandReference(string(content[i+2 : i+end]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineAt(content, i), err)
+		}
+		out.WriteString(value)
+		i += end + 1
+	}
+
+	return out.Bytes(), nil
+}
+
+// expandReference 解析 ${...} 中的内容并返回替换后的值
+func expandReference(ref string) (string, error) {
+	name, arg, hasArg := ref, "", false
+	if idx := strings.IndexByte(ref, ':'); idx >= 0 {
+		name, arg, hasArg = ref[:idx], ref[idx+1:], true
+	}
+
+	if name == "" {
+		return "", fmt.Errorf("empty variable name in ${%s}", ref)
+	}
+
+	value := os.Getenv(name)
+	if value != "" || !hasArg {
+		return value, nil
+	}
+
+	if strings.HasPrefix(arg, "?") {
+		msg := strings.TrimSpace(arg[1:])
+		if msg == "" {
+			msg = "not set"
+		}
+		return "", fmt.Errorf("required variable %s: %s", name, msg)
+	}
+	return arg, nil
+}
+
+// lineAt 返回 offset 所在的行号，从 1 开始
+func lineAt(content []byte, offset int) int {
+	return bytes.Count(content[:offset], []byte("\n")) + 1
+}
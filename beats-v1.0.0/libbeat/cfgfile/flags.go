@@ -0,0 +1,106 @@
+package cfgfile
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// CmdLineFlags 是所有 beat 共用的命令行参数，由 beat.Beat 在启动时解析
+// CmdLineFlags holds the command line flags shared by all beats
+type CmdLineFlags struct {
+	ConfigFiles []string // -c, 可以指定多次，按顺序合并
+	Overwrites  []string // -E key=value
+	TestConfig  bool     // -configtest
+	HomePath    string   // -path.home
+	DataPath    string   // -path.data
+	LogsPath    string   // -path.logs
+	Selectors   []string // -d, 开启 debug 日志的 selector
+	ToStderr    bool     // -e, 日志输出到 stderr
+	Version     bool     // -version
+	HTTPProf    string   // -httpprof, net/http/pprof 监听的地址
+	CPUProfile  string   // -cpuprofile, 退出时写入 CPU profile 的文件
+	MemProfile  string   // -memprofile, 退出时写入内存 profile 的文件
+}
+
+// Flags contains the parsed command line flags
+var Flags = &CmdLineFlags{}
+
+// 没有指定 -c 时使用的配置文件，参见 ChangeDefaultCfgfileFlag
+var defaultConfigFile = "beat.yml"
+
+func init() {
+	// 默认的配置文件名不能包含 beat 的名称，因为这里执行的时候 beat 还没有初始化，
+	// 参见 ChangeDefaultCfgfileFlag
+	// The default config cannot include the beat name as it is not initialised when this
+	// function is called, but see ChangeDefaultCfgfileFlag
+	flag.Var((*stringsFlag)(&Flags.ConfigFiles), "c", "Configuration file, can be repeated to merge several files in order")
+	flag.Var((*settingsFlag)(&Flags.Overwrites), "E", "Configuration overwrite, key=value (can be repeated)")
+	flag.BoolVar(&Flags.TestConfig, "configtest", false, "Test configuration and exit.")
+	flag.StringVar(&Flags.HomePath, "path.home", "", "Home path, defaults to the working directory")
+	flag.StringVar(&Flags.DataPath, "path.data", "", "Data path, defaults to path.home")
+	flag.StringVar(&Flags.LogsPath, "path.logs", "", "Logs path, defaults to path.home/logs")
+	flag.Var((*selectorsFlag)(&Flags.Selectors), "d", "Enable certain debug selectors, comma separated (e.g. -d \"crawler,publish\")")
+	flag.BoolVar(&Flags.ToStderr, "e", false, "Log to stderr and disable syslog/file output")
+	flag.BoolVar(&Flags.Version, "version", false, "Print version and exit")
+	flag.StringVar(&Flags.HTTPProf, "httpprof", "", "Start pprof http server on the given address (e.g. localhost:6060)")
+	flag.StringVar(&Flags.CPUProfile, "cpuprofile", "", "Write cpu profile to file")
+	flag.StringVar(&Flags.MemProfile, "memprofile", "", "Write memory profile to file")
+}
+
+// ChangeDefaultCfgfileFlag replaces the value and default value for the `-c`
+// flag so that it reflects the beat name.
+func ChangeDefaultCfgfileFlag(beatName string) error {
+	cliflag := flag.Lookup("c")
+	if cliflag == nil {
+		return fmt.Errorf("Flag -c not found")
+	}
+
+	defaultConfigFile = fmt.Sprintf("/etc/%s/%s.yml", beatName, beatName)
+	cliflag.DefValue = defaultConfigFile
+	return nil
+}
+
+// ConfigFiles 返回 -c 指定的所有配置文件，没有指定时返回默认的配置文件
+// ConfigFiles returns the config files given by the `-c` flag in order
+func ConfigFiles() []string {
+	if len(Flags.ConfigFiles) == 0 {
+		return []string{defaultConfigFile}
+	}
+	return Flags.ConfigFiles
+}
+
+// IsTestConfig 是否只检查配置文件，检查完成后就退出
+// IsTestConfig returns true if the beat was started with -configtest
+func IsTestConfig() bool {
+	return Flags.TestConfig
+}
+
+// stringsFlag 是一个可以指定多次的参数
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// selectorsFlag 可以指定多次，每次可以用逗号分隔多个 selector
+type selectorsFlag []string
+
+func (f *selectorsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *selectorsFlag) Set(value string) error {
+	for _, selector := range strings.Split(value, ",") {
+		selector = strings.TrimSpace(selector)
+		if selector != "" {
+			*f = append(*f, selector)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package cfgfile
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"strings"
+)
+
+// settingsFlag 收集命令行中的 -E key=value 参数，可以指定多次
+// settingsFlag collects the repeatable -E key=value command line flag
+type settingsFlag []string
+
+func (f *settingsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *settingsFlag) Set(value string) error {
+	idx := strings.IndexByte(value, '=')
+	if idx <= 0 {
+		return fmt.Errorf("invalid setting '%s', expected key=value", value)
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+// 将 -E 参数转换为一棵配置树。key 中的 '.' 表示嵌套的层级，
+// value 按 yaml 进行解析，所以 -E output.elasticsearch.hosts=[a,b] 会得到一个列表
+// buildOverrides builds a config tree from key=value settings. Dots in keys
+// select nested settings, values are parsed as yaml. Later settings win
+func buildOverrides(settings []string) (map[interface{}]interface{}, error) {
+	root := map[interface{}]interface{}{}
+	for _, setting := range settings {
+		idx := strings.IndexByte(setting, '=')
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid setting '%s', expected key=value", setting)
+		}
+		key, raw := setting[:idx], setting[idx+1:]
+
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+			value = raw
+		}
+
+		path := strings.Split(key, ".")
+		current := root
+		for _, name := range path[:len(path)-1] {
+			next, ok := current[name].(map[interface{}]interface{})
+			if !ok {
+				next = map[interface{}]interface{}{}
+				current[name] = next
+			}
+			current = next
+		}
+		current[path[len(path)-1]] = value
+	}
+
+	return root, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 )
 
 // Parse operation failed cause of buffer snapped short + buffer is fixed
@@ -63,9 +64,24 @@ func (b *Buffer) Append(data []byte) error {
 func (b *Buffer) doAppend(data []byte, retainable bool) error {
 	if b.fixed {
 		return b.SetError(ErrUnexpectedEOB)
+	}
+	if b.err != nil && b.err != ErrNoMoreBytes {
+		return b.err
+	}
+
+	if len(b.data) == 0 && retainable {
+		b.data = data
 	} else {
-		return b.SetError(ErrNoMoreBytes)
+		b.data = append(b.data, data...)
 	}
+	b.avaliable += len(data)
+
+	// 有了新的数据之后可以继续解析
+	// reset error status (continue parsing)
+	if b.err == ErrNoMoreBytes {
+		b.err = nil
+	}
+	return nil
 }
 
 func (b *Buffer) SetError(err error) error {
@@ -73,6 +89,30 @@ func (b *Buffer) SetError(err error) error {
 	return err
 }
 
+// Err returns the error state of the buffer
+func (b *Buffer) Err() error {
+	return b.err
+}
+
+// ioErr 将解析时的错误转换为 io 的错误，缓冲区中没有更多数据时返回 io.EOF
+func (b *Buffer) ioErr() error {
+	err := b.Err()
+	if err == ErrUnexpectedEOB || err == ErrNoMoreBytes {
+		return io.EOF
+	}
+	return err
+}
+
+// Reset 丢弃已经读取过的数据并清除错误状态，没有读取的数据会被保留
+// Reset removes all bytes already read from the buffer.
+func (b *Buffer) Reset() {
+	b.data = b.data[b.mark:]
+	b.offset -= b.mark
+	b.mark = 0
+	b.avaliable = len(b.data)
+	b.err = nil
+}
+
 func (b *Buffer) Bytes() []byte {
 	return b.data[b.mark:]
 }
@@ -82,6 +122,7 @@ func (b *Buffer) Write(p []byte) (int, error) {
 	if err != nil {
 		return 0, b.ioErr()
 	}
+	return len(p), nil
 }
 
 // Collect tries to collect count bytes from the buffer and updates the read
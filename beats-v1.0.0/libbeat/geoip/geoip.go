@@ -0,0 +1,180 @@
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"net"
+	"os"
+	"strings"
+)
+
+const defaultCacheSize = 1000
+
+var defaultFields = []string{"client_ip"}
+
+// Config 是 GeoIP 的配置，没有配置 paths 时不启用
+type Config struct {
+	Paths     []string // MaxMind 格式的数据库文件，使用第一个存在的文件
+	Fields    []string // 需要查找的 IP 地址字段，默认为 client_ip
+	CacheSize int      `yaml:"cache_size"` // 缓存最近查找过的地址的数量
+}
+
+// location 是一个 IP 地址在数据库中查到的位置
+type location struct {
+	countryISOCode string
+	countryName    string
+	cityName       string
+	hasLocation    bool
+	lat, lon       float64
+}
+
+// Processor 根据事件中的 IP 地址字段查找地理位置，并把国家、城市和经纬度添加到事件中。
+// 结果保存在 <名称>_geoip 字段中，字段名以 ip 或者 _ip 结尾时去掉这个后缀，比如 client_ip 的结果保存在 client_geoip 中
+// Processor adds the geographic location of IP address fields to events
+type Processor struct {
+	reader *Reader
+	fields []string
+	cache  *lruCache
+}
+
+// New 打开 paths 中第一个存在的数据库文件
+// New opens the first existing database in config.Paths
+func New(config Config) (*Processor, error) {
+	if len(config.Paths) == 0 {
+		return nil, errors.New("no GeoIP database configured")
+	}
+
+	var path string
+	for _, p := range config.Paths {
+		if _, err := os.Stat(p); err == nil {
+			path = p
+			break
+		}
+	}
+	if path == "" {
+		return nil, fmt.Errorf("no GeoIP database found in %v", config.Paths)
+	}
+
+	reader, err := Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database %s: %v", path, err)
+	}
+
+	fields := config.Fields
+	if len(fields) == 0 {
+		fields = defaultFields
+	}
+	cacheSize := config.CacheSize
+	if cacheSize <= 0 {
+		cacheSize = defaultCacheSize
+	}
+
+	logp.Info("geoip: loaded %s database %s", reader.DatabaseType(), path)
+	return &Processor{
+		reader: reader,
+		fields: fields,
+		cache:  newLRUCache(cacheSize),
+	}, nil
+}
+
+// Run 给事件中的每个 IP 地址字段加上地理位置，不是 IP 地址或者没有查到的字段不做处理
+func (p *Processor) Run(event common.MapStr) {
+	for _, field := range p.fields {
+		ip, ok := event[field].(string)
+		if !ok || ip == "" {
+			continue
+		}
+		loc := p.lookup(ip)
+		if loc == nil {
+			continue
+		}
+		event[targetField(field)] = loc.toMapStr()
+	}
+}
+
+// lookup 查找 ip 的位置，先从缓存中查找
+func (p *Processor) lookup(ip string) *location {
+	if loc, ok := p.cache.Get(ip); ok {
+		return loc
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil
+	}
+	record, err := p.reader.Lookup(parsed)
+	if err != nil {
+		logp.Debug("geoip", "failed to look up %s: %v", ip, err)
+		return nil
+	}
+
+	loc := newLocation(record)
+	p.cache.Add(ip, loc)
+	return loc
+}
+
+// newLocation 从 GeoIP2 格式的记录中取出需要的字段，没有任何字段时返回 nil
+func newLocation(record interface{}) *location {
+	m, ok := record.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	loc := &location{}
+	if country, ok := m["country"].(map[string]interface{}); ok {
+		loc.countryISOCode, _ = country["iso_code"].(string)
+		loc.countryName = englishName(country)
+	}
+	if city, ok := m["city"].(map[string]interface{}); ok {
+		loc.cityName = englishName(city)
+	}
+	if l, ok := m["location"].(map[string]interface{}); ok {
+		lat, latOK := l["latitude"].(float64)
+		lon, lonOK := l["longitude"].(float64)
+		if latOK && lonOK {
+			loc.hasLocation = true
+			loc.lat, loc.lon = lat, lon
+		}
+	}
+
+	if loc.countryISOCode == "" && loc.countryName == "" && loc.cityName == "" && !loc.hasLocation {
+		return nil
+	}
+	return loc
+}
+
+func englishName(m map[string]interface{}) string {
+	names, _ := m["names"].(map[string]interface{})
+	name, _ := names["en"].(string)
+	return name
+}
+
+// toMapStr 每次都创建一个新的 MapStr，缓存的结果不会被事件共享
+func (loc *location) toMapStr() common.MapStr {
+	m := common.MapStr{}
+	if loc.countryISOCode != "" {
+		m["country_iso_code"] = loc.countryISOCode
+	}
+	if loc.countryName != "" {
+		m["country_name"] = loc.countryName
+	}
+	if loc.cityName != "" {
+		m["city_name"] = loc.cityName
+	}
+	if loc.hasLocation {
+		m["location"] = common.MapStr{"lat": loc.lat, "lon": loc.lon}
+	}
+	return m
+}
+
+func targetField(field string) string {
+	if field == "ip" {
+		return "geoip"
+	}
+	if strings.HasSuffix(field, "_ip") {
+		return strings.TrimSuffix(field, "_ip") + "_geoip"
+	}
+	return field + "_geoip"
+}
@@ -0,0 +1,211 @@
+package geoip
+
+import (
+	"github.com/ssp4599815/beat/libbeat/common"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testdata/GeoIP2-City-Test.mmdb 是按照 MaxMind DB 格式生成的小数据库，包含:
+//
+//	81.2.69.0/24      GB London
+//	216.160.83.56/29  US Milton
+//	175.16.199.0/24   CN Changchun (还包含 postal、traits 等其他类型的字段)
+//	2001:480::/32     US，没有城市
+//	89.160.20.112/28  只有 registered_country
+//
+// 重复的 country 使用指针保存
+const testDatabase = "testdata/GeoIP2-City-Test.mmdb"
+
+func newTestProcessor(t *testing.T, config Config) *Processor {
+	if len(config.Paths) == 0 {
+		config.Paths = []string{testDatabase}
+	}
+	p, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestReader_Lookup(t *testing.T) {
+	r, err := Open(testDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.DatabaseType() != "GeoIP2-City" {
+		t.Errorf("unexpected database type %s", r.DatabaseType())
+	}
+
+	tests := []struct {
+		ip      string
+		country string
+		city    string
+	}{
+		{"81.2.69.142", "GB", "London"},
+		{"216.160.83.57", "US", "Milton"},
+		{"175.16.199.1", "CN", "Changchun"},
+		{"2001:480::1", "US", ""},
+		{"::ffff:81.2.69.1", "GB", "London"},
+	}
+	for _, test := range tests {
+		record, err := r.Lookup(net.ParseIP(test.ip))
+		if err != nil {
+			t.Fatalf("%s: %v", test.ip, err)
+		}
+		loc := newLocation(record)
+		if loc == nil || loc.countryISOCode != test.country || loc.cityName != test.city {
+			t.Errorf("%s: unexpected record %v", test.ip, record)
+		}
+	}
+
+	for _, ip := range []string{"81.2.70.1", "216.160.83.64", "10.0.0.1", "2002::1"} {
+		record, err := r.Lookup(net.ParseIP(ip))
+		if err != nil || record != nil {
+			t.Errorf("%s: expected no record, got %v, %v", ip, record, err)
+		}
+	}
+}
+
+func TestReader_DataTypes(t *testing.T) {
+	r, err := Open(testDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := r.Lookup(net.ParseIP("175.16.199.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := record.(map[string]interface{})
+	traits := m["traits"].(map[string]interface{})
+	if traits["is_anycast"] != false || traits["asn"] != int64(-1) {
+		t.Errorf("unexpected traits %v", traits)
+	}
+	if m["postal"].(map[string]interface{})["code"] != "130000" {
+		t.Errorf("unexpected postal %v", m["postal"])
+	}
+	names := m["country"].(map[string]interface{})["names"].(map[string]interface{})
+	if names["zh-CN"] != "中国" {
+		t.Errorf("unexpected names %v", names)
+	}
+	if id := m["country"].(map[string]interface{})["geoname_id"]; id != uint64(1) {
+		t.Errorf("unexpected geoname_id %v", id)
+	}
+
+	record, err = r.Lookup(net.ParseIP("2001:480::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subdivisions := record.(map[string]interface{})["subdivisions"].([]interface{})
+	if len(subdivisions) != 1 {
+		t.Errorf("unexpected subdivisions %v", subdivisions)
+	}
+}
+
+func TestProcessor_Run(t *testing.T) {
+	p := newTestProcessor(t, Config{Fields: []string{"client_ip", "ip", "dst"}})
+
+	event := common.MapStr{
+		"client_ip": "81.2.69.142",
+		"ip":        "216.160.83.57",
+		"dst":       "2001:480::1",
+	}
+	p.Run(event)
+
+	client := event["client_geoip"].(common.MapStr)
+	if client["country_iso_code"] != "GB" || client["country_name"] != "United Kingdom" || client["city_name"] != "London" {
+		t.Errorf("unexpected client_geoip %v", client)
+	}
+	location := client["location"].(common.MapStr)
+	if location["lat"] != 51.5142 || location["lon"] != -0.0931 {
+		t.Errorf("unexpected location %v", location)
+	}
+
+	if server := event["geoip"].(common.MapStr); server["city_name"] != "Milton" {
+		t.Errorf("unexpected geoip %v", server)
+	}
+	dst := event["dst_geoip"].(common.MapStr)
+	if dst["country_iso_code"] != "US" || dst["city_name"] != nil || dst["location"] != nil {
+		t.Errorf("unexpected dst_geoip %v", dst)
+	}
+}
+
+func TestProcessor_Ignored(t *testing.T) {
+	p := newTestProcessor(t, Config{})
+
+	// 不是 IP 地址、没有查到或者没有位置信息的记录都不会添加字段
+	for _, ip := range []interface{}{"localhost", "10.0.0.1", "89.160.20.113", 42} {
+		event := common.MapStr{"client_ip": ip}
+		p.Run(event)
+		if _, ok := event["client_geoip"]; ok {
+			t.Errorf("%v: unexpected client_geoip %v", ip, event["client_geoip"])
+		}
+	}
+}
+
+func TestProcessor_Cache(t *testing.T) {
+	p := newTestProcessor(t, Config{CacheSize: 2})
+
+	for _, ip := range []string{"81.2.69.1", "81.2.69.2", "10.0.0.1", "81.2.69.1"} {
+		p.Run(common.MapStr{"client_ip": ip})
+	}
+	if n := p.cache.Len(); n != 2 {
+		t.Fatalf("expected 2 cached addresses, got %d", n)
+	}
+	// 没有查到的地址也会被缓存
+	if loc, ok := p.cache.Get("10.0.0.1"); !ok || loc != nil {
+		t.Errorf("expected 10.0.0.1 to be cached as not found")
+	}
+	if _, ok := p.cache.Get("81.2.69.2"); ok {
+		t.Errorf("expected 81.2.69.2 to be evicted")
+	}
+
+	// 每个事件得到的是一个新的 MapStr
+	e1, e2 := common.MapStr{"client_ip": "81.2.69.1"}, common.MapStr{"client_ip": "81.2.69.1"}
+	p.Run(e1)
+	p.Run(e2)
+	e1["client_geoip"].(common.MapStr)["city_name"] = "changed"
+	if e2["client_geoip"].(common.MapStr)["city_name"] != "London" {
+		t.Error("cached result is shared between events")
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	invalid := filepath.Join(dir, "invalid.mmdb")
+	if err := ioutil.WriteFile(invalid, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated.mmdb")
+	data, err := ioutil.ReadFile(testDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(truncated, data[1000:], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configs := []Config{
+		{},
+		{Paths: []string{filepath.Join(dir, "missing.mmdb")}},
+		{Paths: []string{invalid}},
+		{Paths: []string{truncated}},
+	}
+	for i, config := range configs {
+		if _, err := New(config); err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+
+	// 使用第一个存在的文件
+	newTestProcessor(t, Config{Paths: []string{filepath.Join(dir, "missing.mmdb"), testDatabase}})
+}
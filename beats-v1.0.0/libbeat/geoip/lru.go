@@ -0,0 +1,66 @@
+package geoip
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache 缓存最近查找过的 IP 地址的结果，超过 size 时删除最久没有使用的地址
+type lruCache struct {
+	mutex sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value *location
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// Get 返回缓存的结果，ok 为 false 表示没有缓存。value 为 nil 表示数据库中没有这个地址
+func (c *lruCache) Get(key string) (value *location, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return elem.Value.(*lruEntry).value, true
+}
+
+// Add 缓存一个结果
+func (c *lruCache) Add(key string, value *location) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		elem.Value.(*lruEntry).value = value
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry{key, value})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Len 返回缓存的地址数量
+func (c *lruCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,358 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+)
+
+// metadataStart 是元数据前面的标记，元数据位于文件中最后一次出现这个标记的位置之后
+var metadataStart = []byte("\xab\xcd\xefMaxMind.com")
+
+// 数据段中的类型
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+var errInvalidDatabase = errors.New("invalid MaxMind database")
+
+// metadata 是数据库的元数据中用到的部分
+type metadata struct {
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+}
+
+// Reader 读取 MaxMind DB 格式 (GeoIP2、GeoLite2) 的数据库文件。
+// 整个文件读入内存，前面是一棵按照 IP 地址的每一位查找的二叉树，叶子指向后面数据段中的记录
+// Reader reads databases in the MaxMind DB format
+type Reader struct {
+	buf      []byte
+	metadata metadata
+
+	nodeSize  uint // 每个节点的字节数
+	data      []byte
+	ipv4Start uint // IPv6 数据库中 ::/96 对应的节点，IPv4 地址从这里开始查找
+}
+
+// Open 读取并解析数据库文件
+// Open reads the database at path
+func Open(path string) (*Reader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newReader(buf)
+}
+
+func newReader(buf []byte) (*Reader, error) {
+	start := bytes.LastIndex(buf, metadataStart)
+	if start < 0 {
+		return nil, fmt.Errorf("%v: metadata not found", errInvalidDatabase)
+	}
+	start += len(metadataStart)
+
+	d := decoder{buf: buf[start:]}
+	value, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", errInvalidDatabase, err)
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v: metadata is not a map", errInvalidDatabase)
+	}
+
+	r := &Reader{buf: buf}
+	r.metadata.nodeCount = toUint(m["node_count"])
+	r.metadata.recordSize = toUint(m["record_size"])
+	r.metadata.ipVersion = toUint(m["ip_version"])
+	r.metadata.databaseType, _ = m["database_type"].(string)
+
+	switch r.metadata.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%v: unsupported record size %d", errInvalidDatabase, r.metadata.recordSize)
+	}
+	if r.metadata.ipVersion != 4 && r.metadata.ipVersion != 6 {
+		return nil, fmt.Errorf("%v: unsupported ip version %d", errInvalidDatabase, r.metadata.ipVersion)
+	}
+
+	r.nodeSize = r.metadata.recordSize / 4
+	treeSize := r.metadata.nodeCount * r.nodeSize
+	// 二叉树和数据段之间有 16 个字节的 0
+	if treeSize+16 > uint(start-len(metadataStart)) {
+		return nil, fmt.Errorf("%v: search tree is larger than the file", errInvalidDatabase)
+	}
+	r.data = buf[treeSize+16 : start-len(metadataStart)]
+
+	if r.metadata.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.metadata.nodeCount; i++ {
+			node = r.readNode(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// DatabaseType 返回数据库的类型，比如 GeoIP2-City
+func (r *Reader) DatabaseType() string {
+	return r.metadata.databaseType
+}
+
+// Lookup 查找 ip 对应的记录，没有找到时返回 nil
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+		node = r.ipv4Start
+	} else if r.metadata.ipVersion == 4 {
+		return nil, fmt.Errorf("can not look up IPv6 address %v in an IPv4 database", ip)
+	}
+
+	nodeCount := r.metadata.nodeCount
+	for i := 0; i < len(ip)*8 && node < nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-uint(i%8))) & 1
+		node = r.readNode(node, bit)
+	}
+
+	if node == nodeCount {
+		return nil, nil
+	}
+	if node < nodeCount {
+		return nil, fmt.Errorf("%v: search tree is too deep", errInvalidDatabase)
+	}
+
+	offset := node - nodeCount - 16
+	if offset >= uint(len(r.data)) {
+		return nil, fmt.Errorf("%v: record points outside of the data section", errInvalidDatabase)
+	}
+	d := decoder{buf: r.data}
+	value, _, err := d.decode(offset)
+	return value, err
+}
+
+// readNode 读取节点的左 (bit 为 0) 或者右 (bit 为 1) 记录
+func (r *Reader) readNode(node, bit uint) uint {
+	b := r.buf[node*r.nodeSize : (node+1)*r.nodeSize]
+	switch r.metadata.recordSize {
+	case 24:
+		off := bit * 3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := bit * 4
+		return uint(binary.BigEndian.Uint32(b[off : off+4]))
+	}
+}
+
+// decoder 解析数据段中的值，指针是相对于 buf 开始位置的偏移
+type decoder struct {
+	buf []byte
+}
+
+// decode 解析 offset 处的值，返回值以及下一个值的位置
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	typ, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		pointer, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		// 指针指向的值不能再是指针
+		typ, size, start, err := d.decodeControl(pointer)
+		if err != nil {
+			return nil, 0, err
+		}
+		if typ == typePointer {
+			return nil, 0, errors.New("pointer to a pointer")
+		}
+		value, _, err := d.decodeValue(typ, size, start)
+		return value, next, err
+	}
+	return d.decodeValue(typ, size, offset)
+}
+
+// decodeControl 解析控制字节，返回类型、大小以及数据开始的位置。指针类型返回的 size 是控制字节本身
+func (d *decoder) decodeControl(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, errors.New("unexpected end of data")
+	}
+	ctrl := d.buf[offset]
+	offset++
+
+	typ := int(ctrl >> 5)
+	if typ == typePointer {
+		return typ, uint(ctrl), offset, nil
+	}
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, errors.New("unexpected end of data")
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return 0, 0, 0, errors.New("unexpected end of data")
+		}
+		v := uint(0)
+		for _, b := range d.buf[offset : offset+n] {
+			v = v<<8 | uint(b)
+		}
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + v
+		case 2:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+	}
+	return typ, size, offset, nil
+}
+
+func (d *decoder) decodePointer(ctrl, offset uint) (uint, uint, error) {
+	n := (ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	v := uint(0)
+	if n < 4 {
+		v = ctrl & 0x7
+	}
+	for _, b := range d.buf[offset : offset+n] {
+		v = v<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		v += 2048
+	case 3:
+		v += 526336
+	}
+	return v, offset + n, nil
+}
+
+func (d *decoder) decodeValue(typ int, size, offset uint) (interface{}, uint, error) {
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("map key is not a string")
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, size)
+		for i := range a {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a[i] = value
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, 0, fmt.Errorf("unexpected data type %d", typ)
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errors.New("unexpected end of data")
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typ {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte{}, b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid size %d for double", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid size %d for float", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, fmt.Errorf("invalid size %d for unsigned integer", size)
+		}
+		v := uint64(0)
+		for _, c := range b {
+			v = v<<8 | uint64(c)
+		}
+		return v, next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, fmt.Errorf("invalid size %d for int32", size)
+		}
+		v := uint32(0)
+		for _, c := range b {
+			v = v<<8 | uint32(c)
+		}
+		return int64(int32(v)), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, fmt.Errorf("invalid size %d for uint128", size)
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	}
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+func toUint(v interface{}) uint {
+	n, _ := v.(uint64)
+	return uint(n)
+}
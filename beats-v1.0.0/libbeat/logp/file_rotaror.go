@@ -1,13 +1,229 @@
 package logp
 
-import "os"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
 
+// 日志文件的默认配置
+const (
+	RotatorMaxFiles         = 1024
+	DefaultKeepFiles        = 7
+	DefaultRotateEveryBytes = 10 * 1024 * 1024
+)
+
+// 获取当前时间，测试时可以替换
+var currentTime = time.Now
+
+// FileRotator 将日志写入 Path 目录下名为 Name 的文件。
+// 文件超过 RotateEveryBytes 或者打开的时间超过 Interval 时切割：Name 重命名为 Name.1，
+// Name.1 重命名为 Name.2，依次类推，超过 KeepFiles 的文件会被删除
+// FileRotator is an io.Writer writing to the file Name in the directory Path,
+// rotating it to Name.1 .. Name.N and keeping at most KeepFiles files
 type FileRotator struct {
 	Path             string
 	Name             string
-	RotateEveryBytes *uint64
-	KeepFiles        *int
+	RotateEveryBytes *uint64 `yaml:"rotateeverybytes"` // 文件超过这个大小时切割
+	KeepFiles        *int    `yaml:"keepfiles"`        // 最多保留的文件数量，包括正在写入的文件
+	RotateOnStartup  *bool   `yaml:"rotateonstartup"`  // 启动时先切割已经存在的文件，默认为 false
+	Interval         string  // 按时间切割的间隔，比如 24h，默认不按时间切割
+
+	IntervalDuration time.Duration `yaml:"-"`
 
+	mutex       sync.Mutex
+	started     bool // 是否已经打开过文件，RotateOnStartup 只在第一次打开时生效
 	current     *os.File
 	currentSize uint64
+	openTime    time.Time // 当前文件开始写入的时间
+}
+
+// CheckIfConfigSane 检查配置并设置默认值
+func (rotator *FileRotator) CheckIfConfigSane() error {
+	if len(rotator.Name) == 0 {
+		return errors.New("file logging requires a name for the file names")
+	}
+	if rotator.KeepFiles == nil {
+		keepFiles := DefaultKeepFiles
+		rotator.KeepFiles = &keepFiles
+	}
+	if rotator.RotateEveryBytes == nil {
+		rotateEveryBytes := uint64(DefaultRotateEveryBytes)
+		rotator.RotateEveryBytes = &rotateEveryBytes
+	}
+	if rotator.RotateOnStartup == nil {
+		rotateOnStartup := false
+		rotator.RotateOnStartup = &rotateOnStartup
+	}
+
+	if *rotator.KeepFiles < 2 || *rotator.KeepFiles >= RotatorMaxFiles {
+		return fmt.Errorf("the number of files to keep should be between 2 and %d", RotatorMaxFiles-1)
+	}
+	if *rotator.RotateEveryBytes == 0 {
+		return errors.New("rotateeverybytes must be greater than 0")
+	}
+
+	if rotator.Interval != "" {
+		interval, err := time.ParseDuration(rotator.Interval)
+		if err != nil {
+			return fmt.Errorf("failed to parse interval '%s': %v", rotator.Interval, err)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("interval must be greater than 0, got '%s'", rotator.Interval)
+		}
+		rotator.IntervalDuration = interval
+	}
+	return nil
+}
+
+// CreateDirectory 创建日志文件所在的目录
+func (rotator *FileRotator) CreateDirectory() error {
+	fileinfo, err := os.Stat(rotator.Path)
+	if err == nil {
+		if !fileinfo.IsDir() {
+			return fmt.Errorf("%s exists but it's not a directory", rotator.Path)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return os.MkdirAll(rotator.Path, 0755)
+}
+
+// Write 将 data 追加到日志文件中，写入前检查是否需要切割。
+// 一次写入的内容不会被拆分到两个文件中，所以文件的大小可能会超过 RotateEveryBytes
+func (rotator *FileRotator) Write(data []byte) (int, error) {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	if rotator.current == nil {
+		if err := rotator.openCurrent(); err != nil {
+			return 0, err
+		}
+	}
+
+	if rotator.shouldRotate(uint64(len(data))) {
+		if err := rotator.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rotator.current.Write(data)
+	rotator.currentSize += uint64(n)
+	return n, err
+}
+
+// Rotate 立即切割日志文件
+func (rotator *FileRotator) Rotate() error {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	if rotator.current == nil {
+		if err := rotator.openCurrent(); err != nil {
+			return err
+		}
+	}
+	return rotator.rotate()
+}
+
+// Close 关闭当前的日志文件，之后的写入会重新打开文件
+func (rotator *FileRotator) Close() error {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	if rotator.current == nil {
+		return nil
+	}
+	err := rotator.current.Close()
+	rotator.current = nil
+	return err
+}
+
+// shouldRotate 空文件不会被切割
+func (rotator *FileRotator) shouldRotate(size uint64) bool {
+	if rotator.currentSize == 0 {
+		return false
+	}
+	if rotator.RotateEveryBytes != nil && rotator.currentSize+size > *rotator.RotateEveryBytes {
+		return true
+	}
+	return rotator.IntervalDuration > 0 && currentTime().Sub(rotator.openTime) >= rotator.IntervalDuration
+}
+
+func (rotator *FileRotator) filePath(fileNo int) string {
+	if fileNo == 0 {
+		return filepath.Join(rotator.Path, rotator.Name)
+	}
+	return filepath.Join(rotator.Path, rotator.Name+"."+strconv.Itoa(fileNo))
+}
+
+// openCurrent 打开日志文件，第一次打开时按照 RotateOnStartup 切割已经存在的文件
+func (rotator *FileRotator) openCurrent() error {
+	if !rotator.started {
+		rotator.started = true
+		if rotator.RotateOnStartup != nil && *rotator.RotateOnStartup {
+			info, err := os.Stat(rotator.filePath(0))
+			if err == nil && info.Size() > 0 {
+				if err := rotator.shiftFiles(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	file, err := os.OpenFile(rotator.filePath(0), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rotator.current = file
+	rotator.currentSize = uint64(info.Size())
+	rotator.openTime = currentTime()
+	return nil
+}
+
+// rotate 关闭当前的文件，重命名所有的文件，然后打开新的文件
+func (rotator *FileRotator) rotate() error {
+	if err := rotator.current.Close(); err != nil {
+		return err
+	}
+	rotator.current = nil
+
+	if err := rotator.shiftFiles(); err != nil {
+		return err
+	}
+	return rotator.openCurrent()
+}
+
+// shiftFiles 删除最老的文件，并将 Name.i 重命名为 Name.i+1，Name 重命名为 Name.1
+func (rotator *FileRotator) shiftFiles() error {
+	keepFiles := DefaultKeepFiles
+	if rotator.KeepFiles != nil {
+		keepFiles = *rotator.KeepFiles
+	}
+
+	oldest := rotator.filePath(keepFiles - 1)
+	if err := os.Remove(oldest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for fileNo := keepFiles - 2; fileNo >= 0; fileNo-- {
+		path := rotator.filePath(fileNo)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(path, rotator.filePath(fileNo+1)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,248 @@
+package logp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRotator(t *testing.T, rotateEveryBytes uint64, keepFiles int) (*FileRotator, func()) {
+	dir, err := ioutil.TempDir("", "rotator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotator := &FileRotator{
+		Path:             dir,
+		Name:             "testbeat",
+		RotateEveryBytes: &rotateEveryBytes,
+		KeepFiles:        &keepFiles,
+	}
+	cleanup := func() {
+		rotator.Close()
+		os.RemoveAll(dir)
+	}
+	return rotator, cleanup
+}
+
+// listFiles 返回目录中的文件名和内容
+func listFiles(t *testing.T, dir string) map[string]string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, info := range infos {
+		data, err := ioutil.ReadFile(filepath.Join(dir, info.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[info.Name()] = string(data)
+	}
+	return files
+}
+
+func writeString(t *testing.T, rotator *FileRotator, s string) {
+	n, err := rotator.Write([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(s) {
+		t.Fatalf("expected %d bytes written, got %d", len(s), n)
+	}
+}
+
+func TestFileRotator_CheckIfConfigSane(t *testing.T) {
+	rotator := &FileRotator{Name: "testbeat"}
+	if err := rotator.CheckIfConfigSane(); err != nil {
+		t.Fatal(err)
+	}
+	if *rotator.KeepFiles != DefaultKeepFiles || *rotator.RotateEveryBytes != DefaultRotateEveryBytes ||
+		*rotator.RotateOnStartup || rotator.IntervalDuration != 0 {
+		t.Errorf("unexpected defaults %+v", rotator)
+	}
+
+	rotator = &FileRotator{Name: "testbeat", Interval: "1h"}
+	if err := rotator.CheckIfConfigSane(); err != nil || rotator.IntervalDuration != time.Hour {
+		t.Errorf("unexpected interval %v, %v", rotator.IntervalDuration, err)
+	}
+
+	one, tooMany := 1, RotatorMaxFiles
+	var zero uint64
+	invalid := []*FileRotator{
+		{},
+		{Name: "testbeat", KeepFiles: &one},
+		{Name: "testbeat", KeepFiles: &tooMany},
+		{Name: "testbeat", RotateEveryBytes: &zero},
+		{Name: "testbeat", Interval: "daily"},
+		{Name: "testbeat", Interval: "-1h"},
+	}
+	for i, rotator := range invalid {
+		if err := rotator.CheckIfConfigSane(); err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
+
+func TestFileRotator_RotateBySize(t *testing.T) {
+	rotator, cleanup := newTestRotator(t, 10, 3)
+	defer cleanup()
+
+	// 每个文件最多 10 个字节，保留 testbeat, testbeat.1 和 testbeat.2
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "ffff\n", "gggg\n"} {
+		writeString(t, rotator, line)
+	}
+
+	expected := map[string]string{
+		"testbeat":   "gggg\n",
+		"testbeat.1": "eeee\nffff\n",
+		"testbeat.2": "cccc\ndddd\n",
+	}
+	files := listFiles(t, rotator.Path)
+	if len(files) != len(expected) {
+		t.Fatalf("unexpected files %v", files)
+	}
+	for name, content := range expected {
+		if files[name] != content {
+			t.Errorf("%s: expected %q, got %q", name, content, files[name])
+		}
+	}
+}
+
+func TestFileRotator_LargeWrite(t *testing.T) {
+	rotator, cleanup := newTestRotator(t, 4, 2)
+	defer cleanup()
+
+	// 超过 RotateEveryBytes 的写入不会被拆分，空文件也不会被切割
+	writeString(t, rotator, "0123456789\n")
+	writeString(t, rotator, "abc\n")
+
+	files := listFiles(t, rotator.Path)
+	if len(files) != 2 || files["testbeat.1"] != "0123456789\n" || files["testbeat"] != "abc\n" {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestFileRotator_RotateOnStartup(t *testing.T) {
+	rotator, cleanup := newTestRotator(t, 1024, 3)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(rotator.filePath(0), []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 默认在已经存在的文件后面追加
+	writeString(t, rotator, "first\n")
+	rotator.Close()
+	if files := listFiles(t, rotator.Path); len(files) != 1 || files["testbeat"] != "old\nfirst\n" {
+		t.Fatalf("unexpected files %v", files)
+	}
+
+	rotateOnStartup := true
+	restarted := &FileRotator{
+		Path:             rotator.Path,
+		Name:             rotator.Name,
+		RotateEveryBytes: rotator.RotateEveryBytes,
+		KeepFiles:        rotator.KeepFiles,
+		RotateOnStartup:  &rotateOnStartup,
+	}
+	defer restarted.Close()
+	writeString(t, restarted, "second\n")
+
+	// 只有第一次打开文件时切割
+	restarted.Close()
+	writeString(t, restarted, "third\n")
+
+	files := listFiles(t, rotator.Path)
+	if len(files) != 2 || files["testbeat.1"] != "old\nfirst\n" || files["testbeat"] != "second\nthird\n" {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestFileRotator_RotateByInterval(t *testing.T) {
+	now := time.Date(2015, 11, 24, 10, 0, 0, 0, time.UTC)
+	currentTime = func() time.Time { return now }
+	defer func() { currentTime = time.Now }()
+
+	rotator, cleanup := newTestRotator(t, 1024, 5)
+	defer cleanup()
+	rotator.Interval = "1h"
+	if err := rotator.CheckIfConfigSane(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeString(t, rotator, "a\n")
+	now = now.Add(59 * time.Minute)
+	writeString(t, rotator, "b\n")
+	now = now.Add(time.Minute)
+	writeString(t, rotator, "c\n")
+	now = now.Add(30 * time.Minute)
+	writeString(t, rotator, "d\n")
+
+	files := listFiles(t, rotator.Path)
+	if len(files) != 2 || files["testbeat.1"] != "a\nb\n" || files["testbeat"] != "c\nd\n" {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestFileRotator_ConcurrentWrites(t *testing.T) {
+	rotator, cleanup := newTestRotator(t, 100, 1023)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				rotator.Write([]byte(fmt.Sprintf("writer %d line %02d\n", i, j)))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 所有的行都完整的写入到了某个文件中，并且没有文件超过限制
+	var lines []string
+	for name, content := range listFiles(t, rotator.Path) {
+		if len(content) > 100 {
+			t.Errorf("%s: %d bytes exceeds limit", name, len(content))
+		}
+		lines = append(lines, strings.Split(strings.TrimSuffix(content, "\n"), "\n")...)
+	}
+	if len(lines) != 8*50 {
+		t.Fatalf("expected %d lines, got %d", 8*50, len(lines))
+	}
+	sort.Strings(lines)
+	for i := 1; i < len(lines); i++ {
+		if lines[i] == lines[i-1] {
+			t.Errorf("duplicated line %q", lines[i])
+		}
+	}
+}
+
+func TestInit_FileRotation(t *testing.T) {
+	var rotateEveryBytes uint64 = 200
+	keepFiles := 2
+	read, cleanup := initFileLogging(t, Logging{
+		Level: "info",
+		Files: &FileRotator{RotateEveryBytes: &rotateEveryBytes, KeepFiles: &keepFiles},
+	}, false, nil)
+	defer cleanup()
+
+	for i := 0; i < 20; i++ {
+		Info("message %d", i)
+	}
+
+	logs := read()
+	if !strings.Contains(logs, "message 19") || strings.Contains(logs, "message 0\n") {
+		t.Errorf("unexpected logs:\n%s", logs)
+	}
+	if files := listFiles(t, _log.rotator.Path); len(files) != 2 {
+		t.Errorf("unexpected files %v", files)
+	}
+}
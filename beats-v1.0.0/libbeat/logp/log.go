@@ -1,6 +1,16 @@
 package logp
 
-import "log"
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+)
 
 type Priority int
 
@@ -16,18 +26,321 @@ const (
 	LOG_DEBUG
 )
 
-type Logger struct {
-	toFile bool
-	level  Priority
+// 输出到 stderr 和文件时每行的前缀
+const stderrFlags = log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile
 
-	logger  *log.Logger
-	rotator *FileRotator
+// 文本格式中每个级别的前缀和 JSON 格式中 level 字段的值
+var levelPrefixes = map[Priority]string{
+	LOG_CRIT:    "CRIT ",
+	LOG_ERR:     "ERR  ",
+	LOG_WARNING: "WARN ",
+	LOG_INFO:    "INFO ",
+	LOG_DEBUG:   "DBG  ",
 }
 
-var _log Logger
+var levelNames = map[Priority]string{
+	LOG_CRIT:    "critical",
+	LOG_ERR:     "error",
+	LOG_WARNING: "warning",
+	LOG_INFO:    "info",
+	LOG_DEBUG:   "debug",
+}
+
+// JSON 格式中 timestamp 字段的格式
+const jsonTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Fields 是附加到一条日志中的结构化字段。
+// 文本格式中以 key=value 的形式添加到消息后面，JSON 格式中保存在 fields 字段中
+// Fields are structured key/value pairs attached to a log message
+type Fields map[string]interface{}
+
+type Logger struct {
+	toSyslog bool
+	toStderr bool
+	toFile   bool
+	json     bool // 每条日志输出为一行 JSON
+	level    Priority
+
+	selectors         map[string]bool // 开启了 debug 日志的 selector
+	debugAllSelectors bool            // selector 中包含 "*" 时开启所有的 debug 日志
+
+	logger     *log.Logger
+	syslog     [LOG_DEBUG + 1]*log.Logger
+	fileLogger *log.Logger
+	rotator    *FileRotator
+}
 
-func LogInit(level Priority, prefix string) {
+// 调用 LogInit 之前只输出错误日志到 stderr
+var _log = Logger{
+	toStderr: true,
+	level:    LOG_ERR,
+	logger:   log.New(os.Stderr, "", stderrFlags),
+}
 
+// LogInit 设置日志级别、输出的位置以及开启 debug 日志的 selector。
+// 输出到文件需要再调用 SetToFile
+func LogInit(level Priority, prefix string, toSyslog bool, toStderr bool, debugSelectors []string) {
+	_log.toSyslog = toSyslog
+	_log.toStderr = toStderr
+	_log.toFile = false
+	_log.json = false
 	_log.level = level
-	
+
+	_log.selectors, _log.debugAllSelectors = parseSelectors(debugSelectors)
+
+	if _log.toSyslog {
+		for p := LOG_EMERG; p <= LOG_DEBUG; p++ {
+			_log.syslog[p] = openSyslog(p, prefix)
+			if _log.syslog[p] == nil {
+				// syslog 不可用时改为输出到 stderr
+				_log.toSyslog = false
+				_log.toStderr = true
+				break
+			}
+		}
+	}
+	_log.logger = log.New(os.Stderr, prefix, stderrFlags)
+}
+
+func parseSelectors(selectors []string) (map[string]bool, bool) {
+	all := false
+	m := map[string]bool{}
+	for _, selector := range selectors {
+		m[selector] = true
+		if selector == "*" {
+			all = true
+		}
+	}
+	return m, all
+}
+
+// SetToFile 开启或者关闭输出到文件，rotator 为写入的文件
+func SetToFile(toFile bool, rotator *FileRotator) {
+	_log.toFile = toFile
+	_log.rotator = rotator
+	_log.fileLogger = nil
+	if toFile {
+		_log.fileLogger = log.New(rotator, "", _log.flags())
+	}
+}
+
+// SetJSON 开启或者关闭 JSON 格式的日志，JSON 格式中的时间和调用位置由 logp 添加
+func SetJSON(json bool) {
+	_log.json = json
+	flags := _log.flags()
+	if _log.logger != nil {
+		_log.logger.SetFlags(flags)
+	}
+	if _log.fileLogger != nil {
+		_log.fileLogger.SetFlags(flags)
+	}
+	for _, logger := range _log.syslog {
+		if logger == nil {
+			continue
+		}
+		if json {
+			logger.SetFlags(0)
+		} else {
+			logger.SetFlags(log.Lshortfile)
+		}
+	}
+}
+
+func (l *Logger) flags() int {
+	if l.json {
+		return 0
+	}
+	return stderrFlags
+}
+
+// Reopen 关闭当前的日志文件，下一次写入时重新打开。
+// 外部工具 (比如 logrotate) 重命名了日志文件之后调用，之后的日志会写入新的文件
+// Reopen closes the current log file so the next write creates it again
+func Reopen() error {
+	if !_log.toFile || _log.rotator == nil {
+		return nil
+	}
+	return _log.rotator.Close()
+}
+
+// debugMessage 输出 selector 的 debug 日志，calldepth 是调用方所在的栈的深度
+func debugMessage(calldepth int, selector string, fields Fields, format string, v ...interface{}) {
+	if IsDebug(selector) {
+		send(calldepth+1, LOG_DEBUG, selector, fields, format, v...)
+	}
+}
+
+// send 将日志格式化后输出到所有开启的位置
+func send(calldepth int, level Priority, selector string, fields Fields, format string, v ...interface{}) {
+	var message string
+	if _log.json {
+		message = formatJSON(calldepth, level, selector, fields, fmt.Sprintf(format, v...))
+	} else {
+		message = formatText(level, selector, fields, fmt.Sprintf(format, v...))
+	}
+
+	if _log.toSyslog {
+		_log.syslog[level].Output(calldepth, message)
+	}
+	if _log.toStderr {
+		_log.logger.Output(calldepth, message)
+	}
+	if _log.toFile {
+		_log.fileLogger.Output(calldepth, message)
+	}
+}
+
+// Debug 在日志级别为 debug 并且开启了 selector 时输出日志
+func Debug(selector string, format string, v ...interface{}) {
+	debugMessage(3, selector, nil, format, v...)
+}
+
+// MakeDebug 返回一个只输出 selector 的 debug 日志的函数
+func MakeDebug(selector string) func(string, ...interface{}) {
+	return func(msg string, v ...interface{}) {
+		debugMessage(3, selector, nil, msg, v...)
+	}
+}
+
+// IsDebug 返回 selector 的 debug 日志是否会被输出，可以用来避免准备日志内容的开销
+func IsDebug(selector string) bool {
+	return _log.level >= LOG_DEBUG && (_log.debugAllSelectors || _log.selectors[selector])
+}
+
+func msg(level Priority, fields Fields, format string, v ...interface{}) {
+	if _log.level >= level {
+		send(4, level, "", fields, format, v...)
+	}
+}
+
+// Info 输出 info 级别的日志
+func Info(format string, v ...interface{}) {
+	msg(LOG_INFO, nil, format, v...)
+}
+
+// Warn 输出 warning 级别的日志
+func Warn(format string, v ...interface{}) {
+	msg(LOG_WARNING, nil, format, v...)
+}
+
+// Err 输出 error 级别的日志
+func Err(format string, v ...interface{}) {
+	msg(LOG_ERR, nil, format, v...)
+}
+
+// Critical 输出 critical 级别的日志
+func Critical(format string, v ...interface{}) {
+	msg(LOG_CRIT, nil, format, v...)
+}
+
+// Recover 捕获 panic，记录错误日志和调用栈，需要在 defer 中调用
+func Recover(message string) {
+	if r := recover(); r != nil {
+		msg(LOG_ERR, nil, "%s. Recovering, but please report this: %v.", message, r)
+		msg(LOG_ERR, nil, "Stacktrace: %s", debug.Stack())
+	}
+}
+
+// Entry 输出带有结构化字段的日志
+// Entry logs messages with the attached structured fields
+type Entry struct {
+	fields Fields
+}
+
+// WithFields 返回输出日志时附加 fields 的 Entry，例如:
+//
+//	logp.WithFields(logp.Fields{"source": path, "offset": offset}).Info("Harvester started")
+func WithFields(fields Fields) *Entry {
+	return &Entry{fields: fields}
+}
+
+// Debug 在日志级别为 debug 并且开启了 selector 时输出日志
+func (e *Entry) Debug(selector string, format string, v ...interface{}) {
+	debugMessage(3, selector, e.fields, format, v...)
+}
+
+// Info 输出 info 级别的日志
+func (e *Entry) Info(format string, v ...interface{}) {
+	msg(LOG_INFO, e.fields, format, v...)
+}
+
+// Warn 输出 warning 级别的日志
+func (e *Entry) Warn(format string, v ...interface{}) {
+	msg(LOG_WARNING, e.fields, format, v...)
+}
+
+// Err 输出 error 级别的日志
+func (e *Entry) Err(format string, v ...interface{}) {
+	msg(LOG_ERR, e.fields, format, v...)
+}
+
+// Critical 输出 critical 级别的日志
+func (e *Entry) Critical(format string, v ...interface{}) {
+	msg(LOG_CRIT, e.fields, format, v...)
+}
+
+// formatText 格式化为 "INFO message key=value" 的形式，debug 日志在消息前加上 [selector]，
+// 时间和调用位置由 log.Logger 添加
+func formatText(level Priority, selector string, fields Fields, message string) string {
+	text := levelPrefixes[level]
+	if selector != "" {
+		text += "[" + selector + "] "
+	}
+	text += message
+	for _, key := range sortedKeys(fields) {
+		text += fmt.Sprintf(" %s=%v", key, fields[key])
+	}
+	return text
+}
+
+// formatJSON 格式化为一行 JSON，calldepth 和 log.Logger.Output 中的含义相同
+func formatJSON(calldepth int, level Priority, selector string, fields Fields, message string) string {
+	line := map[string]interface{}{
+		"timestamp": time.Now().UTC().Format(jsonTimeFormat),
+		"level":     levelNames[level],
+		"message":   message,
+	}
+	if selector != "" {
+		line["selector"] = selector
+	}
+	// 和 log.Logger.Output 一样由 send 调用，runtime.Caller 返回的路径总是以 / 分隔
+	if _, file, lineNo, ok := runtime.Caller(calldepth); ok {
+		line["caller"] = fmt.Sprintf("%s:%d", path.Join(path.Base(path.Dir(file)), path.Base(file)), lineNo)
+	}
+	if len(fields) > 0 {
+		line["fields"] = jsonFields(fields)
+	}
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		// 字段中有不能编码的值时，将所有字段转换为字符串
+		strFields := map[string]string{}
+		for key, value := range fields {
+			strFields[key] = fmt.Sprint(value)
+		}
+		line["fields"] = strFields
+		data, _ = json.Marshal(line)
+	}
+	return string(data)
+}
+
+// jsonFields 将 error 类型的值转换为错误信息，否则它们会被编码为 {}
+func jsonFields(fields Fields) map[string]interface{} {
+	m := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		m[key] = value
+	}
+	return m
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
@@ -2,26 +2,49 @@ package logp
 
 import (
 	"fmt"
+	"github.com/ssp4599815/beat/libbeat/paths"
 	"runtime"
 	"strings"
 )
 
-
+// Logging 是配置文件中 logging 部分的配置
 type Logging struct {
-	Selectors []string
-	Files     *FileRotator
-	ToSyslog  *bool // 日志输出到 syslog 中
-	ToFiles   *bool // 日志输出到文件中
-	Level     string
+	Selectors []string      // 开启 debug 日志的 selector，"*" 表示所有
+	Files     *FileRotator  // 输出到文件时的配置
+	ToSyslog  *bool         `yaml:"to_syslog"` // 日志输出到 syslog 中
+	ToFiles   *bool         `yaml:"to_files"`  // 日志输出到文件中
+	Level     string        // critical, error, warning, info 或者 debug
+	JSON      bool          `yaml:"json"` // 每条日志输出为一行 JSON，方便其他程序解析
+	Metrics   MetricsConfig // 定期输出内部指标
+}
+
+// MetricsConfig 是定期将内部指标输出到日志中的配置
+type MetricsConfig struct {
+	Enabled *bool  // 默认开启
+	Period  string // 输出的间隔，默认为 30s
 }
 
-// 初始化日志系统
-func Init(name string, config *Logging) error {
+// 初始化日志系统。
+// toStderr 和 debugSelectors 来自命令行的 -e 和 -d 参数：-e 时只输出到 stderr，
+// -d 指定了 selector 时日志级别为 debug，selector 和配置文件中的合并
+// Init initializes the logging of the beat from its config and command line flags
+func Init(name string, config *Logging, toStderr bool, debugSelectors []string) error {
+	if config == nil {
+		config = &Logging{}
+	}
 	logLevel, err := getLogLevel(config)
 	if err != nil {
 		return err
 	}
 
+	selectors := append(append([]string{}, config.Selectors...), debugSelectors...)
+	if len(debugSelectors) > 0 {
+		logLevel = LOG_DEBUG
+	}
+	if logLevel == LOG_DEBUG && len(selectors) == 0 {
+		selectors = []string{"*"}
+	}
+
 	// 决定 日志是输出到 文件 还是 syslog
 	var defaultToFiles, defaultToSyslog bool
 	var defaultFilePath string
@@ -36,6 +59,11 @@ func Init(name string, config *Logging) error {
 		defaultToFiles = false
 		defaultFilePath = fmt.Sprintf("/var/log/%s", name)
 	}
+	// 设置了 -path.logs 或者 -path.home 时默认写入 logs 目录
+	if paths.Paths.Logs != "" {
+		defaultFilePath = paths.Paths.Logs
+	}
+
 	var toSyslog, toFiles bool
 	if config.ToSyslog != nil {
 		toSyslog = *config.ToSyslog
@@ -48,7 +76,46 @@ func Init(name string, config *Logging) error {
 		toFiles = defaultToFiles
 	}
 
+	// -e 时禁用 syslog 和文件
+	if toStderr {
+		toSyslog = false
+		toFiles = false
+	}
+
+	var rotator *FileRotator
+	if toFiles {
+		rotator = &FileRotator{}
+		if config.Files != nil {
+			rotator.Path = config.Files.Path
+			rotator.Name = config.Files.Name
+			rotator.RotateEveryBytes = config.Files.RotateEveryBytes
+			rotator.KeepFiles = config.Files.KeepFiles
+			rotator.RotateOnStartup = config.Files.RotateOnStartup
+			rotator.Interval = config.Files.Interval
+		}
+		if rotator.Path == "" {
+			rotator.Path = defaultFilePath
+		} else if paths.Paths.Logs != "" {
+			rotator.Path = paths.Resolve(paths.Logs, rotator.Path)
+		}
+		if rotator.Name == "" {
+			rotator.Name = name
+		}
 
+		if err := rotator.CheckIfConfigSane(); err != nil {
+			return err
+		}
+		if err := rotator.CreateDirectory(); err != nil {
+			return err
+		}
+	}
+
+	LogInit(logLevel, "", toSyslog, toStderr || (!toSyslog && !toFiles), selectors)
+	if toFiles {
+		SetToFile(true, rotator)
+	}
+	SetJSON(config.JSON)
+	return nil
 }
 
 // Priority 优先级
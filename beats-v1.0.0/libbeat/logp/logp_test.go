@@ -0,0 +1,248 @@
+package logp
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// initFileLogging 将日志输出到临时目录中的文件，返回读取文件内容的函数
+func initFileLogging(t *testing.T, config Logging, toStderr bool, debugSelectors []string) (func() string, func()) {
+	dir, err := ioutil.TempDir("", "logp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	toFiles, toSyslog := true, false
+	config.ToFiles = &toFiles
+	config.ToSyslog = &toSyslog
+	if config.Files == nil {
+		config.Files = &FileRotator{}
+	}
+	config.Files.Path = filepath.Join(dir, "logs")
+	config.Files.Name = "testbeat"
+	if err := Init("testbeat", &config, toStderr, debugSelectors); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	read := func() string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "logs", "testbeat"))
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	cleanup := func() {
+		if _log.rotator != nil {
+			_log.rotator.Close()
+		}
+		LogInit(LOG_ERR, "", false, true, nil)
+		os.RemoveAll(dir)
+	}
+	return read, cleanup
+}
+
+func TestInit_Levels(t *testing.T) {
+	read, cleanup := initFileLogging(t, Logging{Level: "warning"}, false, nil)
+	defer cleanup()
+
+	Debug("test", "debug message")
+	Info("info message")
+	Warn("warn message %d", 1)
+	Err("err message")
+	Critical("critical message")
+
+	logs := read()
+	for _, message := range []string{"WARN warn message 1", "ERR  err message", "CRIT critical message"} {
+		if !strings.Contains(logs, message) {
+			t.Errorf("expected '%s' in logs:\n%s", message, logs)
+		}
+	}
+	for _, message := range []string{"debug message", "info message"} {
+		if strings.Contains(logs, message) {
+			t.Errorf("unexpected '%s' in logs:\n%s", message, logs)
+		}
+	}
+	// 记录的是调用方的位置
+	if !strings.Contains(logs, "logp_test.go:") {
+		t.Errorf("expected caller in logs:\n%s", logs)
+	}
+}
+
+func TestInit_Selectors(t *testing.T) {
+	read, cleanup := initFileLogging(t, Logging{Selectors: []string{"publish"}}, false, []string{"crawler"})
+	defer cleanup()
+
+	// -d 指定了 selector 时日志级别为 debug，配置文件中的 selector 同样生效
+	debug := MakeDebug("publish")
+	Debug("crawler", "crawler message")
+	debug("publish message")
+	Debug("harvester", "harvester message")
+	Info("info message")
+
+	if !IsDebug("crawler") || IsDebug("harvester") {
+		t.Error("unexpected IsDebug result")
+	}
+	logs := read()
+	for _, message := range []string{"DBG  [crawler] crawler message", "DBG  [publish] publish message", "INFO info message"} {
+		if !strings.Contains(logs, message) {
+			t.Errorf("expected '%s' in logs:\n%s", message, logs)
+		}
+	}
+	if strings.Contains(logs, "harvester message") {
+		t.Errorf("unexpected harvester message in logs:\n%s", logs)
+	}
+}
+
+func TestInit_AllSelectors(t *testing.T) {
+	read, cleanup := initFileLogging(t, Logging{Level: "debug"}, false, nil)
+	defer cleanup()
+
+	Debug("anything", "debug message")
+	if !strings.Contains(read(), "DBG  [anything] debug message") {
+		t.Errorf("expected debug message in logs:\n%s", read())
+	}
+}
+
+func TestInit_ToStderr(t *testing.T) {
+	read, cleanup := initFileLogging(t, Logging{}, true, nil)
+	defer cleanup()
+
+	// -e 时不写入文件
+	Err("err message")
+	if logs := read(); logs != "" {
+		t.Errorf("expected no file logs, got:\n%s", logs)
+	}
+	if !_log.toStderr || _log.toFile || _log.toSyslog {
+		t.Errorf("expected only stderr output: %+v", _log)
+	}
+}
+
+func TestInit_Errors(t *testing.T) {
+	defer LogInit(LOG_ERR, "", false, true, nil)
+
+	toFiles := true
+	keepFiles := 1
+	configs := []Logging{
+		{Level: "verbose"},
+		{ToFiles: &toFiles, Files: &FileRotator{Path: os.TempDir(), Name: "testbeat", KeepFiles: &keepFiles}},
+	}
+	for i, config := range configs {
+		if err := Init("testbeat", &config, false, nil); err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	read, cleanup := initFileLogging(t, Logging{}, false, nil)
+	defer cleanup()
+
+	func() {
+		defer Recover("test panic")
+		panic("boom")
+	}()
+	if logs := read(); !strings.Contains(logs, "test panic. Recovering, but please report this: boom.") {
+		t.Errorf("expected recovered panic in logs:\n%s", logs)
+	}
+}
+
+func TestWithFields_Text(t *testing.T) {
+	read, cleanup := initFileLogging(t, Logging{Level: "debug"}, false, nil)
+	defer cleanup()
+
+	WithFields(Fields{"source": "/var/log/messages", "offset": 42}).Info("Harvester started")
+	WithFields(Fields{"count": 3}).Debug("spooler", "Flushing spooler")
+
+	logs := read()
+	for _, message := range []string{
+		"INFO Harvester started offset=42 source=/var/log/messages",
+		"DBG  [spooler] Flushing spooler count=3",
+	} {
+		if !strings.Contains(logs, message) {
+			t.Errorf("expected '%s' in logs:\n%s", message, logs)
+		}
+	}
+}
+
+func TestInit_JSON(t *testing.T) {
+	read, cleanup := initFileLogging(t, Logging{Level: "debug", JSON: true}, false, nil)
+	defer cleanup()
+
+	Info("info %s", "message")
+	WithFields(Fields{"source": "/var/log/messages", "error": errors.New("read failed")}).Err("Stop harvesting")
+	Debug("crawler", "debug message")
+
+	lines := strings.Split(strings.TrimSpace(read()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got:\n%s", strings.Join(lines, "\n"))
+	}
+
+	var records []map[string]interface{}
+	for _, line := range lines {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON line %s: %v", line, err)
+		}
+		if _, err := time.Parse(jsonTimeFormat, record["timestamp"].(string)); err != nil {
+			t.Errorf("invalid timestamp in %s: %v", line, err)
+		}
+		// 记录的是调用方的位置
+		if caller, _ := record["caller"].(string); !strings.HasPrefix(caller, "logp/logp_test.go:") {
+			t.Errorf("unexpected caller in %s", line)
+		}
+		records = append(records, record)
+	}
+
+	if records[0]["level"] != "info" || records[0]["message"] != "info message" || records[0]["fields"] != nil {
+		t.Errorf("unexpected record %v", records[0])
+	}
+	fields, _ := records[1]["fields"].(map[string]interface{})
+	if records[1]["level"] != "error" || fields["source"] != "/var/log/messages" || fields["error"] != "read failed" {
+		t.Errorf("unexpected record %v", records[1])
+	}
+	if records[2]["level"] != "debug" || records[2]["selector"] != "crawler" {
+		t.Errorf("unexpected record %v", records[2])
+	}
+}
+
+func TestFormatJSON_InvalidFields(t *testing.T) {
+	line := formatJSON(1, LOG_INFO, "", Fields{"ch": make(chan int), "n": 1}, "message")
+
+	var record struct {
+		Fields map[string]string
+	}
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatalf("invalid JSON line %s: %v", line, err)
+	}
+	if record.Fields["n"] != "1" || !strings.HasPrefix(record.Fields["ch"], "0x") {
+		t.Errorf("unexpected fields %v", record.Fields)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	read, cleanup := initFileLogging(t, Logging{Level: "info"}, false, nil)
+	defer cleanup()
+
+	Info("before rotation")
+
+	// 模拟 logrotate 重命名日志文件
+	current := filepath.Join(_log.rotator.Path, "testbeat")
+	if err := os.Rename(current, current+".old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	Info("after rotation")
+
+	if logs := read(); strings.Contains(logs, "before rotation") || !strings.Contains(logs, "after rotation") {
+		t.Errorf("unexpected logs:\n%s", logs)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows || nacl || plan9
+// +build windows nacl plan9
+
+package logp
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// openSyslog 在不支持 syslog 的系统上总是返回 nil
+func openSyslog(level Priority, prefix string) *log.Logger {
+	fmt.Fprintln(os.Stderr, "Syslog is not supported on this OS")
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package logp
+
+import (
+	"fmt"
+	"log"
+	"log/syslog"
+	"os"
+)
+
+// openSyslog 创建写入 syslog 的 logger，syslog 不可用时返回 nil
+func openSyslog(level Priority, prefix string) *log.Logger {
+	logger, err := syslog.NewLogger(syslog.Priority(level)|syslog.LOG_USER, log.Lshortfile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening syslog: ", err)
+		return nil
+	}
+	logger.SetPrefix(prefix)
+	return logger
+}
@@ -0,0 +1,145 @@
+package monitoring
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter 是只增加的计数器，比如读取的行数
+// Counter is a monotonically increasing metric
+type Counter struct {
+	value int64
+}
+
+// Add 增加 delta，delta 不能为负数
+func (c *Counter) Add(delta int64) {
+	atomic.AddInt64(&c.value, delta)
+}
+
+// Inc 增加 1
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.value, 1)
+}
+
+// Get 返回当前的值
+func (c *Counter) Get() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+// Gauge 是可以增加也可以减少的值，比如打开的文件数量
+// Gauge is a metric that can go up and down
+type Gauge struct {
+	value int64
+}
+
+// Set 设置当前的值
+func (g *Gauge) Set(value int64) {
+	atomic.StoreInt64(&g.value, value)
+}
+
+// Add 增加 delta，delta 为负数时减少
+func (g *Gauge) Add(delta int64) {
+	atomic.AddInt64(&g.value, delta)
+}
+
+// Inc 增加 1
+func (g *Gauge) Inc() {
+	atomic.AddInt64(&g.value, 1)
+}
+
+// Dec 减少 1
+func (g *Gauge) Dec() {
+	atomic.AddInt64(&g.value, -1)
+}
+
+// Get 返回当前的值
+func (g *Gauge) Get() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+// Registry 按名称保存所有的指标，名称使用 . 分隔，比如 filebeat.harvester.open_files
+// Registry holds named counters and gauges
+type Registry struct {
+	mutex    sync.RWMutex
+	counters map[string]*Counter
+	gauges   map[string]*Gauge
+}
+
+// Snapshot 是某一时刻所有指标的值
+type Snapshot struct {
+	Counters map[string]int64
+	Gauges   map[string]int64
+}
+
+// Default 是 NewCounter 和 NewGauge 使用的全局的 Registry
+var Default = NewRegistry()
+
+// NewRegistry 创建一个空的 Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		counters: map[string]*Counter{},
+		gauges:   map[string]*Gauge{},
+	}
+}
+
+// NewCounter 在 Default 中注册一个计数器
+func NewCounter(name string) *Counter {
+	return Default.NewCounter(name)
+}
+
+// NewGauge 在 Default 中注册一个 gauge
+func NewGauge(name string) *Gauge {
+	return Default.NewGauge(name)
+}
+
+// NewCounter 注册一个计数器，指标一般在包初始化时注册，所以名称重复时直接 panic
+func (r *Registry) NewCounter(name string) *Counter {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.checkName(name)
+	c := &Counter{}
+	r.counters[name] = c
+	return c
+}
+
+// NewGauge 注册一个 gauge，名称重复时直接 panic
+func (r *Registry) NewGauge(name string) *Gauge {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.checkName(name)
+	g := &Gauge{}
+	r.gauges[name] = g
+	return g
+}
+
+func (r *Registry) checkName(name string) {
+	if name == "" {
+		panic("monitoring: metric name must not be empty")
+	}
+	_, isCounter := r.counters[name]
+	_, isGauge := r.gauges[name]
+	if isCounter || isGauge {
+		panic(fmt.Sprintf("monitoring: metric %s already registered", name))
+	}
+}
+
+// Snapshot 返回所有指标当前的值
+func (r *Registry) Snapshot() Snapshot {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	s := Snapshot{
+		Counters: make(map[string]int64, len(r.counters)),
+		Gauges:   make(map[string]int64, len(r.gauges)),
+	}
+	for name, c := range r.counters {
+		s.Counters[name] = c.Get()
+	}
+	for name, g := range r.gauges {
+		s.Gauges[name] = g.Get()
+	}
+	return s
+}
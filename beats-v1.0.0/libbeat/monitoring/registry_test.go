@@ -0,0 +1,60 @@
+package monitoring
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRegistry_Snapshot(t *testing.T) {
+	r := NewRegistry()
+	lines := r.NewCounter("harvester.lines_read")
+	files := r.NewGauge("harvester.open_files")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				lines.Inc()
+			}
+			lines.Add(5)
+			files.Inc()
+		}()
+	}
+	wg.Wait()
+	files.Dec()
+
+	s := r.Snapshot()
+	if s.Counters["harvester.lines_read"] != 1050 || len(s.Counters) != 1 {
+		t.Errorf("unexpected counters %v", s.Counters)
+	}
+	if s.Gauges["harvester.open_files"] != 9 || len(s.Gauges) != 1 {
+		t.Errorf("unexpected gauges %v", s.Gauges)
+	}
+
+	files.Set(3)
+	if files.Get() != 3 {
+		t.Errorf("expected 3, got %d", files.Get())
+	}
+}
+
+func TestRegistry_DuplicateName(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("publisher.events_acked")
+
+	for _, register := range []func(){
+		func() { r.NewCounter("publisher.events_acked") },
+		func() { r.NewGauge("publisher.events_acked") },
+		func() { r.NewGauge("") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			register()
+		}()
+	}
+}
@@ -0,0 +1,108 @@
+package monitoring
+
+import (
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"sync"
+	"time"
+)
+
+// DefaultLogPeriod 是默认输出指标的间隔
+const DefaultLogPeriod = 30 * time.Second
+
+// LogReporter 每隔 period 输出一次指标的变化：计数器输出这段时间内增加的值，
+// gauge 在变化时输出当前的值，没有变化的指标不会输出。停止时输出所有非 0 的指标
+// LogReporter periodically logs the non-zero metric deltas of a registry
+type LogReporter struct {
+	registry *Registry
+	period   time.Duration
+	last     Snapshot
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewLogReporter 创建输出 registry 中的指标的 LogReporter，period 为 0 时使用默认的间隔
+func NewLogReporter(registry *Registry, period time.Duration) *LogReporter {
+	if period <= 0 {
+		period = DefaultLogPeriod
+	}
+	return &LogReporter{
+		registry: registry,
+		period:   period,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start 在新的 goroutine 中定期输出指标
+func (r *LogReporter) Start() {
+	r.last = r.registry.Snapshot()
+	r.wg.Add(1)
+	go r.run()
+}
+
+// Stop 停止定期输出，并输出所有非 0 的指标
+func (r *LogReporter) Stop() {
+	close(r.done)
+	r.wg.Wait()
+
+	total := logp.Fields{}
+	s := r.registry.Snapshot()
+	for name, value := range s.Counters {
+		if value != 0 {
+			total[name] = value
+		}
+	}
+	for name, value := range s.Gauges {
+		if value != 0 {
+			total[name] = value
+		}
+	}
+	if len(total) == 0 {
+		logp.Info("No non-zero metrics in total")
+		return
+	}
+	logp.WithFields(total).Info("Total non-zero metrics")
+}
+
+func (r *LogReporter) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.report()
+		}
+	}
+}
+
+func (r *LogReporter) report() {
+	current := r.registry.Snapshot()
+	deltas := snapshotDeltas(r.last, current)
+	r.last = current
+
+	if len(deltas) == 0 {
+		logp.Info("No non-zero metrics in the last %s", r.period)
+		return
+	}
+	logp.WithFields(deltas).Info("Non-zero metrics in the last %s", r.period)
+}
+
+// snapshotDeltas 返回计数器增加的值和发生了变化的 gauge 的当前值
+func snapshotDeltas(last, current Snapshot) logp.Fields {
+	deltas := logp.Fields{}
+	for name, value := range current.Counters {
+		if delta := value - last.Counters[name]; delta != 0 {
+			deltas[name] = delta
+		}
+	}
+	for name, value := range current.Gauges {
+		if previous, ok := last.Gauges[name]; !ok && value != 0 || ok && previous != value {
+			deltas[name] = value
+		}
+	}
+	return deltas
+}
@@ -0,0 +1,88 @@
+package monitoring
+
+import (
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotDeltas(t *testing.T) {
+	last := Snapshot{
+		Counters: map[string]int64{"lines_read": 10, "flushes": 2},
+		Gauges:   map[string]int64{"open_files": 3, "depth": 0},
+	}
+	current := Snapshot{
+		Counters: map[string]int64{"lines_read": 25, "flushes": 2, "events_acked": 4},
+		Gauges:   map[string]int64{"open_files": 3, "depth": 0, "states": 7},
+	}
+
+	deltas := snapshotDeltas(last, current)
+	expected := logp.Fields{"lines_read": int64(15), "events_acked": int64(4), "states": int64(7)}
+	if len(deltas) != len(expected) {
+		t.Fatalf("unexpected deltas %v", deltas)
+	}
+	for name, value := range expected {
+		if deltas[name] != value {
+			t.Errorf("%s: expected %v, got %v", name, value, deltas[name])
+		}
+	}
+
+	// gauge 减少到 0 时也会输出
+	current.Gauges["open_files"] = 0
+	if deltas := snapshotDeltas(last, current); deltas["open_files"] != int64(0) {
+		t.Errorf("expected open_files to be reported, got %v", deltas)
+	}
+}
+
+func TestLogReporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "monitoring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	toFiles, toSyslog := true, false
+	config := logp.Logging{
+		Level:    "info",
+		ToFiles:  &toFiles,
+		ToSyslog: &toSyslog,
+		Files:    &logp.FileRotator{Path: dir, Name: "testbeat"},
+	}
+	if err := logp.Init("testbeat", &config, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer logp.LogInit(logp.LOG_ERR, "", false, true, nil)
+
+	r := NewRegistry()
+	lines := r.NewCounter("harvester.lines_read")
+	lines.Add(5)
+
+	// Start 之前的值不会算在第一次输出中
+	reporter := NewLogReporter(r, 20*time.Millisecond)
+	reporter.Start()
+	lines.Add(3)
+	time.Sleep(100 * time.Millisecond)
+	reporter.Stop()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "testbeat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := string(data)
+	for _, message := range []string{
+		"Non-zero metrics in the last 20ms harvester.lines_read=3",
+		"No non-zero metrics in the last 20ms",
+		"Total non-zero metrics harvester.lines_read=8",
+	} {
+		if !strings.Contains(logs, message) {
+			t.Errorf("expected '%s' in logs:\n%s", message, logs)
+		}
+	}
+	if strings.Contains(logs, "lines_read=5") {
+		t.Errorf("unexpected initial value in logs:\n%s", logs)
+	}
+}
@@ -0,0 +1,178 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HTTP 监控接口的默认配置，默认只监听本机
+const (
+	DefaultHTTPHost = "localhost"
+	DefaultHTTPPort = 5066
+)
+
+// HTTPConfig 是 HTTP 监控接口的配置，默认不开启
+type HTTPConfig struct {
+	Enabled bool
+	Host    string
+	Port    int
+}
+
+// StateFunc 返回 beat 当前的状态，会在 HTTP 请求的 goroutine 中调用
+type StateFunc func() interface{}
+
+// Server 是本地的 HTTP 监控接口:
+//
+//	/stats    registry 中所有指标的值 (JSON)
+//	/state    beat 当前的状态 (JSON)，比如正在运行的 prospector 和 harvester
+//	/metrics  Prometheus 文本格式的指标
+//
+// Server serves the metrics and the state of the beat over HTTP
+type Server struct {
+	registry *Registry
+	state    StateFunc
+	server   *http.Server
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewServer 创建输出 registry 中的指标和 state 返回的状态的 Server，state 可以为 nil
+func NewServer(registry *Registry, state StateFunc) *Server {
+	s := &Server{
+		registry: registry,
+		state:    state,
+	}
+	s.server = &http.Server{Handler: s.handler()}
+	return s
+}
+
+// Start 监听 config 中的地址，并在新的 goroutine 中处理请求
+func (s *Server) Start(config HTTPConfig) error {
+	host := config.Host
+	if host == "" {
+		host = DefaultHTTPHost
+	}
+	port := config.Port
+	if port == 0 {
+		port = DefaultHTTPPort
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("failed to start monitoring endpoint: %v", err)
+	}
+	s.listener = listener
+	logp.Info("Starting monitoring endpoint on %s", listener.Addr())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logp.Err("Monitoring endpoint stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Addr 返回监听的地址，Start 之前返回 nil
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Stop 关闭监听的端口和所有的连接
+func (s *Server) Stop() {
+	if s.listener == nil {
+		return
+	}
+	s.server.Close()
+	s.wg.Wait()
+	logp.Info("Stopped monitoring endpoint")
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", s.handleStats)
+	mux.HandleFunc("/state", s.handleState)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return mux
+}
+
+// handleStats 以名称为 key 输出所有的指标
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.registry.Snapshot()
+	stats := make(map[string]int64, len(snapshot.Counters)+len(snapshot.Gauges))
+	for name, value := range snapshot.Counters {
+		stats[name] = value
+	}
+	for name, value := range snapshot.Gauges {
+		stats[name] = value
+	}
+	writeJSON(w, stats)
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	var state interface{}
+	if s.state != nil {
+		state = s.state()
+	}
+	if state == nil {
+		state = map[string]interface{}{}
+	}
+	writeJSON(w, state)
+}
+
+// handleMetrics 输出 Prometheus 的文本格式，名称中的 . 等字符替换为 _
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	snapshot := s.registry.Snapshot()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writePrometheus(w, snapshot.Counters, "counter")
+	writePrometheus(w, snapshot.Gauges, "gauge")
+}
+
+func writePrometheus(w http.ResponseWriter, values map[string]int64, metricType string) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		metric := prometheusName(name)
+		fmt.Fprintf(w, "# TYPE %s %s\n%s %d\n", metric, metricType, metric, values[name])
+	}
+}
+
+// prometheusName 将名称转换为 Prometheus 允许的 [a-zA-Z_:][a-zA-Z0-9_:]*
+func prometheusName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
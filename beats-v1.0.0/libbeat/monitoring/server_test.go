@@ -0,0 +1,130 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T, state StateFunc) *httptest.Server {
+	r := NewRegistry()
+	r.NewCounter("filebeat.harvester.lines_read").Add(42)
+	r.NewGauge("filebeat.harvester.open_files").Set(3)
+	return httptest.NewServer(NewServer(r, state).handler())
+}
+
+func get(t *testing.T, url string) (string, string) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: unexpected status %s", url, resp.Status)
+	}
+	return string(body), resp.Header.Get("Content-Type")
+}
+
+func TestServer_Stats(t *testing.T) {
+	ts := newTestServer(t, nil)
+	defer ts.Close()
+
+	body, contentType := get(t, ts.URL+"/stats")
+	if !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("unexpected content type %s", contentType)
+	}
+	var stats map[string]int64
+	if err := json.Unmarshal([]byte(body), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats["filebeat.harvester.lines_read"] != 42 || stats["filebeat.harvester.open_files"] != 3 {
+		t.Errorf("unexpected stats %v", stats)
+	}
+}
+
+func TestServer_State(t *testing.T) {
+	ts := newTestServer(t, func() interface{} {
+		return map[string]interface{}{"prospectors": []string{"/var/log/*.log"}}
+	})
+	defer ts.Close()
+
+	body, _ := get(t, ts.URL+"/state")
+	var state map[string][]string
+	if err := json.Unmarshal([]byte(body), &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state["prospectors"]) != 1 || state["prospectors"][0] != "/var/log/*.log" {
+		t.Errorf("unexpected state %v", state)
+	}
+
+	// 没有状态时返回空的对象
+	empty := newTestServer(t, nil)
+	defer empty.Close()
+	if body, _ := get(t, empty.URL+"/state"); strings.TrimSpace(body) != "{}" {
+		t.Errorf("unexpected state %s", body)
+	}
+}
+
+func TestServer_Prometheus(t *testing.T) {
+	ts := newTestServer(t, nil)
+	defer ts.Close()
+
+	body, contentType := get(t, ts.URL+"/metrics")
+	if !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("unexpected content type %s", contentType)
+	}
+	expected := "# TYPE filebeat_harvester_lines_read counter\n" +
+		"filebeat_harvester_lines_read 42\n" +
+		"# TYPE filebeat_harvester_open_files gauge\n" +
+		"filebeat_harvester_open_files 3\n"
+	if body != expected {
+		t.Errorf("unexpected metrics:\n%s", body)
+	}
+}
+
+func TestPrometheusName(t *testing.T) {
+	tests := map[string]string{
+		"libbeat.publisher.events_acked": "libbeat_publisher_events_acked",
+		"a-b/c:d":                        "a_b_c:d",
+		"1st.metric":                     "_1st_metric",
+	}
+	for name, expected := range tests {
+		if actual := prometheusName(name); actual != expected {
+			t.Errorf("%s: expected %s, got %s", name, expected, actual)
+		}
+	}
+}
+
+func TestServer_StartStop(t *testing.T) {
+	// 找一个空闲的端口
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	s := NewServer(NewRegistry(), nil)
+	if err := s.Start(HTTPConfig{Enabled: true, Host: "127.0.0.1", Port: port}); err != nil {
+		t.Fatal(err)
+	}
+	get(t, "http://"+s.Addr().String()+"/stats")
+
+	// 端口已经被占用
+	if err := NewServer(NewRegistry(), nil).Start(HTTPConfig{Host: "127.0.0.1", Port: port}); err == nil {
+		t.Error("expected error")
+	}
+
+	s.Stop()
+	if _, err := http.Get("http://" + s.Addr().String() + "/stats"); err == nil {
+		t.Error("expected server to be stopped")
+	}
+}
@@ -0,0 +1,16 @@
+package outputs
+
+import (
+	"github.com/ssp4599815/beat/libbeat/outputs/codec"
+)
+
+// LoadCodec 根据配置创建 output 使用的 codec。没有配置 codec 时使用 json，
+// 设置了 pretty 时输出缩进格式化的 json
+// LoadCodec creates the codec configured for an output
+func LoadCodec(config *MothershipConfig) (codec.Codec, error) {
+	codecConfig := config.Codec
+	if codecConfig.JSON == nil && codecConfig.Format == nil && config.Pretty != nil {
+		codecConfig.JSON = &codec.JSONConfig{Pretty: *config.Pretty}
+	}
+	return codec.New(codecConfig)
+}
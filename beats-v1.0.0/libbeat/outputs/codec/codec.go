@@ -0,0 +1,44 @@
+package codec
+
+import (
+	"errors"
+	"github.com/ssp4599815/beat/libbeat/common"
+)
+
+// Codec 将一个事件编码为 output 写出的内容，不包括事件之间的分隔符
+// Codec encodes a single event
+type Codec interface {
+	Encode(event common.MapStr) ([]byte, error)
+}
+
+// Config 选择 output 使用的 codec，json 和 format 最多只能配置一个，都没有配置时使用 json
+// Config selects the codec used by an output
+type Config struct {
+	JSON   *JSONConfig   `yaml:"json"`
+	Format *FormatConfig `yaml:"format"`
+}
+
+// JSONConfig 是 json codec 的配置
+type JSONConfig struct {
+	Pretty bool `yaml:"pretty"` // 缩进格式化输出
+}
+
+// FormatConfig 是 format codec 的配置
+type FormatConfig struct {
+	String string `yaml:"string"` // 格式字符串，比如 "%{[beat][name]}: %{[message]}"
+}
+
+// New 根据配置创建 codec
+// New creates the codec selected by config
+func New(config Config) (Codec, error) {
+	if config.JSON != nil && config.Format != nil {
+		return nil, errors.New("only one of codec.json and codec.format can be configured")
+	}
+	if config.Format != nil {
+		return NewFormat(config.Format.String)
+	}
+	if config.JSON != nil && config.JSON.Pretty {
+		return NewJSON(true), nil
+	}
+	return NewJSON(false), nil
+}
@@ -0,0 +1,77 @@
+package codec
+
+import (
+	"github.com/ssp4599815/beat/libbeat/common"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	event := common.MapStr{
+		"message": "hello",
+		"count":   3,
+		"beat":    common.MapStr{"name": "test", "host": map[string]interface{}{"ip": "127.0.0.1"}},
+		"tags":    []string{"a", "b"},
+	}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"%{[message]}", "hello"},
+		{"static", "static"},
+		{"%{[beat][name]}: %{[message]} (%{[count]})", "test: hello (3)"},
+		{"%{[beat][host][ip]}", "127.0.0.1"},
+		{"%{[tags]}", `["a","b"]`},
+		{"%{[beat][host]}", `{"ip":"127.0.0.1"}`},
+	}
+
+	for _, test := range tests {
+		codec, err := NewFormat(test.format)
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		actual, err := codec.Encode(event)
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if string(actual) != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.format, test.expected, actual)
+		}
+	}
+}
+
+func TestFormat_Errors(t *testing.T) {
+	for _, format := range []string{"", "%{[message]", "%{message}", "%{[a]b}", "%{[]}"} {
+		if _, err := NewFormat(format); err == nil {
+			t.Errorf("%q: expected error", format)
+		}
+	}
+
+	codec, err := NewFormat("%{[beat][name]}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []common.MapStr{{}, {"beat": "name"}} {
+		if _, err := codec.Encode(event); err == nil {
+			t.Errorf("%v: expected error for missing field", event)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	codec, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := codec.Encode(common.MapStr{"a": 1})
+	if string(data) != `{"a":1}` {
+		t.Errorf("unexpected default encoding %s", data)
+	}
+
+	_, err = New(Config{JSON: &JSONConfig{}, Format: &FormatConfig{String: "x"}})
+	if err == nil {
+		t.Error("expected error when both json and format are configured")
+	}
+}
@@ -0,0 +1,117 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"strings"
+)
+
+// formatCodec 使用格式字符串编码事件，%{[a][b]} 会被替换为事件中 a.b 字段的值。
+// 字符串原样输出，其他类型的值编码为 json。事件中没有引用的字段时返回错误
+type formatCodec struct {
+	parts []formatPart
+}
+
+// formatPart 是格式字符串的一部分，field 为空时是普通文本
+type formatPart struct {
+	text  string
+	field []string
+}
+
+// NewFormat 解析格式字符串，创建 format codec
+func NewFormat(format string) (Codec, error) {
+	if format == "" {
+		return nil, errors.New("format string must not be empty")
+	}
+
+	var parts []formatPart
+	rest := format
+	for {
+		start := strings.Index(rest, "%{")
+		if start < 0 {
+			if rest != "" {
+				parts = append(parts, formatPart{text: rest})
+			}
+			break
+		}
+		if start > 0 {
+			parts = append(parts, formatPart{text: rest[:start]})
+		}
+		rest = rest[start+2:]
+
+		end := strings.Index(rest, "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated field reference in format string '%s'", format)
+		}
+		field, err := parseField(rest[:end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid format string '%s': %v", format, err)
+		}
+		parts = append(parts, formatPart{field: field})
+		rest = rest[end+1:]
+	}
+
+	return &formatCodec{parts: parts}, nil
+}
+
+// parseField 解析 [a][b] 形式的字段引用
+func parseField(ref string) ([]string, error) {
+	if ref == "" || ref[0] != '[' || ref[len(ref)-1] != ']' {
+		return nil, fmt.Errorf("field reference '%s' must have the form [name]", ref)
+	}
+
+	var field []string
+	for _, name := range strings.Split(ref[1:len(ref)-1], "][") {
+		if name == "" || strings.ContainsAny(name, "[]") {
+			return nil, fmt.Errorf("invalid field reference '%s'", ref)
+		}
+		field = append(field, name)
+	}
+	return field, nil
+}
+
+func (c *formatCodec) Encode(event common.MapStr) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, part := range c.parts {
+		if part.field == nil {
+			buf.WriteString(part.text)
+			continue
+		}
+
+		value, ok := lookup(event, part.field)
+		if !ok {
+			return nil, fmt.Errorf("field [%s] not found in event", strings.Join(part.field, "]["))
+		}
+		if s, ok := value.(string); ok {
+			buf.WriteString(s)
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// lookup 按照字段路径查找嵌套的值
+func lookup(event common.MapStr, field []string) (interface{}, bool) {
+	var value interface{} = event
+	for _, name := range field {
+		var ok bool
+		switch m := value.(type) {
+		case common.MapStr:
+			value, ok = m[name]
+		case map[string]interface{}:
+			value, ok = m[name]
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
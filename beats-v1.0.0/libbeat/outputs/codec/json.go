@@ -0,0 +1,23 @@
+package codec
+
+import (
+	"encoding/json"
+	"github.com/ssp4599815/beat/libbeat/common"
+)
+
+// jsonCodec 将事件编码为 json，pretty 为 true 时使用两个空格缩进
+type jsonCodec struct {
+	pretty bool
+}
+
+// NewJSON 创建 json codec
+func NewJSON(pretty bool) Codec {
+	return &jsonCodec{pretty: pretty}
+}
+
+func (c *jsonCodec) Encode(event common.MapStr) ([]byte, error) {
+	if c.pretty {
+		return json.MarshalIndent(event, "", "  ")
+	}
+	return json.Marshal(event)
+}
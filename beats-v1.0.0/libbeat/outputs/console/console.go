@@ -0,0 +1,67 @@
+package console
+
+import (
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/codec"
+	"io"
+	"os"
+	"sync"
+)
+
+func init() {
+	outputs.RegisterOutputPlugin("console", New)
+}
+
+// console 将事件逐行打印到标准输出，主要用于调试。
+// 默认每行一个 json，设置了 pretty 时输出缩进格式化的 json，也可以通过 codec 使用格式字符串
+// console prints events to stdout
+type console struct {
+	mutex sync.Mutex
+	out   io.Writer
+	codec codec.Codec
+}
+
+// New 创建一个新的 console output，需要调用 Init 进行初始化
+func New() outputs.Outputer {
+	return &console{out: os.Stdout}
+}
+
+// Init 根据配置创建 codec
+func (c *console) Init(beatName string, config *outputs.MothershipConfig, topologyExpire int) error {
+	encoder, err := outputs.LoadCodec(config)
+	if err != nil {
+		return err
+	}
+	c.codec = encoder
+	return nil
+}
+
+// PublishEvents 打印所有事件，写入标准输出失败时整批事件都被当做失败
+func (c *console) PublishEvents(signal outputs.Signaler, events []common.MapStr) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, event := range events {
+		data, err := c.codec.Encode(event)
+		if err != nil {
+			logp.Err("console: failed to encode event, dropping it: %v", err)
+			continue
+		}
+
+		if _, err := c.out.Write(append(data, '\n')); err != nil {
+			logp.Err("console: failed to write events: %v", err)
+			outputs.SignalFailed(signal)
+			return err
+		}
+	}
+
+	outputs.SignalCompleted(signal)
+	return nil
+}
+
+// Close 标准输出不需要关闭
+func (c *console) Close() error {
+	return nil
+}
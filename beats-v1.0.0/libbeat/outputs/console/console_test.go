@@ -0,0 +1,56 @@
+package console
+
+import (
+	"bytes"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/codec"
+	"testing"
+)
+
+func publish(t *testing.T, config outputs.MothershipConfig, events []common.MapStr) string {
+	var buf bytes.Buffer
+	c := New().(*console)
+	c.out = &buf
+	if err := c.Init("testbeat", &config, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	signal := outputs.NewSyncSignal()
+	if err := c.PublishEvents(signal, events); err != nil {
+		t.Fatal(err)
+	}
+	if !signal.Wait() {
+		t.Fatal("publish failed")
+	}
+	return buf.String()
+}
+
+func TestPublishEvents(t *testing.T) {
+	pretty := true
+	events := []common.MapStr{{"message": "a"}, {"message": "b"}}
+
+	tests := []struct {
+		config   outputs.MothershipConfig
+		expected string
+	}{
+		{
+			outputs.MothershipConfig{},
+			"{\"message\":\"a\"}\n{\"message\":\"b\"}\n",
+		},
+		{
+			outputs.MothershipConfig{Pretty: &pretty},
+			"{\n  \"message\": \"a\"\n}\n{\n  \"message\": \"b\"\n}\n",
+		},
+		{
+			outputs.MothershipConfig{Codec: codec.Config{Format: &codec.FormatConfig{String: "msg=%{[message]}"}}},
+			"msg=a\nmsg=b\n",
+		},
+	}
+
+	for i, test := range tests {
+		if actual := publish(t, test.config, events); actual != test.expected {
+			t.Errorf("%d: expected %q, got %q", i, test.expected, actual)
+		}
+	}
+}
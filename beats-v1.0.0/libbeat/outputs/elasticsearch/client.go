@@ -0,0 +1,289 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// client 是到一个 elasticsearch 节点的连接
+// client sends bulk requests to a single elasticsearch host
+type client struct {
+	url       string
+	username  string
+	password  string
+	index     *indexFormat
+	http      *http.Client
+	connected bool
+
+	// 索引模板在第一次成功连接到节点时加载
+	template       *template
+	templateMutex  sync.Mutex
+	templateLoaded bool
+}
+
+// template 是启动时从文件中读取的索引模板
+type template struct {
+	name      string
+	body      []byte
+	overwrite bool
+}
+
+// bulkResponse 是 _bulk 请求的返回结果，每个 item 对应请求中的一个文档
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+type bulkItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// makeURL 根据配置生成节点的地址，host 中没有 scheme 和端口时使用 protocol 和默认端口 9200
+func makeURL(host, protocol, path string) (string, error) {
+	if protocol == "" {
+		protocol = "http"
+	}
+	if !strings.Contains(host, "://") {
+		host = protocol + "://" + host
+	}
+
+	scheme := host[:strings.Index(host, "://")]
+	if scheme != "http" && scheme != "https" {
+		return "", fmt.Errorf("unsupported protocol '%s' in host '%s'", scheme, host)
+	}
+
+	rest := strings.TrimRight(host[len(scheme)+3:], "/")
+	hostPort := rest
+	if idx := strings.Index(rest, "/"); idx >= 0 {
+		hostPort = rest[:idx]
+	}
+	if hostPort == "" {
+		return "", fmt.Errorf("missing host in '%s'", host)
+	}
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		rest = net.JoinHostPort(hostPort, strconv.Itoa(defaultPort)) + rest[len(hostPort):]
+	}
+
+	url := scheme + "://" + rest
+	if path != "" {
+		url += "/" + strings.Trim(path, "/")
+	}
+	return url, nil
+}
+
+func newClient(
+	url, username, password string,
+	index *indexFormat,
+	tlsConfig *tls.Config,
+	timeout time.Duration,
+	tmpl *template,
+) *client {
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	return &client{
+		url:      url,
+		username: username,
+		password: password,
+		index:    index,
+		http:     &http.Client{Transport: transport, Timeout: timeout},
+		template: tmpl,
+	}
+}
+
+// Connect 加载索引模板。http 是无状态的，节点不可用时在发送 bulk 请求时才会发现
+func (c *client) Connect() error {
+	if err := c.loadTemplate(); err != nil {
+		return fmt.Errorf("failed to load template into %s: %v", c.url, err)
+	}
+	c.connected = true
+	return nil
+}
+
+// Ping 请求节点的根路径，检查节点是否可用以及用户名和密码是否正确，不会加载索引模板
+func (c *client) Ping() error {
+	status, body, err := c.request("GET", "/", nil)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("%s returned status %d: %s", c.url, status, body)
+	}
+	return nil
+}
+
+// IsConnected 返回是否已经成功连接过节点
+func (c *client) IsConnected() bool {
+	return c.connected
+}
+
+// Close 标记连接不可用，下次发送之前会重新调用 Connect
+func (c *client) Close() error {
+	c.connected = false
+	return nil
+}
+
+// PublishEvents 将事件发送到 client 对应的节点，返回需要重试的事件
+func (c *client) PublishEvents(events []common.MapStr) ([]common.MapStr, error) {
+	return c.Bulk(c.index, events)
+}
+
+// request 发送一个请求，返回状态码和 body
+func (c *client) request(method, path string, body []byte) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respBody, nil
+}
+
+// loadTemplate 在第一次连接节点时加载索引模板，模板已经存在并且没有设置 overwrite 时不会覆盖
+func (c *client) loadTemplate() error {
+	if c.template == nil {
+		return nil
+	}
+
+	c.templateMutex.Lock()
+	defer c.templateMutex.Unlock()
+	if c.templateLoaded {
+		return nil
+	}
+
+	path := "/_template/" + c.template.name
+	if !c.template.overwrite {
+		status, _, err := c.request("HEAD", path, nil)
+		if err != nil {
+			return err
+		}
+		if status == http.StatusOK {
+			logp.Info("elasticsearch: template %s already exists on %s", c.template.name, c.url)
+			c.templateLoaded = true
+			return nil
+		}
+	}
+
+	status, body, err := c.request("PUT", path, c.template.body)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("loading template %s failed with status %d: %s", c.template.name, status, body)
+	}
+
+	logp.Info("elasticsearch: loaded template %s into %s", c.template.name, c.url)
+	c.templateLoaded = true
+	return nil
+}
+
+// Bulk 使用 _bulk 接口发送一批事件，返回需要重试的事件。
+// 连接失败或者整个请求失败时返回 error，这时所有的事件都需要重试。
+// 因为格式错误等原因被拒绝的事件重试也不会成功，这些事件会被丢弃
+// Bulk publishes events and returns the events that failed with a retryable error
+func (c *client) Bulk(index *indexFormat, events []common.MapStr) ([]common.MapStr, error) {
+	body, encoded := encodeBulk(index, events)
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	status, respBody, err := c.request("POST", "/_bulk", body)
+	if err != nil {
+		return events, err
+	}
+	if status >= 300 {
+		return events, fmt.Errorf("bulk request to %s failed with status %d: %s", c.url, status, respBody)
+	}
+
+	return bulkCollectFailed(encoded, respBody)
+}
+
+// encodeBulk 生成 _bulk 请求的 body，每个事件对应一行 action 和一行文档。
+// 无法编码为 json 的事件会被丢弃，返回的是编码成功的事件
+func encodeBulk(index *indexFormat, events []common.MapStr) ([]byte, []common.MapStr) {
+	var buf bytes.Buffer
+	encoded := events[:0:0]
+
+	for _, event := range events {
+		doc, err := json.Marshal(event)
+		if err != nil {
+			logp.Err("elasticsearch: failed to encode event, dropping it: %v", err)
+			continue
+		}
+
+		meta := map[string]string{"_index": index.Select(event)}
+		if docType, ok := event["type"].(string); ok && docType != "" {
+			meta["_type"] = docType
+		}
+		action, _ := json.Marshal(map[string]interface{}{"index": meta})
+
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(doc)
+		buf.WriteByte('\n')
+		encoded = append(encoded, event)
+	}
+	return buf.Bytes(), encoded
+}
+
+// bulkCollectFailed 解析 _bulk 的返回结果，返回状态码为 429 或者 5xx 的事件
+func bulkCollectFailed(events []common.MapStr, body []byte) ([]common.MapStr, error) {
+	var resp bulkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return events, fmt.Errorf("failed to parse bulk response: %v", err)
+	}
+	if !resp.Errors {
+		return nil, nil
+	}
+	if len(resp.Items) != len(events) {
+		return events, fmt.Errorf("bulk response has %d items, expected %d", len(resp.Items), len(events))
+	}
+
+	var failed []common.MapStr
+	for i, item := range resp.Items {
+		for _, result := range item {
+			switch {
+			case result.Status < 300:
+			case result.Status == http.StatusTooManyRequests || result.Status >= 500:
+				failed = append(failed, events[i])
+			default:
+				logp.Err("elasticsearch: dropping event rejected with status %d: %s", result.Status, result.Error)
+			}
+		}
+	}
+	return failed, nil
+}
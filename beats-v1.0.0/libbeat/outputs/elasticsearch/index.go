@@ -0,0 +1,121 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"strings"
+	"time"
+)
+
+// indexFormat 是解析后的索引名称，例如 "filebeat-%{+yyyy.MM.dd}"。
+// %{+...} 中的日期格式使用 joda 的写法，日期取自事件的 @timestamp (UTC)
+// indexFormat is a parsed index name that may contain %{+date} patterns
+type indexFormat struct {
+	parts []indexPart
+}
+
+type indexPart func(buf []byte, ts time.Time) []byte
+
+// 支持的日期格式，长的要放在前面
+var dateTokens = []struct {
+	token  string
+	format func(buf []byte, ts time.Time) []byte
+}{
+	{"yyyy", func(buf []byte, ts time.Time) []byte { return appendInt(buf, ts.Year(), 4) }},
+	{"yy", func(buf []byte, ts time.Time) []byte { return appendInt(buf, ts.Year()%100, 2) }},
+	{"MM", func(buf []byte, ts time.Time) []byte { return appendInt(buf, int(ts.Month()), 2) }},
+	{"dd", func(buf []byte, ts time.Time) []byte { return appendInt(buf, ts.Day(), 2) }},
+	{"HH", func(buf []byte, ts time.Time) []byte { return appendInt(buf, ts.Hour(), 2) }},
+	{"mm", func(buf []byte, ts time.Time) []byte { return appendInt(buf, ts.Minute(), 2) }},
+	{"ss", func(buf []byte, ts time.Time) []byte { return appendInt(buf, ts.Second(), 2) }},
+}
+
+func appendInt(buf []byte, v, width int) []byte {
+	return append(buf, fmt.Sprintf("%0*d", width, v)...)
+}
+
+func literal(s string) indexPart {
+	return func(buf []byte, ts time.Time) []byte { return append(buf, s...) }
+}
+
+// parseIndexFormat 解析索引名称中的 %{+...} 日期格式
+func parseIndexFormat(index string) (*indexFormat, error) {
+	f := &indexFormat{}
+	for len(index) > 0 {
+		start := strings.Index(index, "%{")
+		if start < 0 {
+			f.parts = append(f.parts, literal(index))
+			break
+		}
+		if start > 0 {
+			f.parts = append(f.parts, literal(index[:start]))
+		}
+
+		end := strings.Index(index[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated '%%{' in index '%s'", index)
+		}
+		pattern := index[start+2 : start+end]
+		if !strings.HasPrefix(pattern, "+") {
+			return nil, fmt.Errorf("unsupported pattern '%%{%s}' in index, only dates like %%{+yyyy.MM.dd} are supported", pattern)
+		}
+
+		parts, err := parseDate(pattern[1:])
+		if err != nil {
+			return nil, err
+		}
+		f.parts = append(f.parts, parts...)
+		index = index[start+end+1:]
+	}
+	return f, nil
+}
+
+// parseDate 解析 joda 格式的日期，字母必须是支持的格式，其他字符原样输出
+func parseDate(pattern string) ([]indexPart, error) {
+	var parts []indexPart
+	for len(pattern) > 0 {
+		c := pattern[0]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			parts = append(parts, literal(pattern[:1]))
+			pattern = pattern[1:]
+			continue
+		}
+
+		found := false
+		for _, t := range dateTokens {
+			if strings.HasPrefix(pattern, t.token) {
+				parts = append(parts, t.format)
+				pattern = pattern[len(t.token):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unsupported date format '%s' in index", pattern)
+		}
+	}
+	return parts, nil
+}
+
+// Select 返回事件应该写入的索引
+func (f *indexFormat) Select(event common.MapStr) string {
+	ts := eventTime(event).UTC()
+	var buf []byte
+	for _, part := range f.parts {
+		buf = part(buf, ts)
+	}
+	return string(buf)
+}
+
+// eventTime 返回事件的 @timestamp，没有时使用当前时间
+func eventTime(event common.MapStr) time.Time {
+	switch ts := event["@timestamp"].(type) {
+	case time.Time:
+		return ts
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
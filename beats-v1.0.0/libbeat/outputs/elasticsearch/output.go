@@ -0,0 +1,158 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/mode"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	defaultPort        = 9200
+	defaultBulkMaxSize = 50
+	defaultMaxRetries  = 3
+	defaultTimeout     = 90 * time.Second
+	maxBackoff         = 60 * time.Second
+)
+
+// defaultBackoff 是 bulk 请求失败或者有文档需要重试时 worker 第一次等待的时间，测试中会改小
+var defaultBackoff = 1 * time.Second
+
+func init() {
+	outputs.RegisterOutputPlugin("elasticsearch", New)
+}
+
+// elasticsearchOutput 使用 _bulk 接口将事件发送到 elasticsearch。
+// 事件按照 bulk_max_size 分批，默认 (loadbalance 没有设置为 false 时) 分散的发送到所有节点上，
+// 一个节点失败时由其他节点发送
+// elasticsearchOutput publishes events to elasticsearch using the bulk API
+type elasticsearchOutput struct {
+	mode *mode.ConnectionMode
+}
+
+// New 创建一个新的 elasticsearch output，需要调用 Init 进行初始化
+func New() outputs.Outputer {
+	return &elasticsearchOutput{}
+}
+
+// Init 检查配置并启动 worker，这里不会连接 elasticsearch，索引模板在第一次连接节点时加载
+func (out *elasticsearchOutput) Init(beatName string, config *outputs.MothershipConfig, topologyExpire int) error {
+	hosts := config.Hosts
+	if len(hosts) == 0 && config.Host != "" {
+		hosts = []string{config.Host}
+	}
+	if len(hosts) == 0 {
+		return errors.New("no hosts configured")
+	}
+
+	index := config.Index
+	if index == "" {
+		index = beatName + "-%{+yyyy.MM.dd}"
+	}
+	indexFormat, err := parseIndexFormat(index)
+	if err != nil {
+		return err
+	}
+
+	var tmpl *template
+	if config.Template.Path != "" {
+		tmpl, err = readTemplate(beatName, &config.Template)
+		if err != nil {
+			return err
+		}
+	}
+
+	timeout := defaultTimeout
+	if config.Timeout > 0 {
+		timeout = time.Duration(config.Timeout) * time.Second
+	}
+
+	tlsConfig, err := outputs.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return err
+	}
+
+	// 配置了 tls 并且没有指定 protocol 时默认使用 https
+	protocol := config.Protocol
+	if protocol == "" && tlsConfig != nil {
+		protocol = "https"
+	}
+	urls := make([]string, len(hosts))
+	for i, host := range hosts {
+		if urls[i], err = makeURL(host, protocol, config.Path); err != nil {
+			return err
+		}
+	}
+
+	settings := mode.Settings{
+		Name:        "elasticsearch",
+		BulkMaxSize: defaultBulkMaxSize,
+		MaxRetries:  defaultMaxRetries,
+		Backoff:     defaultBackoff,
+		MaxBackoff:  maxBackoff,
+	}
+	if config.BulkMaxSize != nil && *config.BulkMaxSize > 0 {
+		settings.BulkMaxSize = *config.BulkMaxSize
+	}
+	if config.MaxRetries != nil {
+		settings.MaxRetries = *config.MaxRetries
+	}
+	if config.ReconnectInterval > 0 {
+		settings.Backoff = time.Duration(config.ReconnectInterval) * time.Second
+	}
+
+	factory := func(url string) (mode.ProtocolClient, error) {
+		return newClient(url, config.Username, config.Password, indexFormat, tlsConfig, timeout, tmpl), nil
+	}
+	loadBalance := config.LoadBalance == nil || *config.LoadBalance
+	out.mode, err = mode.NewConnectionMode(urls, loadBalance, config.Worker, factory, settings)
+	if err != nil {
+		return err
+	}
+
+	logp.Info("elasticsearch: output to %v with index %s, bulk_max_size %d, loadbalance %v",
+		urls, index, settings.BulkMaxSize, loadBalance)
+	return nil
+}
+
+// readTemplate 读取并检查索引模板文件，模板名称默认为 beat 的名称
+func readTemplate(beatName string, config *outputs.Template) (*template, error) {
+	body, err := ioutil.ReadFile(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %v", err)
+	}
+	var tmp map[string]interface{}
+	if err := json.Unmarshal(body, &tmp); err != nil {
+		return nil, fmt.Errorf("invalid template %s: %v", config.Path, err)
+	}
+
+	name := config.Name
+	if name == "" {
+		name = beatName
+	}
+	return &template{name: name, body: body, overwrite: config.Overwrite}, nil
+}
+
+// PublishEvents 将事件按照 bulk_max_size 分批交给 worker，通过 _bulk 请求写入 elasticsearch，
+// 被拒绝的文档会被丢弃，只有需要重试的文档会被重新发送
+func (out *elasticsearchOutput) PublishEvents(signal outputs.Signaler, events []common.MapStr) error {
+	return out.mode.PublishEvents(signal, events)
+}
+
+// TestConnection 使用发送事件时同样的认证和 TLS 配置请求每个节点的根路径，不会加载索引模板
+func (out *elasticsearchOutput) TestConnection() []outputs.HostResult {
+	return out.mode.TestConnection()
+}
+
+// Close 停止所有的 worker，正在重试的事件会被当做发送失败
+func (out *elasticsearchOutput) Close() error {
+	if out.mode == nil {
+		return nil
+	}
+	return out.mode.Close()
+}
@@ -0,0 +1,377 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/internal/outputtest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer 是一个假的 elasticsearch，记录收到的请求，bulk 请求的返回结果由 bulkStatus 决定
+type testServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	requests []string // "METHOD path"
+	docs     [][]string
+	metas    [][]map[string]map[string]string
+
+	// bulkStatus 返回第 n 个 bulk 请求中第 i 个文档的状态码
+	bulkStatus func(n, i int) int
+	templates  map[string][]byte
+}
+
+func newTestServer(bulkStatus func(n, i int) int) *testServer {
+	s := &testServer{bulkStatus: bulkStatus, templates: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *testServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch {
+	case r.URL.Path == "/_bulk":
+		s.handleBulk(w, body)
+	case r.Method == "GET" && r.URL.Path == "/":
+		fmt.Fprint(w, `{"tagline":"You Know, for Search"}`)
+	case r.Method == "HEAD":
+		if _, ok := s.templates[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == "PUT":
+		s.templates[r.URL.Path] = body
+		fmt.Fprint(w, `{"acknowledged":true}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (s *testServer) handleBulk(w http.ResponseWriter, body []byte) {
+	n := len(s.docs)
+	var docs []string
+	var metas []map[string]map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var meta map[string]map[string]string
+		json.Unmarshal(scanner.Bytes(), &meta)
+		metas = append(metas, meta)
+		scanner.Scan()
+		docs = append(docs, scanner.Text())
+	}
+	s.docs = append(s.docs, docs)
+	s.metas = append(s.metas, metas)
+
+	resp := bulkResponse{}
+	for i := range docs {
+		status := s.bulkStatus(n, i)
+		if status >= 300 {
+			resp.Errors = true
+		}
+		resp.Items = append(resp.Items, map[string]bulkItemResult{"index": {Status: status}})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *testServer) bulkRequests() [][]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.docs
+}
+
+func init() {
+	defaultBackoff = time.Millisecond
+}
+
+// newTestOutput 返回初始化好的 elasticsearch output，测试中可以直接访问它的 mode
+func newTestOutput(t *testing.T, config outputs.MothershipConfig) *elasticsearchOutput {
+	out := New().(*elasticsearchOutput)
+	outputtest.Init(t, out, config)
+	return out
+}
+
+// testEvents 在 outputtest.Events 的基础上加上选择索引和 _type 需要的 @timestamp 和 type，
+// message 为 "line <序号>"，方便在 bulk 请求中找到某一个事件
+func testEvents(n int) []common.MapStr {
+	ts := time.Date(2015, 11, 20, 10, 0, 0, 0, time.UTC)
+	events := outputtest.Events(n)
+	for i, event := range events {
+		event["@timestamp"] = ts
+		event["type"] = "log"
+		event["message"] = fmt.Sprintf("line %d", i)
+	}
+	return events
+}
+
+func TestIndexFormat(t *testing.T) {
+	event := common.MapStr{"@timestamp": time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)}
+	tests := []struct {
+		format, index string
+	}{
+		{"filebeat", "filebeat"},
+		{"filebeat-%{+yyyy.MM.dd}", "filebeat-2015.01.02"},
+		{"logs-%{+yy-MM}-x", "logs-15-01-x"},
+		{"%{+yyyy.MM.dd.HH:mm:ss}", "2015.01.02.03:04:05"},
+	}
+	for _, test := range tests {
+		f, err := parseIndexFormat(test.format)
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if index := f.Select(event); index != test.index {
+			t.Errorf("%s: expected %s, got %s", test.format, test.index, index)
+		}
+	}
+
+	for _, format := range []string{"a-%{+yyyy", "a-%{[field]}", "a-%{+yyyy.QQ}"} {
+		if _, err := parseIndexFormat(format); err == nil {
+			t.Errorf("%s: expected error", format)
+		}
+	}
+}
+
+func TestMakeURL(t *testing.T) {
+	tests := []struct {
+		host, protocol, path, url string
+	}{
+		{"localhost", "", "", "http://localhost:9200"},
+		{"localhost:9201", "https", "", "https://localhost:9201"},
+		{"http://es:80/", "", "/es/", "http://es:80/es"},
+		{"https://es", "", "", "https://es:9200"},
+	}
+	for _, test := range tests {
+		url, err := makeURL(test.host, test.protocol, test.path)
+		if err != nil || url != test.url {
+			t.Errorf("%s: expected %s, got %s (%v)", test.host, test.url, url, err)
+		}
+	}
+
+	if _, err := makeURL("ftp://es", "", ""); err == nil {
+		t.Error("expected error for unsupported protocol")
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	server := newTestServer(func(n, i int) int { return 201 })
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:       []string{server.URL},
+		BulkMaxSize: outputtest.IntPtr(2),
+	})
+	defer out.Close()
+
+	signal := outputs.NewSyncSignal()
+	out.PublishEvents(signal, testEvents(3))
+	if !signal.Wait() {
+		t.Fatal("publish failed")
+	}
+
+	docs := server.bulkRequests()
+	if len(docs) != 2 || len(docs[0]) != 2 || len(docs[1]) != 1 {
+		t.Fatalf("expected batches of 2 and 1 events, got %v", docs)
+	}
+	meta := server.metas[0][0]["index"]
+	if meta["_index"] != "testbeat-2015.11.20" || meta["_type"] != "log" {
+		t.Errorf("unexpected bulk action: %v", meta)
+	}
+}
+
+func TestPublishEvents_RetryFailedItems(t *testing.T) {
+	// 第一次请求: 0 成功，1 被限流，2 格式错误，3 服务端错误
+	server := newTestServer(func(n, i int) int {
+		if n > 0 {
+			return 201
+		}
+		return []int{201, 429, 400, 503}[i]
+	})
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{Hosts: []string{server.URL}})
+	defer out.Close()
+
+	signal := outputs.NewSyncSignal()
+	out.PublishEvents(signal, testEvents(4))
+	if !signal.Wait() {
+		t.Fatal("publish failed")
+	}
+
+	docs := server.bulkRequests()
+	if len(docs) != 2 {
+		t.Fatalf("expected 2 bulk requests, got %d", len(docs))
+	}
+	if len(docs[1]) != 2 ||
+		!bytes.Contains([]byte(docs[1][0]), []byte("line 1")) ||
+		!bytes.Contains([]byte(docs[1][1]), []byte("line 3")) {
+		t.Errorf("only retryable events should be resent, got %v", docs[1])
+	}
+}
+
+func TestPublishEvents_MaxRetries(t *testing.T) {
+	server := newTestServer(func(n, i int) int { return 503 })
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:      []string{server.URL},
+		MaxRetries: outputtest.IntPtr(2),
+	})
+	defer out.Close()
+
+	signal := outputs.NewSyncSignal()
+	out.PublishEvents(signal, testEvents(1))
+	if signal.Wait() {
+		t.Fatal("expected publish to fail")
+	}
+	if n := len(server.bulkRequests()); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestPublishEvents_Failover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	server := newTestServer(func(n, i int) int { return 201 })
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:      []string{down.URL, server.URL},
+		MaxRetries: outputtest.IntPtr(0),
+	})
+	defer out.Close()
+
+	for i := 0; i < 2; i++ {
+		signal := outputs.NewSyncSignal()
+		out.PublishEvents(signal, testEvents(1))
+		if !signal.Wait() {
+			t.Fatal("publish failed, expected failover to the second host")
+		}
+	}
+	if n := len(server.bulkRequests()); n != 2 {
+		t.Errorf("expected 2 bulk requests, got %d", n)
+	}
+}
+
+func TestTemplateLoading(t *testing.T) {
+	dir, err := ioutil.TempDir("", "estemplate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "template.json")
+	ioutil.WriteFile(path, []byte(`{"template":"testbeat-*"}`), 0644)
+
+	server := newTestServer(func(n, i int) int { return 201 })
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:    []string{server.URL},
+		Template: outputs.Template{Path: path},
+	})
+	defer out.Close()
+
+	if len(server.requests) != 0 {
+		t.Fatalf("Init must not connect, got %v", server.requests)
+	}
+
+	for i := 0; i < 2; i++ {
+		signal := outputs.NewSyncSignal()
+		out.PublishEvents(signal, testEvents(1))
+		if !signal.Wait() {
+			t.Fatal("publish failed")
+		}
+	}
+
+	expected := []string{"HEAD /_template/testbeat", "PUT /_template/testbeat", "POST /_bulk", "POST /_bulk"}
+	if fmt.Sprint(server.requests) != fmt.Sprint(expected) {
+		t.Errorf("expected requests %v, got %v", expected, server.requests)
+	}
+	if string(server.templates["/_template/testbeat"]) != `{"template":"testbeat-*"}` {
+		t.Errorf("unexpected template: %s", server.templates["/_template/testbeat"])
+	}
+
+	// 模板文件不存在或者格式错误时 Init 失败
+	ioutil.WriteFile(path, []byte(`{`), 0644)
+	config := outputs.MothershipConfig{Hosts: []string{server.URL}, Template: outputs.Template{Path: path}}
+	if err := New().Init("testbeat", &config, 0); err == nil {
+		t.Error("expected error for invalid template")
+	}
+}
+
+func TestTestConnection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "estemplate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "template.json")
+	ioutil.WriteFile(path, []byte(`{"template":"testbeat-*"}`), 0644)
+
+	server := newTestServer(func(n, i int) int { return 201 })
+	defer server.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:    []string{server.URL, down.URL},
+		Template: outputs.Template{Path: path},
+	})
+	defer out.Close()
+
+	results := out.TestConnection()
+	if len(results) != 2 || results[0].Err != nil || results[1].Err == nil {
+		t.Fatalf("unexpected results %v", results)
+	}
+	// 测试连接时不会加载模板
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if fmt.Sprint(server.requests) != "[GET /]" {
+		t.Errorf("unexpected requests %v", server.requests)
+	}
+}
+
+func TestPublishEvents_TLS(t *testing.T) {
+	server := &testServer{bulkStatus: func(n, i int) int { return 201 }, templates: map[string][]byte{}}
+	server.Server = httptest.NewTLSServer(http.HandlerFunc(server.handle))
+	defer server.Close()
+
+	sum := sha256.Sum256(server.Certificate().Raw)
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	// 没有指定 protocol 时使用 https，通过指纹校验服务端的自签名证书
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts: []string{host},
+		TLS: &outputs.TLSConfig{
+			VerificationMode: "none",
+			Fingerprints:     []string{hex.EncodeToString(sum[:])},
+		},
+	})
+	defer out.Close()
+
+	signal := outputs.NewSyncSignal()
+	out.PublishEvents(signal, testEvents(1))
+	if !signal.Wait() {
+		t.Fatal("publish failed")
+	}
+	if n := len(server.bulkRequests()); n != 1 {
+		t.Errorf("expected 1 bulk request, got %d", n)
+	}
+}
@@ -0,0 +1,124 @@
+package fileout
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/codec"
+	"sync"
+)
+
+const (
+	defaultRotateEveryKb = 10 * 1024
+	defaultNumberOfFiles = 7
+	maxNumberOfFiles     = 1024
+)
+
+func init() {
+	outputs.RegisterOutputPlugin("file", New)
+}
+
+// fileOutput 将事件使用 codec 编码后逐行写入文件 (默认每行一个 json)，文件大小超过 rotate_every_kb 时进行轮转。
+// 每一批事件写完之后都会 fsync，所以事件被确认时已经写入磁盘了
+// fileOutput writes newline delimited JSON events to rotating files
+type fileOutput struct {
+	mutex   sync.Mutex
+	rotator *rotator
+	codec   codec.Codec
+}
+
+// New 创建一个新的 file output，需要调用 Init 进行初始化
+func New() outputs.Outputer {
+	return &fileOutput{}
+}
+
+// Init 检查配置并打开输出文件
+func (out *fileOutput) Init(beatName string, config *outputs.MothershipConfig, topologyExpire int) error {
+	if config.Path == "" {
+		return errors.New("path must be configured")
+	}
+
+	filename := config.Filename
+	if filename == "" {
+		filename = beatName
+	}
+
+	rotateEveryKb := defaultRotateEveryKb
+	if config.RotateEveryKb != 0 {
+		rotateEveryKb = config.RotateEveryKb
+	}
+	if rotateEveryKb < 0 {
+		return fmt.Errorf("rotate_every_kb must be positive, got %d", rotateEveryKb)
+	}
+
+	encoder, err := outputs.LoadCodec(config)
+	if err != nil {
+		return err
+	}
+	out.codec = encoder
+
+	numberOfFiles := defaultNumberOfFiles
+	if config.NumberOfFiles != 0 {
+		numberOfFiles = config.NumberOfFiles
+	}
+	if numberOfFiles < 2 || numberOfFiles > maxNumberOfFiles {
+		return fmt.Errorf("number_of_files must be between 2 and %d, got %d", maxNumberOfFiles, numberOfFiles)
+	}
+
+	out.rotator = &rotator{
+		path:             config.Path,
+		name:             filename,
+		rotateEveryBytes: uint64(rotateEveryKb) * 1024,
+		keepFiles:        numberOfFiles,
+		compress:         config.CompressRotated,
+	}
+	if err := out.rotator.open(); err != nil {
+		return fmt.Errorf("failed to open output file: %v", err)
+	}
+
+	logp.Info("file: output to %s, rotate every %d KB, keep %d files",
+		out.rotator.filename(0), rotateEveryKb, numberOfFiles)
+	return nil
+}
+
+// PublishEvents 写入一批事件并 fsync。写入失败时只报告还没有写入的事件，
+// 已经写入文件的行不会被重新写入；fsync 失败时整批事件都被当做失败
+func (out *fileOutput) PublishEvents(signal outputs.Signaler, events []common.MapStr) error {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+
+	for i, event := range events {
+		data, err := out.codec.Encode(event)
+		if err != nil {
+			logp.Err("file: failed to encode event, dropping it: %v", err)
+			continue
+		}
+
+		if err := out.rotator.Write(append(data, '\n')); err != nil {
+			logp.Err("file: failed to write %d event(s): %v", len(events)-i, err)
+			outputs.SignalFailedEvents(signal, events[i:])
+			return err
+		}
+	}
+
+	if err := out.rotator.Sync(); err != nil {
+		logp.Err("file: failed to sync events: %v", err)
+		outputs.SignalFailed(signal)
+		return err
+	}
+
+	outputs.SignalCompleted(signal)
+	return nil
+}
+
+// Close 关闭输出文件
+func (out *fileOutput) Close() error {
+	out.mutex.Lock()
+	defer out.mutex.Unlock()
+	if out.rotator == nil {
+		return nil
+	}
+	return out.rotator.Close()
+}
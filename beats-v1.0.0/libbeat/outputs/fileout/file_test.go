@@ -0,0 +1,221 @@
+package fileout
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/internal/outputtest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fileout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readLines(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var r = bufio.NewReader(f)
+	var lines []string
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bufio.NewReader(gz)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	return lines
+}
+
+func TestRotator(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	r := &rotator{path: dir, name: "test", rotateEveryBytes: 10, keepFiles: 3}
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n"} {
+		if err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// aaaa bbbb | cccc dddd | eeee，最老的文件被删除
+	if files := listFiles(t, dir); strings.Join(files, ",") != "test,test.1,test.2" {
+		t.Fatalf("unexpected files: %v", files)
+	}
+	expected := map[string]string{"test": "eeee", "test.1": "cccc,dddd", "test.2": "aaaa,bbbb"}
+	for name, content := range expected {
+		if lines := readLines(t, filepath.Join(dir, name)); strings.Join(lines, ",") != content {
+			t.Errorf("%s: expected %s, got %v", name, content, lines)
+		}
+	}
+}
+
+func TestRotator_Compress(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	r := &rotator{path: dir, name: "test", rotateEveryBytes: 5, keepFiles: 3, compress: true}
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n"} {
+		if err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if files := listFiles(t, dir); strings.Join(files, ",") != "test,test.1.gz,test.2.gz" {
+		t.Fatalf("unexpected files: %v", files)
+	}
+	expected := map[string]string{"test": "dddd", "test.1.gz": "cccc", "test.2.gz": "bbbb"}
+	for name, content := range expected {
+		if lines := readLines(t, filepath.Join(dir, name)); strings.Join(lines, ",") != content {
+			t.Errorf("%s: expected %s, got %v", name, content, lines)
+		}
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	out := New()
+	config := outputs.MothershipConfig{Path: dir}
+	if err := out.Init("testbeat", &config, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	events := []common.MapStr{{"message": "a", "n": 0}, {"message": "b", "n": 1}}
+	signal := outputs.NewSyncSignal()
+	if err := out.PublishEvents(signal, events); err != nil {
+		t.Fatal(err)
+	}
+	if !signal.Wait() {
+		t.Fatal("publish failed")
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, filepath.Join(dir, "testbeat"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %v", lines)
+	}
+	for i, line := range lines {
+		event := common.MapStr{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event["n"] != float64(i) {
+			t.Errorf("line %d out of order: %s", i, line)
+		}
+	}
+
+	// 重新打开时追加写入
+	out = New()
+	if err := out.Init("testbeat", &config, 0); err != nil {
+		t.Fatal(err)
+	}
+	signal = outputs.NewSyncSignal()
+	out.PublishEvents(signal, events[:1])
+	signal.Wait()
+	out.Close()
+	if lines := readLines(t, filepath.Join(dir, "testbeat")); len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %v", lines)
+	}
+}
+
+func TestPublishEvents_WriteFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	out := New()
+	outputtest.Init(t, out, outputs.MothershipConfig{Path: dir, RotateEveryKb: 1, NumberOfFiles: 2})
+	defer out.Close()
+
+	// testbeat.1 是一个非空的目录，第三个事件写入前的轮转会失败
+	rotated := filepath.Join(dir, "testbeat.1")
+	if err := os.MkdirAll(filepath.Join(rotated, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	events := outputtest.Events(3)
+	for _, event := range events {
+		event["message"] = strings.Repeat("x", 400)
+	}
+
+	// 只有没有写入的事件被报告为失败
+	signal := outputtest.NewEventsSignal()
+	if err := out.PublishEvents(signal, events); err == nil {
+		t.Fatal("expected write error")
+	}
+	if signal.Wait() {
+		t.Fatal("expected publish to fail")
+	}
+	if len(signal.Events) != 1 || signal.Events[0]["n"] != 2 {
+		t.Fatalf("expected only event 2 to be reported as failed, got %d events", len(signal.Events))
+	}
+
+	// 重新发送失败的事件之后每个事件只被写入一次
+	if err := os.RemoveAll(rotated); err != nil {
+		t.Fatal(err)
+	}
+	if !outputtest.Publish(t, out, signal.Events) {
+		t.Fatal("retry failed")
+	}
+	lines := append(readLines(t, rotated), readLines(t, filepath.Join(dir, "testbeat"))...)
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(lines))
+	}
+}
+
+func TestInit_Errors(t *testing.T) {
+	config := outputs.MothershipConfig{}
+	if err := New().Init("testbeat", &config, 0); err == nil {
+		t.Error("expected error without path")
+	}
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	config = outputs.MothershipConfig{Path: dir, NumberOfFiles: 1}
+	if err := New().Init("testbeat", &config, 0); err == nil {
+		t.Error("expected error for number_of_files 1")
+	}
+}
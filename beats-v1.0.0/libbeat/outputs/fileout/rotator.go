@@ -0,0 +1,169 @@
+package fileout
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// rotator 将数据写入 path/name，文件大小超过 rotateEveryBytes 时进行轮转:
+// name.N-1 -> 删除，name.i -> name.i+1，name -> name.1，然后重新创建 name。
+// 包括当前文件在内最多保留 keepFiles 个文件。compress 为 true 时轮转后的文件会被压缩为 name.i.gz
+type rotator struct {
+	path             string
+	name             string
+	rotateEveryBytes uint64
+	keepFiles        int
+	compress         bool
+
+	file *os.File
+	size uint64
+}
+
+func (r *rotator) filename(i int) string {
+	name := filepath.Join(r.path, r.name)
+	if i == 0 {
+		return name
+	}
+	return name + "." + strconv.Itoa(i)
+}
+
+// open 打开当前文件，文件已经存在时追加写入
+func (r *rotator) open() error {
+	if err := os.MkdirAll(r.path, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(r.filename(0), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = uint64(info.Size())
+	return nil
+}
+
+// Write 写入数据，写入之后文件会超过大小限制时先进行轮转。
+// 一次写入的数据不会被拆分到两个文件中，写入失败时已经写入的部分会被截断
+func (r *rotator) Write(data []byte) error {
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+
+	if r.size > 0 && r.size+uint64(len(data)) > r.rotateEveryBytes {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := r.file.Write(data)
+	if err != nil && n > 0 {
+		// 重新写入时不能在文件中留下不完整的一行
+		if terr := r.file.Truncate(int64(r.size)); terr == nil {
+			n = 0
+		}
+	}
+	r.size += uint64(n)
+	return err
+}
+
+// Sync 将写入的数据刷新到磁盘
+func (r *rotator) Sync() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+// Close 刷新并关闭当前文件
+func (r *rotator) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Sync()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file = nil
+	return err
+}
+
+// rotate 关闭当前文件，依次重命名已经轮转的文件，然后打开新的文件
+func (r *rotator) rotate() error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+
+	// 删除最老的文件，保证轮转之后文件的数量不超过 keepFiles
+	last := r.keepFiles - 1
+	for _, suffix := range []string{"", ".gz"} {
+		if err := os.Remove(r.filename(last) + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for i := last - 1; i >= 1; i-- {
+		for _, suffix := range []string{"", ".gz"} {
+			err := os.Rename(r.filename(i)+suffix, r.filename(i+1)+suffix)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	if last >= 1 {
+		if err := os.Rename(r.filename(0), r.filename(1)); err != nil {
+			return err
+		}
+		if r.compress {
+			if err := compressFile(r.filename(1)); err != nil {
+				return err
+			}
+		}
+	} else if err := os.Remove(r.filename(0)); err != nil {
+		return err
+	}
+
+	return r.open()
+}
+
+// compressFile 将文件压缩为 file.gz 并删除原文件
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := gzip.NewWriter(out)
+	_, err = io.Copy(w, in)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if serr := out.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
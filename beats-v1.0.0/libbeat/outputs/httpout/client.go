@@ -0,0 +1,174 @@
+package httpout
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// client 将一批事件编码后 POST 到 url，实现 mode.ProtocolClient
+// client posts batches of events to the configured url
+type client struct {
+	url         string
+	http        *http.Client
+	format      string
+	username    string
+	password    string
+	bearerToken string
+	headers     map[string]string
+	compress    bool // 使用 gzip 压缩请求体
+	gzipLevel   int
+
+	connected  bool
+	retryAfter time.Duration // 上一次请求失败时服务端返回的 Retry-After
+}
+
+// Connect 什么都不做，http 是无状态的，服务端不可用时在发送请求时才会发现
+func (c *client) Connect() error {
+	c.connected = true
+	return nil
+}
+
+// IsConnected 返回 Connect 之后是否还没有出现过请求错误
+func (c *client) IsConnected() bool {
+	return c.connected
+}
+
+// Close 标记连接不可用，空闲的连接由 http.Client 管理
+func (c *client) Close() error {
+	c.connected = false
+	return nil
+}
+
+// RetryAfter 返回上一次请求失败时服务端要求等待的时间
+func (c *client) RetryAfter() time.Duration {
+	return c.retryAfter
+}
+
+// PublishEvents 发送一次请求。5xx 和 429 返回所有的事件进行重试；
+// 其他的 4xx 表示服务端拒绝了这些事件，重试也不会成功，事件会被丢弃
+func (c *client) PublishEvents(events []common.MapStr) ([]common.MapStr, error) {
+	body, err := c.encode(events)
+	if err != nil {
+		logp.Err("http: failed to encode events, dropping %d event(s): %v", len(events), err)
+		return nil, nil
+	}
+
+	c.retryAfter = 0
+	retryAfter, err := c.send(body)
+	if err == nil {
+		return nil, nil
+	}
+	if retryAfter < 0 {
+		logp.Err("http: dropping %d event(s): %v", len(events), err)
+		return nil, nil
+	}
+	c.retryAfter = retryAfter
+	return events, err
+}
+
+// encode 将事件编码为请求体，配置了压缩时使用 gzip 压缩
+func (c *client) encode(events []common.MapStr) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if c.compress {
+		var err error
+		if gz, err = gzip.NewWriterLevel(&buf, c.gzipLevel); err != nil {
+			return nil, err
+		}
+		w = gz
+	}
+
+	var err error
+	if c.format == formatJSONArray {
+		err = json.NewEncoder(w).Encode(events)
+	} else {
+		enc := json.NewEncoder(w)
+		for _, event := range events {
+			if err = enc.Encode(event); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// send 发送一次请求。返回的 retryAfter 小于 0 表示请求被拒绝，重试也不会成功；
+// 大于 0 表示服务端要求等待的时间 (Retry-After)
+func (c *client) send(body []byte) (retryAfter time.Duration, err error) {
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+
+	if c.format == formatJSONArray {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	if c.compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	}
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return parseRetryAfter(resp.Header.Get("Retry-After")),
+			fmt.Errorf("%s returned %s: %s", c.url, resp.Status, bytes.TrimSpace(message))
+	}
+	return -1, fmt.Errorf("%s returned %s: %s", c.url, resp.Status, bytes.TrimSpace(message))
+}
+
+// parseRetryAfter 解析 Retry-After 头，它可以是秒数也可以是 HTTP 日期。无法解析时返回 0
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+package httpout
+
+import (
+	"compress/gzip"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/mode"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultBulkMaxSize = 50
+	defaultMaxRetries  = 3
+	defaultTimeout     = 90 * time.Second
+	maxBackoff         = 60 * time.Second
+)
+
+// defaultBackoff 是请求失败并且服务端没有返回 Retry-After 时第一次等待的时间，测试中会改小
+var defaultBackoff = 1 * time.Second
+
+const (
+	formatJSONArray = "json_array"
+	formatNDJSON    = "ndjson"
+)
+
+func init() {
+	outputs.RegisterOutputPlugin("http", New)
+}
+
+// httpOutput 将事件按照 bulk_max_size 分批，以 json 数组或者每行一个 json (ndjson) 的格式
+// POST 到配置的 url。分批、worker 和重试由 mode.ConnectionMode 完成：
+// 服务端返回 5xx 或者 429 时等待一段时间后重试，有 Retry-After 头时按照它等待；
+// 返回其他的 4xx 时重试也不会成功，这一批事件会被丢弃
+// httpOutput posts batches of events to an HTTP endpoint
+type httpOutput struct {
+	mode *mode.ConnectionMode
+}
+
+// New 创建一个新的 http output，需要调用 Init 进行初始化
+func New() outputs.Outputer {
+	return &httpOutput{}
+}
+
+// Init 检查配置并启动 worker
+func (out *httpOutput) Init(beatName string, config *outputs.MothershipConfig, topologyExpire int) error {
+	if config.URL == "" {
+		return errors.New("url must be configured")
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url '%s': %v", config.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported protocol '%s' in url '%s'", u.Scheme, config.URL)
+	}
+
+	c := &client{
+		url:         config.URL,
+		username:    config.Username,
+		password:    config.Password,
+		bearerToken: config.BearerToken,
+		headers:     config.Headers,
+	}
+
+	switch config.BatchFormat {
+	case "", formatJSONArray:
+		c.format = formatJSONArray
+	case formatNDJSON:
+		c.format = formatNDJSON
+	default:
+		return fmt.Errorf("unknown batch_format '%s', must be one of %s, %s",
+			config.BatchFormat, formatJSONArray, formatNDJSON)
+	}
+
+	if config.BearerToken != "" && (config.Username != "" || config.Password != "") {
+		return errors.New("only one of username/password and bearer_token can be configured")
+	}
+
+	switch config.Compression {
+	case "", "none":
+	case "gzip":
+		c.compress = true
+		c.gzipLevel = gzip.DefaultCompression
+		if config.CompressionLevel != nil {
+			c.gzipLevel = *config.CompressionLevel
+		}
+		if c.gzipLevel < gzip.HuffmanOnly || c.gzipLevel > gzip.BestCompression {
+			return fmt.Errorf("invalid compression_level %d", c.gzipLevel)
+		}
+	default:
+		return fmt.Errorf("unknown compression '%s', must be one of none, gzip", config.Compression)
+	}
+
+	tlsConfig, err := outputs.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return err
+	}
+
+	timeout := defaultTimeout
+	if config.Timeout > 0 {
+		timeout = time.Duration(config.Timeout) * time.Second
+	}
+	c.http = newHTTPClient(tlsConfig, timeout)
+
+	settings := mode.Settings{
+		Name:        "http",
+		BulkMaxSize: defaultBulkMaxSize,
+		MaxRetries:  defaultMaxRetries,
+		Backoff:     defaultBackoff,
+		MaxBackoff:  maxBackoff,
+	}
+	if config.BulkMaxSize != nil && *config.BulkMaxSize > 0 {
+		settings.BulkMaxSize = *config.BulkMaxSize
+	}
+	if config.MaxRetries != nil {
+		settings.MaxRetries = *config.MaxRetries
+	}
+	if config.ReconnectInterval > 0 {
+		settings.Backoff = time.Duration(config.ReconnectInterval) * time.Second
+	}
+
+	// 每个 worker 有自己的 client 记录 Retry-After，http.Client 是并发安全的，所有 worker 共用
+	factory := func(host string) (mode.ProtocolClient, error) {
+		workerClient := *c
+		return &workerClient, nil
+	}
+	out.mode, err = mode.NewConnectionMode([]string{c.url}, false, config.Worker, factory, settings)
+	if err != nil {
+		return err
+	}
+
+	logp.Info("http: output to %s, batch_format %s", c.url, c.format)
+	return nil
+}
+
+func newHTTPClient(tlsConfig *tls.Config, timeout time.Duration) *http.Client {
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+// PublishEvents 将事件按照 bulk_max_size 分批交给 worker 发送，所有批次发送完成后通知 signal
+func (out *httpOutput) PublishEvents(signal outputs.Signaler, events []common.MapStr) error {
+	return out.mode.PublishEvents(signal, events)
+}
+
+// Close 停止所有的 worker，还在等待重试的事件会被当做发送失败
+func (out *httpOutput) Close() error {
+	if out.mode == nil {
+		return nil
+	}
+	return out.mode.Close()
+}
@@ -0,0 +1,273 @@
+package httpout
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/internal/outputtest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer 记录收到的请求，前 fail 个请求返回 status
+type fakeServer struct {
+	*httptest.Server
+
+	mutex      sync.Mutex
+	requests   []*http.Request
+	events     []common.MapStr
+	fail       int
+	status     int
+	retryAfter string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.requests = append(s.requests, r)
+
+		if s.fail > 0 {
+			s.fail--
+			if s.retryAfter != "" {
+				w.Header().Set("Retry-After", s.retryAfter)
+			}
+			http.Error(w, "failed", s.status)
+			return
+		}
+
+		events, err := decodeBody(r)
+		if err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.events = append(s.events, events...)
+	}))
+	return s
+}
+
+func (s *fakeServer) Events() []common.MapStr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.events
+}
+
+func (s *fakeServer) Requests() []*http.Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests
+}
+
+// decodeBody 根据 Content-Type 和 Content-Encoding 解析请求体
+func decodeBody(r *http.Request) ([]common.MapStr, error) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = gz
+	}
+
+	var events []common.MapStr
+	if r.Header.Get("Content-Type") == "application/json" {
+		err := json.NewDecoder(body).Decode(&events)
+		return events, err
+	}
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		event := common.MapStr{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+func init() {
+	defaultBackoff = time.Millisecond
+}
+
+// newTestOutput 返回初始化好的 http output，请求发送到 config.URL
+func newTestOutput(t *testing.T, config outputs.MothershipConfig) *httpOutput {
+	out := New().(*httpOutput)
+	outputtest.Init(t, out, config)
+	return out
+}
+
+func TestPublishEvents_JSONArray(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		URL:         server.URL + "/events",
+		BulkMaxSize: outputtest.IntPtr(4),
+		Username:    "user",
+		Password:    "secret",
+		Headers:     map[string]string{"X-Source": "test"},
+	})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(10)) {
+		t.Fatal("publish failed")
+	}
+	outputtest.CheckEvents(t, server.Events(), 10)
+
+	requests := server.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(requests))
+	}
+	r := requests[0]
+	if r.Method != "POST" || r.URL.Path != "/events" {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	}
+	if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+		t.Errorf("unexpected basic auth %s:%s", user, password)
+	}
+	if r.Header.Get("X-Source") != "test" {
+		t.Errorf("missing custom header: %v", r.Header)
+	}
+}
+
+func TestPublishEvents_NDJSONGzipBearer(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		URL:         server.URL,
+		BatchFormat: "ndjson",
+		Compression: "gzip",
+		BearerToken: "token",
+	})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(5)) {
+		t.Fatal("publish failed")
+	}
+	outputtest.CheckEvents(t, server.Events(), 5)
+
+	r := server.Requests()[0]
+	if r.Header.Get("Content-Type") != "application/x-ndjson" || r.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("unexpected headers: %v", r.Header)
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		t.Errorf("unexpected authorization %s", r.Header.Get("Authorization"))
+	}
+}
+
+func TestPublishEvents_Retry(t *testing.T) {
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		server := newFakeServer(t)
+		server.fail = 2
+		server.status = status
+
+		out := newTestOutput(t, outputs.MothershipConfig{URL: server.URL})
+		if !outputtest.Publish(t, out, outputtest.Events(3)) {
+			t.Fatalf("%d: publish failed", status)
+		}
+		outputtest.CheckEvents(t, server.Events(), 3)
+		if n := len(server.Requests()); n != 3 {
+			t.Errorf("%d: expected 3 requests, got %d", status, n)
+		}
+
+		out.Close()
+		server.Close()
+	}
+}
+
+func TestPublishEvents_RetryAfter(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.Close()
+	server.fail = 1
+	server.status = http.StatusTooManyRequests
+	server.retryAfter = "1"
+
+	out := newTestOutput(t, outputs.MothershipConfig{URL: server.URL})
+	defer out.Close()
+
+	start := time.Now()
+	if !outputtest.Publish(t, out, outputtest.Events(1)) {
+		t.Fatal("publish failed")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected to wait for Retry-After, retried after %v", elapsed)
+	}
+}
+
+func TestPublishEvents_Rejected(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.Close()
+	server.fail = 1
+	server.status = http.StatusBadRequest
+
+	out := newTestOutput(t, outputs.MothershipConfig{URL: server.URL})
+	defer out.Close()
+
+	// 4xx 不会重试，被拒绝的事件被丢弃，后面的事件继续发送
+	if !outputtest.Publish(t, out, outputtest.Events(1)) {
+		t.Fatal("publish failed")
+	}
+	if n := len(server.Requests()); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+	if !outputtest.Publish(t, out, outputtest.Events(2)) {
+		t.Fatal("publish failed")
+	}
+	outputtest.CheckEvents(t, server.Events(), 2)
+}
+
+func TestPublishEvents_MaxRetries(t *testing.T) {
+	server := newFakeServer(t)
+	defer server.Close()
+	server.fail = 10
+	server.status = http.StatusInternalServerError
+
+	out := newTestOutput(t, outputs.MothershipConfig{URL: server.URL, MaxRetries: outputtest.IntPtr(2)})
+	defer out.Close()
+
+	if outputtest.Publish(t, out, outputtest.Events(1)) {
+		t.Fatal("expected publish to fail")
+	}
+	if n := len(server.Requests()); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("3"); d != 3*time.Second {
+		t.Errorf("expected 3s, got %v", d)
+	}
+	if d := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); d <= 59*time.Minute {
+		t.Errorf("expected about 1h, got %v", d)
+	}
+	for _, value := range []string{"", "-1", "soon", "Mon, 02 Jan 2006 15:04:05 GMT"} {
+		if d := parseRetryAfter(value); d != 0 {
+			t.Errorf("%q: expected 0, got %v", value, d)
+		}
+	}
+}
+
+func TestInit_Errors(t *testing.T) {
+	configs := []outputs.MothershipConfig{
+		{},
+		{URL: "ftp://localhost"},
+		{URL: "http://localhost", BatchFormat: "xml"},
+		{URL: "http://localhost", Compression: "lz4"},
+		{URL: "http://localhost", Username: "user", BearerToken: "token"},
+	}
+	for i, config := range configs {
+		if err := New().Init("testbeat", &config, 0); err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Package outputtest 提供 output 插件的测试共用的辅助函数
+// Package outputtest contains helpers shared by the tests of the output plugins
+package outputtest
+
+import (
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"testing"
+)
+
+// Publisher 是 output 和 mode.ConnectionMode 都实现的发送事件的方法
+type Publisher interface {
+	PublishEvents(signal outputs.Signaler, events []common.MapStr) error
+}
+
+// Init 以 testbeat 为 beat 的名称初始化 out，初始化失败时测试直接失败
+func Init(t *testing.T, out outputs.Outputer, config outputs.MothershipConfig) {
+	if err := out.Init("testbeat", &config, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Publish 发送 events 并等待 signal，返回所有事件是否都发送成功
+func Publish(t *testing.T, out Publisher, events []common.MapStr) bool {
+	signal := outputs.NewSyncSignal()
+	if err := out.PublishEvents(signal, events); err != nil {
+		t.Fatal(err)
+	}
+	return signal.Wait()
+}
+
+// EventsSignal 记录 output 通过 FailedEvents 报告的失败事件，使用 Wait 等待结果
+type EventsSignal struct {
+	*outputs.SyncSignal
+	Events []common.MapStr
+}
+
+// NewEventsSignal 创建一个新的 EventsSignal
+func NewEventsSignal() *EventsSignal {
+	return &EventsSignal{SyncSignal: outputs.NewSyncSignal()}
+}
+
+func (s *EventsSignal) FailedEvents(events []common.MapStr) {
+	s.Events = events
+	s.Failed()
+}
+
+// Events 返回 n 个事件，事件的 n 字段是它的序号，用来检查顺序
+func Events(n int) []common.MapStr {
+	events := make([]common.MapStr, n)
+	for i := range events {
+		events[i] = common.MapStr{"message": "test", "n": i}
+	}
+	return events
+}
+
+// CheckEvents 检查服务端收到并解析了 json 的事件是不是 Events(n) 生成的事件，并且顺序不变
+func CheckEvents(t *testing.T, events []common.MapStr, n int) {
+	if len(events) != n {
+		t.Fatalf("expected %d events, got %d", n, len(events))
+	}
+	for i, event := range events {
+		if event["n"] != float64(i) {
+			t.Fatalf("event %d out of order: %v", i, event)
+		}
+	}
+}
+
+// IntPtr 返回 i 的指针，用于设置 bulk_max_size 等指针类型的配置
+func IntPtr(i int) *int { return &i }
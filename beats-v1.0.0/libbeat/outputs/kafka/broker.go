@@ -0,0 +1,130 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const clientID = "beats"
+
+// broker 是到一个 kafka broker 的连接，请求是串行发送的
+// broker is the connection to a single kafka broker
+type broker struct {
+	id      int32
+	addr    string
+	tls     *tls.Config
+	timeout time.Duration
+
+	mutex         sync.Mutex
+	conn          net.Conn
+	correlationID int32
+}
+
+func newBroker(id int32, addr string, tlsConfig *tls.Config, timeout time.Duration) *broker {
+	return &broker{id: id, addr: addr, tls: tlsConfig, timeout: timeout}
+}
+
+func (b *broker) connect() error {
+	if b.conn != nil {
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: b.timeout}
+	var conn net.Conn
+	var err error
+	if b.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", b.addr, b.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", b.addr)
+	}
+	if err != nil {
+		return err
+	}
+	b.conn = conn
+	return nil
+}
+
+// Close 关闭连接，下一次请求时会重新连接
+func (b *broker) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.closeConn()
+}
+
+func (b *broker) closeConn() error {
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn = nil
+	return err
+}
+
+// request 发送一个请求并读取返回结果，返回结果中不包括 correlation id。
+// 请求头: size int32, api_key int16, api_version int16, correlation_id int32, client_id string
+// 出错时连接会被关闭
+func (b *broker) request(apiKey int16, body []byte, expectResponse bool) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	resp, err := b.roundTrip(apiKey, body, expectResponse)
+	if err != nil {
+		b.closeConn()
+		return nil, fmt.Errorf("broker %s: %v", b.addr, err)
+	}
+	return resp, nil
+}
+
+func (b *broker) roundTrip(apiKey int16, body []byte, expectResponse bool) ([]byte, error) {
+	if err := b.connect(); err != nil {
+		return nil, err
+	}
+
+	b.correlationID++
+	var e encoder
+	e.putInt32(int32(2 + 2 + 4 + 2 + len(clientID) + len(body)))
+	e.putInt16(apiKey)
+	e.putInt16(0) // api version
+	e.putInt32(b.correlationID)
+	e.putString(clientID)
+	e.Write(body)
+
+	if err := b.conn.SetDeadline(time.Now().Add(b.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := b.conn.Write(e.Bytes()); err != nil {
+		return nil, err
+	}
+	if !expectResponse {
+		return nil, nil
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(b.conn, header[:]); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header[:4]))
+	if size < 4 {
+		return nil, errShortResponse
+	}
+	if id := int32(binary.BigEndian.Uint32(header[4:])); id != b.correlationID {
+		return nil, errors.New("correlation id mismatch")
+	}
+
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(b.conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func brokerAddress(meta brokerMeta) string {
+	return net.JoinHostPort(meta.host, strconv.Itoa(int(meta.port)))
+}
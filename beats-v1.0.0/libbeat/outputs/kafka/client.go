@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"sync"
+	"time"
+)
+
+var errNoBrokers = errors.New("kafka: no broker available")
+
+// client 维护集群的 metadata (每个 topic 的分区以及分区的 leader) 和到 broker 的连接
+// client caches the cluster metadata and the broker connections
+type client struct {
+	tls     *tls.Config
+	timeout time.Duration
+
+	mutex     sync.Mutex
+	bootstrap []*broker
+	brokers   map[int32]*broker
+	topics    map[string]topicMeta
+}
+
+func newClient(hosts []string, tlsConfig *tls.Config, timeout time.Duration) *client {
+	c := &client{
+		tls:     tlsConfig,
+		timeout: timeout,
+		brokers: map[int32]*broker{},
+		topics:  map[string]topicMeta{},
+	}
+	for _, host := range hosts {
+		c.bootstrap = append(c.bootstrap, newBroker(-1, host, tlsConfig, timeout))
+	}
+	return c
+}
+
+// Partitions 返回 topic 的所有分区，topic 的 metadata 还没有加载时会先加载
+func (c *client) Partitions(topic string) ([]partitionMeta, error) {
+	c.mutex.Lock()
+	meta, ok := c.topics[topic]
+	c.mutex.Unlock()
+	if ok && meta.err == errNone {
+		return meta.partitions, nil
+	}
+
+	if err := c.RefreshMetadata(topic); err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	meta = c.topics[topic]
+	c.mutex.Unlock()
+	if meta.err != errNone {
+		return nil, fmt.Errorf("topic %s: %v", topic, kafkaError(meta.err))
+	}
+	if len(meta.partitions) == 0 {
+		return nil, fmt.Errorf("topic %s has no partitions", topic)
+	}
+	return meta.partitions, nil
+}
+
+// Leader 返回分区的 leader
+func (c *client) Leader(topic string, partition int32) (*broker, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, p := range c.topics[topic].partitions {
+		if p.id != partition {
+			continue
+		}
+		if b, ok := c.brokers[p.leader]; ok {
+			return b, nil
+		}
+		break
+	}
+	return nil, fmt.Errorf("topic %s partition %d: %v", topic, partition, kafkaError(errLeaderNotAvailable))
+}
+
+// RefreshMetadata 依次向配置的节点请求 topic 的 metadata，直到有一个节点返回为止
+func (c *client) RefreshMetadata(topics ...string) error {
+	request := encodeMetadataRequest(topics)
+
+	var err error
+	for _, b := range c.bootstrap {
+		var resp []byte
+		resp, err = b.request(apiKeyMetadata, request, true)
+		if err != nil {
+			logp.Warn("kafka: failed to fetch metadata: %v", err)
+			continue
+		}
+
+		var brokers []brokerMeta
+		var meta map[string]topicMeta
+		brokers, meta, err = decodeMetadataResponse(resp)
+		if err != nil {
+			b.Close()
+			continue
+		}
+		c.updateMetadata(brokers, meta)
+		return nil
+	}
+	if err == nil {
+		err = errNoBrokers
+	}
+	return err
+}
+
+func (c *client) updateMetadata(brokers []brokerMeta, topics map[string]topicMeta) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, meta := range brokers {
+		addr := brokerAddress(meta)
+		if b, ok := c.brokers[meta.id]; ok {
+			if b.addr == addr {
+				continue
+			}
+			b.Close()
+		}
+		c.brokers[meta.id] = newBroker(meta.id, addr, c.tls, c.timeout)
+	}
+	for name, meta := range topics {
+		c.topics[name] = meta
+	}
+}
+
+// Close 关闭所有的连接
+func (c *client) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, b := range c.bootstrap {
+		b.Close()
+	}
+	for _, b := range c.brokers {
+		b.Close()
+	}
+}
@@ -0,0 +1,491 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/codec"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPort         = 9092
+	defaultBulkMaxSize  = 2048
+	defaultMaxRetries   = 3
+	defaultTimeout      = 30 * time.Second
+	defaultRequiredAcks = 1
+	defaultBackoff      = 250 * time.Millisecond
+	maxBackoff          = 60 * time.Second
+)
+
+var errClosed = errors.New("kafka output closed")
+
+func init() {
+	outputs.RegisterOutputPlugin("kafka", New)
+}
+
+// kafkaOutput 将事件使用 codec 编码 (默认为 json) 后写入 kafka。
+// topic 可以是固定的，也可以取自事件中的一个字段 (topic_field)；
+// 分区的选择策略有 random、round_robin 和 hash (根据 hash_field 字段的值)。
+// 每条消息单独确认，一批事件中只有部分消息写入成功时，只有失败的消息会被重试
+// kafkaOutput publishes events to kafka
+type kafkaOutput struct {
+	client      *client
+	hosts       []string
+	tls         *tls.Config
+	topic       string
+	topicField  string
+	hashField   string
+	partitioner partitioner
+	codec       codec.Codec
+	acks        int16
+	compression int8
+	timeout     time.Duration
+	bulkMaxSize int
+	maxRetries  int // 小于 0 时一直重试，直到 output 被关闭
+	backoff     time.Duration
+
+	work chan []*pendingMessage
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// pendingMessage 是一条等待写入的消息，batch 在消息写入成功或者最终失败时被通知一次
+type pendingMessage struct {
+	message
+	event     common.MapStr
+	topic     string
+	partition int32
+	batch     *batchSignal
+}
+
+// batchSignal 收集一次 PublishEvents 中每条消息的结果，所有消息都有结果之后通知 signal 一次。
+// 部分消息失败时只报告失败的事件 (signal 支持时)，publisher 重新发送时不会重复写入已经成功的消息
+type batchSignal struct {
+	signal outputs.Signaler
+
+	mutex   sync.Mutex
+	pending int
+	failed  []common.MapStr
+}
+
+func (b *batchSignal) done(event common.MapStr, ok bool) {
+	b.mutex.Lock()
+	if !ok {
+		b.failed = append(b.failed, event)
+	}
+	b.pending--
+	finished := b.pending == 0
+	b.mutex.Unlock()
+
+	if !finished {
+		return
+	}
+	if len(b.failed) == 0 {
+		outputs.SignalCompleted(b.signal)
+	} else {
+		outputs.SignalFailedEvents(b.signal, b.failed)
+	}
+}
+
+// New 创建一个新的 kafka output，需要调用 Init 进行初始化
+func New() outputs.Outputer {
+	return &kafkaOutput{}
+}
+
+// Init 检查配置并启动 worker，metadata 在第一次发送事件的时候加载
+func (out *kafkaOutput) Init(beatName string, config *outputs.MothershipConfig, topologyExpire int) error {
+	configHosts := config.Hosts
+	if len(configHosts) == 0 && config.Host != "" {
+		configHosts = []string{config.Host}
+	}
+	if len(configHosts) == 0 {
+		return errors.New("no hosts configured")
+	}
+	port := defaultPort
+	if config.Port > 0 {
+		port = config.Port
+	}
+	hosts := make([]string, len(configHosts))
+	for i, host := range configHosts {
+		hosts[i] = host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			hosts[i] = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+	}
+
+	if config.Topic == "" && config.TopicField == "" {
+		return errors.New("topic or topic_field must be configured")
+	}
+	if config.Partition == "hash" && config.HashField == "" {
+		return errors.New("hash_field must be configured for the hash partition strategy")
+	}
+	partitioner, err := newPartitioner(config.Partition)
+	if err != nil {
+		return err
+	}
+
+	switch config.Compression {
+	case "", "gzip":
+		out.compression = compressionGZIP
+	case "none":
+		out.compression = compressionNone
+	case "snappy", "lz4":
+		return fmt.Errorf("compression '%s' is not supported, use gzip or none", config.Compression)
+	default:
+		return fmt.Errorf("unknown compression '%s', must be one of none, gzip", config.Compression)
+	}
+
+	acks := defaultRequiredAcks
+	if config.RequiredAcks != nil {
+		acks = *config.RequiredAcks
+	}
+	if acks < -1 {
+		return fmt.Errorf("required_acks must be -1 (all replicas), 0 (no ack) or the number of replicas, got %d", acks)
+	}
+
+	tlsConfig, err := outputs.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return err
+	}
+	encoder, err := outputs.LoadCodec(config)
+	if err != nil {
+		return err
+	}
+
+	out.timeout = defaultTimeout
+	if config.Timeout > 0 {
+		out.timeout = time.Duration(config.Timeout) * time.Second
+	}
+	out.bulkMaxSize = defaultBulkMaxSize
+	if config.BulkMaxSize != nil && *config.BulkMaxSize > 0 {
+		out.bulkMaxSize = *config.BulkMaxSize
+	}
+	out.maxRetries = defaultMaxRetries
+	if config.MaxRetries != nil {
+		out.maxRetries = *config.MaxRetries
+	}
+	out.backoff = defaultBackoff
+	if config.ReconnectInterval > 0 {
+		out.backoff = time.Duration(config.ReconnectInterval) * time.Second
+	}
+
+	out.client = newClient(hosts, tlsConfig, out.timeout)
+	out.hosts = hosts
+	out.tls = tlsConfig
+	out.topic = config.Topic
+	out.topicField = config.TopicField
+	out.hashField = config.HashField
+	out.partitioner = partitioner
+	out.codec = encoder
+	out.acks = int16(acks)
+
+	workers := config.Worker
+	if workers <= 0 {
+		workers = 1
+	}
+	out.work = make(chan []*pendingMessage)
+	out.done = make(chan struct{})
+	for i := 0; i < workers; i++ {
+		out.wg.Add(1)
+		go out.worker()
+	}
+
+	logp.Info("kafka: output to %v, topic '%s', topic_field '%s', required_acks %d",
+		hosts, config.Topic, config.TopicField, acks)
+	return nil
+}
+
+// PublishEvents 将事件编码为消息交给 worker 发送。失败的消息在 worker 中单独重试，
+// 所有消息都有结果之后通知 signal，只有放弃重试的消息会被报告为失败
+func (out *kafkaOutput) PublishEvents(signal outputs.Signaler, events []common.MapStr) error {
+	if len(events) == 0 {
+		outputs.SignalCompleted(signal)
+		return nil
+	}
+
+	batch := &batchSignal{signal: signal, pending: len(events)}
+	messages := make([]*pendingMessage, 0, len(events))
+	for _, event := range events {
+		msg, err := out.newMessage(event)
+		if err != nil {
+			logp.Err("kafka: dropping event: %v", err)
+			batch.done(event, false)
+			continue
+		}
+		msg.batch = batch
+		messages = append(messages, msg)
+	}
+
+	for len(messages) > 0 {
+		n := len(messages)
+		if n > out.bulkMaxSize {
+			n = out.bulkMaxSize
+		}
+
+		select {
+		case out.work <- messages[:n]:
+		case <-out.done:
+			failMessages(messages)
+			return errClosed
+		}
+		messages = messages[n:]
+	}
+	return nil
+}
+
+// newMessage 确定事件的 topic 和 key，并使用 codec 编码事件
+func (out *kafkaOutput) newMessage(event common.MapStr) (*pendingMessage, error) {
+	topic := out.topic
+	if out.topicField != "" {
+		if value, ok := event[out.topicField].(string); ok && value != "" {
+			topic = value
+		}
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("no topic in field '%s'", out.topicField)
+	}
+
+	value, err := out.codec.Encode(event)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &pendingMessage{event: event, topic: topic, partition: -1}
+	msg.value = value
+	if out.hashField != "" {
+		if key, ok := event[out.hashField]; ok && key != nil {
+			msg.key = []byte(fmt.Sprint(key))
+		}
+	}
+	return msg, nil
+}
+
+// TestConnection 单独连接每个节点并加载 metadata，配置了固定的 topic 时还会检查这个 topic 是否可用
+func (out *kafkaOutput) TestConnection() []outputs.HostResult {
+	results := make([]outputs.HostResult, len(out.hosts))
+	for i, host := range out.hosts {
+		c := newClient([]string{host}, out.tls, out.timeout)
+		var err error
+		if out.topic != "" {
+			_, err = c.Partitions(out.topic)
+		} else {
+			err = c.RefreshMetadata()
+		}
+		c.Close()
+		results[i] = outputs.HostResult{Host: host, Err: err}
+	}
+	return results
+}
+
+// Close 停止所有的 worker 并关闭连接，还没有写入成功的消息会被当做失败
+func (out *kafkaOutput) Close() error {
+	if out.done == nil {
+		return nil
+	}
+	select {
+	case <-out.done:
+	default:
+		close(out.done)
+	}
+	out.wg.Wait()
+	out.client.Close()
+	return nil
+}
+
+func (out *kafkaOutput) worker() {
+	defer out.wg.Done()
+	for {
+		select {
+		case <-out.done:
+			return
+		case messages := <-out.work:
+			out.publish(messages)
+		}
+	}
+}
+
+// publish 发送一批消息，失败的消息在刷新 metadata 之后重试，最多重试 max_retries 次
+func (out *kafkaOutput) publish(messages []*pendingMessage) {
+	backoff := out.backoff
+	for attempt := 0; ; attempt++ {
+		messages = out.send(messages)
+		if len(messages) == 0 {
+			return
+		}
+
+		if out.maxRetries >= 0 && attempt >= out.maxRetries {
+			logp.Err("kafka: dropping %d message(s) after %d retries", len(messages), attempt)
+			failMessages(messages)
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-out.done:
+			failMessages(messages)
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		topics := map[string]bool{}
+		var names []string
+		for _, msg := range messages {
+			if !topics[msg.topic] {
+				topics[msg.topic] = true
+				names = append(names, msg.topic)
+			}
+		}
+		if err := out.client.RefreshMetadata(names...); err != nil {
+			logp.Warn("kafka: failed to refresh metadata: %v", err)
+		}
+	}
+}
+
+// send 将消息按照分区的 leader 分组，每个 broker 发送一个 Produce 请求，返回需要重试的消息
+func (out *kafkaOutput) send(messages []*pendingMessage) []*pendingMessage {
+	var retry []*pendingMessage
+	requests := map[*broker]map[string]map[int32][]*pendingMessage{}
+
+	// 每个 topic 只查询一次 metadata，topic 不可用时不会为每条消息重复请求
+	topicPartitions := map[string][]partitionMeta{}
+	topicErrors := map[string]bool{}
+
+	for _, msg := range messages {
+		partitions, ok := topicPartitions[msg.topic]
+		if !ok && !topicErrors[msg.topic] {
+			var err error
+			partitions, err = out.client.Partitions(msg.topic)
+			if err != nil {
+				logp.Warn("kafka: %v", err)
+				topicErrors[msg.topic] = true
+			} else {
+				topicPartitions[msg.topic] = partitions
+			}
+		}
+		if topicErrors[msg.topic] {
+			retry = append(retry, msg)
+			continue
+		}
+
+		// 每次都重新选择分区，这样 random 和 round_robin 会避开没有 leader 的分区
+		msg.partition = out.partitioner(&msg.message, partitions, availablePartitions(partitions))
+		leader, err := out.client.Leader(msg.topic, msg.partition)
+		if err != nil {
+			retry = append(retry, msg)
+			continue
+		}
+
+		topics, ok := requests[leader]
+		if !ok {
+			topics = map[string]map[int32][]*pendingMessage{}
+			requests[leader] = topics
+		}
+		if topics[msg.topic] == nil {
+			topics[msg.topic] = map[int32][]*pendingMessage{}
+		}
+		topics[msg.topic][msg.partition] = append(topics[msg.topic][msg.partition], msg)
+	}
+
+	for leader, topics := range requests {
+		retry = append(retry, out.produce(leader, topics)...)
+	}
+	return retry
+}
+
+// produce 向一个 broker 发送 Produce 请求，根据每个分区的结果通知消息是否写入成功
+func (out *kafkaOutput) produce(b *broker, topics map[string]map[int32][]*pendingMessage) []*pendingMessage {
+	set := produceSet{}
+	var all []*pendingMessage
+	for topic, partitions := range topics {
+		set[topic] = map[int32][]byte{}
+		for partition, messages := range partitions {
+			plain := make([]*message, len(messages))
+			for i, msg := range messages {
+				plain[i] = &msg.message
+			}
+			data, err := encodeMessageSet(plain, out.compression)
+			if err != nil {
+				logp.Err("kafka: failed to encode messages: %v", err)
+				failMessages(messages)
+				continue
+			}
+			set[topic][partition] = data
+			all = append(all, messages...)
+		}
+	}
+
+	request := encodeProduceRequest(out.acks, int32(out.timeout/time.Millisecond), set)
+	resp, err := b.request(apiKeyProduce, request, out.acks != 0)
+	if err != nil {
+		logp.Warn("kafka: %v", err)
+		return all
+	}
+	if out.acks == 0 {
+		completeMessages(all)
+		return nil
+	}
+
+	results, err := decodeProduceResponse(resp)
+	if err != nil {
+		logp.Warn("kafka: broker %s: %v", b.addr, err)
+		b.Close()
+		return all
+	}
+
+	var retry []*pendingMessage
+	for topic, partitions := range topics {
+		for partition, messages := range partitions {
+			if _, ok := set[topic][partition]; !ok {
+				continue
+			}
+
+			code, ok := results[topic][partition]
+			switch {
+			case !ok:
+				retry = append(retry, messages...)
+			case code == errNone:
+				completeMessages(messages)
+			case kafkaError(code).retriable():
+				logp.Warn("kafka: topic %s partition %d: %v", topic, partition, kafkaError(code))
+				retry = append(retry, messages...)
+			default:
+				logp.Err("kafka: dropping %d message(s) for topic %s partition %d: %v",
+					len(messages), topic, partition, kafkaError(code))
+				failMessages(messages)
+			}
+		}
+	}
+	return retry
+}
+
+func availablePartitions(partitions []partitionMeta) []partitionMeta {
+	var available []partitionMeta
+	for _, p := range partitions {
+		if p.leader >= 0 && p.err != errLeaderNotAvailable {
+			available = append(available, p)
+		}
+	}
+	return available
+}
+
+func completeMessages(messages []*pendingMessage) {
+	for _, msg := range messages {
+		msg.batch.done(msg.event, true)
+	}
+}
+
+func failMessages(messages []*pendingMessage) {
+	for _, msg := range messages {
+		msg.batch.done(msg.event, false)
+	}
+}
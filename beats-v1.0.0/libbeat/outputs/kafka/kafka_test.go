@@ -0,0 +1,471 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/internal/outputtest"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBroker 是一个只支持 Metadata v0 和 Produce v0 请求的 kafka broker，
+// 它是集群中唯一的 broker，所有分区的 leader 都是它自己
+type fakeBroker struct {
+	ln net.Listener
+
+	mutex     sync.Mutex
+	topics    map[string]int                            // topic -> 分区数量
+	messages  map[string]map[int32][]common.MapStr      // topic -> partition -> 收到的消息
+	keys      map[string]map[int32][]string             // topic -> partition -> 消息的 key
+	produces  int                                       // 收到的 Produce 请求数量
+	onProduce func(topic string, partition int32) int16 // 返回分区的错误码
+}
+
+func newFakeBroker(t *testing.T, topics map[string]int) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &fakeBroker{
+		ln:       ln,
+		topics:   topics,
+		messages: map[string]map[int32][]common.MapStr{},
+		keys:     map[string]map[int32][]string{},
+	}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) Addr() string { return b.ln.Addr().String() }
+
+func (b *fakeBroker) Close() { b.ln.Close() }
+
+func (b *fakeBroker) Messages(topic string, partition int32) []common.MapStr {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.messages[topic][partition]
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+
+		d := &decoder{data: data}
+		apiKey := d.getInt16()
+		d.getInt16() // version
+		correlationID := d.getInt32()
+		d.getString() // client id
+
+		var resp []byte
+		switch apiKey {
+		case apiKeyMetadata:
+			resp = b.metadata(d)
+		case apiKeyProduce:
+			resp = b.produce(d)
+		default:
+			return
+		}
+		if resp == nil {
+			continue
+		}
+
+		var e encoder
+		e.putInt32(int32(4 + len(resp)))
+		e.putInt32(correlationID)
+		e.Write(resp)
+		if _, err := conn.Write(e.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func (b *fakeBroker) metadata(d *decoder) []byte {
+	var topics []string
+	for i, n := 0, d.getInt32(); int32(i) < n; i++ {
+		topics = append(topics, d.getString())
+	}
+
+	host, port, _ := net.SplitHostPort(b.Addr())
+	portNum, _ := strconv.Atoi(port)
+
+	var e encoder
+	e.putInt32(1)
+	e.putInt32(0)
+	e.putString(host)
+	e.putInt32(int32(portNum))
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	e.putInt32(int32(len(topics)))
+	for _, topic := range topics {
+		count, ok := b.topics[topic]
+		if !ok {
+			e.putInt16(errUnknownTopicOrPartition)
+			e.putString(topic)
+			e.putInt32(0)
+			continue
+		}
+		e.putInt16(errNone)
+		e.putString(topic)
+		e.putInt32(int32(count))
+		for i := 0; i < count; i++ {
+			e.putInt16(errNone)
+			e.putInt32(int32(i))
+			e.putInt32(0) // leader
+			e.putInt32(1)
+			e.putInt32(0) // replicas
+			e.putInt32(1)
+			e.putInt32(0) // isr
+		}
+	}
+	return e.Bytes()
+}
+
+func (b *fakeBroker) produce(d *decoder) []byte {
+	acks := d.getInt16()
+	d.getInt32() // timeout
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.produces++
+
+	var e encoder
+	topics := d.getInt32()
+	e.putInt32(topics)
+	for i := int32(0); i < topics; i++ {
+		topic := d.getString()
+		e.putString(topic)
+
+		partitions := d.getInt32()
+		e.putInt32(partitions)
+		for j := int32(0); j < partitions; j++ {
+			partition := d.getInt32()
+			messages, err := decodeMessageSet(d.getBytes())
+			if err != nil {
+				panic(err)
+			}
+
+			code := errNone
+			if b.onProduce != nil {
+				code = b.onProduce(topic, partition)
+			}
+			if code == errNone {
+				if b.messages[topic] == nil {
+					b.messages[topic] = map[int32][]common.MapStr{}
+					b.keys[topic] = map[int32][]string{}
+				}
+				for _, msg := range messages {
+					event := common.MapStr{}
+					json.Unmarshal(msg.value, &event)
+					b.messages[topic][partition] = append(b.messages[topic][partition], event)
+					b.keys[topic][partition] = append(b.keys[topic][partition], string(msg.key))
+				}
+			}
+
+			e.putInt32(partition)
+			e.putInt16(code)
+			e.putInt64(0)
+		}
+	}
+
+	if acks == 0 {
+		return nil
+	}
+	return e.Bytes()
+}
+
+// newTestOutput 返回初始化好的 kafka output，超时时间默认为 5 秒，重试时只等待 1 毫秒
+func newTestOutput(t *testing.T, config outputs.MothershipConfig) *kafkaOutput {
+	out := New().(*kafkaOutput)
+	if config.Timeout == 0 {
+		config.Timeout = 5
+	}
+	outputtest.Init(t, out, config)
+	out.backoff = time.Millisecond
+	return out
+}
+
+func TestProtocol_MessageSet(t *testing.T) {
+	messages := []*message{
+		{key: []byte("a"), value: []byte("1")},
+		{key: nil, value: []byte("2")},
+	}
+	for _, compression := range []int8{compressionNone, compressionGZIP} {
+		data, err := encodeMessageSet(messages, compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeMessageSet(data)
+		if err != nil {
+			t.Fatalf("compression %d: %v", compression, err)
+		}
+		if len(decoded) != 2 || string(decoded[0].key) != "a" || decoded[1].key != nil ||
+			string(decoded[0].value) != "1" || string(decoded[1].value) != "2" {
+			t.Errorf("compression %d: unexpected messages %v", compression, decoded)
+		}
+	}
+}
+
+func TestPublishEvents_RoundRobin(t *testing.T) {
+	broker := newFakeBroker(t, map[string]int{"logs": 3})
+	defer broker.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:     []string{broker.Addr()},
+		Topic:     "logs",
+		Partition: "round_robin",
+	})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(6)) {
+		t.Fatal("publish failed")
+	}
+	total := 0
+	for partition := int32(0); partition < 3; partition++ {
+		n := len(broker.Messages("logs", partition))
+		if n != 2 {
+			t.Errorf("partition %d: expected 2 messages, got %d", partition, n)
+		}
+		total += n
+	}
+	if total != 6 {
+		t.Errorf("expected 6 messages, got %d", total)
+	}
+}
+
+func TestPublishEvents_TopicField(t *testing.T) {
+	broker := newFakeBroker(t, map[string]int{"logs": 1, "nginx": 1})
+	defer broker.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:       []string{broker.Addr()},
+		Topic:       "logs",
+		TopicField:  "type",
+		Compression: "none",
+	})
+	defer out.Close()
+
+	events := []common.MapStr{
+		{"type": "nginx", "message": "a"},
+		{"message": "b"},
+		{"type": "nginx", "message": "c"},
+	}
+	if !outputtest.Publish(t, out, events) {
+		t.Fatal("publish failed")
+	}
+	if n := len(broker.Messages("nginx", 0)); n != 2 {
+		t.Errorf("expected 2 messages in topic from field, got %d", n)
+	}
+	if n := len(broker.Messages("logs", 0)); n != 1 {
+		t.Errorf("expected 1 message in default topic, got %d", n)
+	}
+}
+
+func TestPublishEvents_Hash(t *testing.T) {
+	broker := newFakeBroker(t, map[string]int{"logs": 4})
+	defer broker.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:     []string{broker.Addr()},
+		Topic:     "logs",
+		Partition: "hash",
+		HashField: "host",
+	})
+	defer out.Close()
+
+	var events []common.MapStr
+	for i := 0; i < 20; i++ {
+		events = append(events, common.MapStr{"host": "host" + strconv.Itoa(i%3), "n": i})
+	}
+	if !outputtest.Publish(t, out, events) {
+		t.Fatal("publish failed")
+	}
+
+	// 同一个 host 的消息都在同一个分区中，并且 key 是 host 的值
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	seen := map[string]int32{}
+	total := 0
+	for partition, keys := range broker.keys["logs"] {
+		for _, key := range keys {
+			if p, ok := seen[key]; ok && p != partition {
+				t.Errorf("key %s written to partitions %d and %d", key, p, partition)
+			}
+			seen[key] = partition
+			total++
+		}
+	}
+	if total != 20 || len(seen) != 3 {
+		t.Errorf("expected 20 messages with 3 keys, got %d messages with keys %v", total, seen)
+	}
+}
+
+func TestPublishEvents_Retry(t *testing.T) {
+	broker := newFakeBroker(t, map[string]int{"logs": 2})
+	defer broker.Close()
+
+	// 第一次写入分区 0 时返回 not leader，需要刷新 metadata 后重试
+	failed := false
+	broker.onProduce = func(topic string, partition int32) int16 {
+		if partition == 0 && !failed {
+			failed = true
+			return errNotLeaderForPartition
+		}
+		return errNone
+	}
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:     []string{broker.Addr()},
+		Topic:     "logs",
+		Partition: "round_robin",
+	})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(4)) {
+		t.Fatal("publish failed")
+	}
+	if n0, n1 := len(broker.Messages("logs", 0)), len(broker.Messages("logs", 1)); n0+n1 != 4 {
+		t.Errorf("expected 4 messages, got %d and %d", n0, n1)
+	}
+}
+
+func TestPublishEvents_PerMessageFailure(t *testing.T) {
+	broker := newFakeBroker(t, map[string]int{"logs": 2})
+	defer broker.Close()
+
+	// 分区 1 的消息因为太大被拒绝，这种错误不会重试
+	broker.onProduce = func(topic string, partition int32) int16 {
+		if partition == 1 {
+			return 10 // message size too large
+		}
+		return errNone
+	}
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:     []string{broker.Addr()},
+		Topic:     "logs",
+		Partition: "round_robin",
+	})
+	defer out.Close()
+
+	// 只有分区 1 的两条消息被报告为失败
+	signal := outputtest.NewEventsSignal()
+	if err := out.PublishEvents(signal, outputtest.Events(4)); err != nil {
+		t.Fatal(err)
+	}
+	if signal.Wait() {
+		t.Fatal("expected publish to report the failed messages")
+	}
+	if len(signal.Events) != 2 {
+		t.Errorf("expected 2 failed events, got %v", signal.Events)
+	}
+	if n := len(broker.Messages("logs", 0)); n != 2 {
+		t.Errorf("expected the messages of partition 0 to be written, got %d", n)
+	}
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if broker.produces != 1 {
+		t.Errorf("non retriable errors must not be retried, got %d produce requests", broker.produces)
+	}
+}
+
+func TestPublishEvents_MaxRetries(t *testing.T) {
+	broker := newFakeBroker(t, map[string]int{})
+	defer broker.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:      []string{broker.Addr()},
+		Topic:      "missing",
+		MaxRetries: outputtest.IntPtr(2),
+	})
+	defer out.Close()
+
+	if outputtest.Publish(t, out, outputtest.Events(2)) {
+		t.Fatal("expected publish to fail for unknown topic")
+	}
+}
+
+func TestPublishEvents_NoAcks(t *testing.T) {
+	broker := newFakeBroker(t, map[string]int{"logs": 1})
+	defer broker.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:        []string{broker.Addr()},
+		Topic:        "logs",
+		RequiredAcks: outputtest.IntPtr(0),
+	})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(3)) {
+		t.Fatal("publish failed")
+	}
+
+	// 没有确认的时候只能等待 broker 处理完请求
+	for i := 0; i < 100 && len(broker.Messages("logs", 0)) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(broker.Messages("logs", 0)); n != 3 {
+		t.Errorf("expected 3 messages, got %d", n)
+	}
+}
+
+func TestInit_Errors(t *testing.T) {
+	configs := []outputs.MothershipConfig{
+		{Hosts: []string{"localhost"}},
+		{Hosts: []string{"localhost"}, Topic: "logs", Compression: "snappy"},
+		{Hosts: []string{"localhost"}, Topic: "logs", Partition: "sticky"},
+		{Hosts: []string{"localhost"}, Topic: "logs", Partition: "hash"},
+		{Hosts: []string{"localhost"}, Topic: "logs", RequiredAcks: outputtest.IntPtr(-2)},
+	}
+	for i, config := range configs {
+		if err := New().Init("testbeat", &config, 0); err == nil {
+			t.Errorf("config %d: expected error", i)
+		}
+	}
+}
+
+func TestTestConnection(t *testing.T) {
+	broker := newFakeBroker(t, map[string]int{"logs": 1})
+	defer broker.Close()
+	down := newFakeBroker(t, nil)
+	down.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{Hosts: []string{broker.Addr(), down.Addr()}, Topic: "logs"})
+	defer out.Close()
+	results := out.TestConnection()
+	if len(results) != 2 || results[0].Err != nil || results[1].Err == nil {
+		t.Fatalf("unexpected results %v", results)
+	}
+
+	// 不存在的 topic
+	missing := newTestOutput(t, outputs.MothershipConfig{Hosts: []string{broker.Addr()}, Topic: "missing"})
+	defer missing.Close()
+	if results := missing.TestConnection(); len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("expected unknown topic error, got %v", results)
+	}
+}
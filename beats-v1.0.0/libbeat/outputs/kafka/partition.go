@@ -0,0 +1,55 @@
+package kafka
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync/atomic"
+)
+
+// partitioner 为一条消息选择分区，available 是当前有 leader 的分区
+type partitioner func(msg *message, partitions, available []partitionMeta) int32
+
+// newPartitioner 根据配置的策略创建 partitioner:
+//   - random: 随机选择一个可用的分区 (默认)
+//   - round_robin: 依次使用每一个可用的分区
+//   - hash: 根据消息的 key 计算分区，同样 key 的消息总是写入同一个分区，没有 key 时随机选择
+func newPartitioner(strategy string) (partitioner, error) {
+	switch strategy {
+	case "", "random":
+		return randomPartitioner, nil
+	case "round_robin":
+		var next uint32
+		return func(msg *message, partitions, available []partitionMeta) int32 {
+			candidates := pickFrom(partitions, available)
+			n := atomic.AddUint32(&next, 1) - 1
+			return candidates[n%uint32(len(candidates))].id
+		}, nil
+	case "hash":
+		return hashPartitioner, nil
+	}
+	return nil, fmt.Errorf("unknown partition strategy '%s', must be one of random, round_robin, hash", strategy)
+}
+
+func randomPartitioner(msg *message, partitions, available []partitionMeta) int32 {
+	candidates := pickFrom(partitions, available)
+	return candidates[rand.Intn(len(candidates))].id
+}
+
+// hashPartitioner 使用 key 的 FNV-1a 哈希 (和 sarama 一样)，只要分区数量不变，同一个 key 总是写入同一个分区
+func hashPartitioner(msg *message, partitions, available []partitionMeta) int32 {
+	if msg.key == nil {
+		return randomPartitioner(msg, partitions, available)
+	}
+	h := fnv.New32a()
+	h.Write(msg.key)
+	return partitions[h.Sum32()%uint32(len(partitions))].id
+}
+
+// pickFrom 优先从有 leader 的分区中选择，所有的分区都没有 leader 时从全部分区中选择
+func pickFrom(partitions, available []partitionMeta) []partitionMeta {
+	if len(available) > 0 {
+		return available
+	}
+	return partitions
+}
@@ -0,0 +1,364 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"sort"
+)
+
+// kafka 协议中用到的请求类型，这里只实现了生产者需要的 Produce 和 Metadata 请求的 v0 版本
+const (
+	apiKeyProduce  int16 = 0
+	apiKeyMetadata int16 = 3
+
+	compressionNone int8 = 0
+	compressionGZIP int8 = 1
+)
+
+// kafka 返回的错误码，只列出了生产者需要处理的部分
+const (
+	errNone                    int16 = 0
+	errUnknownTopicOrPartition int16 = 3
+	errLeaderNotAvailable      int16 = 5
+	errNotLeaderForPartition   int16 = 6
+	errRequestTimedOut         int16 = 7
+	errNetworkException        int16 = 13
+	errNotEnoughReplicas       int16 = 19
+	errNotEnoughReplicasAfter  int16 = 20
+)
+
+var errShortResponse = errors.New("kafka: short response")
+
+// kafkaError 是 kafka 返回的错误码
+type kafkaError int16
+
+func (e kafkaError) Error() string {
+	switch int16(e) {
+	case errUnknownTopicOrPartition:
+		return "kafka: unknown topic or partition"
+	case errLeaderNotAvailable:
+		return "kafka: leader not available"
+	case errNotLeaderForPartition:
+		return "kafka: not leader for partition"
+	case errRequestTimedOut:
+		return "kafka: request timed out"
+	case errNotEnoughReplicas, errNotEnoughReplicasAfter:
+		return "kafka: not enough replicas"
+	}
+	return fmt.Sprintf("kafka: error code %d", int16(e))
+}
+
+// retriable 返回是否可以在刷新 metadata 之后重新发送
+func (e kafkaError) retriable() bool {
+	switch int16(e) {
+	case errUnknownTopicOrPartition, errLeaderNotAvailable, errNotLeaderForPartition,
+		errRequestTimedOut, errNetworkException, errNotEnoughReplicas, errNotEnoughReplicasAfter:
+		return true
+	}
+	return false
+}
+
+// encoder 按照 kafka 协议的格式 (大端) 编码请求
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) putInt8(v int8) { e.WriteByte(byte(v)) }
+
+func (e *encoder) putInt16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) putInt32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) putInt64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) putString(s string) {
+	e.putInt16(int16(len(s)))
+	e.WriteString(s)
+}
+
+// putBytes 编码一个字节数组，nil 编码为长度 -1
+func (e *encoder) putBytes(b []byte) {
+	if b == nil {
+		e.putInt32(-1)
+		return
+	}
+	e.putInt32(int32(len(b)))
+	e.Write(b)
+}
+
+// decoder 解析 kafka 的返回结果，数据不够时记录错误，后面的读取都返回 0
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data) < n {
+		d.err = errShortResponse
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) getInt8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) getInt16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) getInt32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) getInt64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *decoder) getString() string {
+	return string(d.next(int(d.getInt16())))
+}
+
+func (d *decoder) getBytes() []byte {
+	n := d.getInt32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+func (d *decoder) getInt32Array() []int32 {
+	n := d.getInt32()
+	if n < 0 {
+		return nil
+	}
+	var values []int32
+	for i := int32(0); i < n && d.err == nil; i++ {
+		values = append(values, d.getInt32())
+	}
+	return values
+}
+
+// message 是要写入 kafka 的一条消息
+type message struct {
+	key   []byte
+	value []byte
+}
+
+// encodeMessage 编码一条 v0 格式的消息:
+// offset int64, size int32, crc int32, magic int8, attributes int8, key bytes, value bytes
+func encodeMessage(e *encoder, offset int64, attributes int8, key, value []byte) {
+	var body encoder
+	body.putInt8(0) // magic
+	body.putInt8(attributes)
+	body.putBytes(key)
+	body.putBytes(value)
+
+	e.putInt64(offset)
+	e.putInt32(int32(4 + body.Len()))
+	e.putInt32(int32(crc32.ChecksumIEEE(body.Bytes())))
+	e.Write(body.Bytes())
+}
+
+// encodeMessageSet 编码一个分区的所有消息，压缩时所有消息被压缩后放在一条消息中
+func encodeMessageSet(messages []*message, compression int8) ([]byte, error) {
+	var set encoder
+	for i, msg := range messages {
+		encodeMessage(&set, int64(i), compressionNone, msg.key, msg.value)
+	}
+	if compression == compressionNone {
+		return set.Bytes(), nil
+	}
+
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write(set.Bytes())
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var wrapper encoder
+	encodeMessage(&wrapper, int64(len(messages)-1), compression, nil, compressed.Bytes())
+	return wrapper.Bytes(), nil
+}
+
+// decodeMessageSet 解析一个分区的消息，压缩过的消息会被解压。测试中的 fake broker 使用
+func decodeMessageSet(data []byte) ([]*message, error) {
+	d := &decoder{data: data}
+	var messages []*message
+	for len(d.data) > 0 && d.err == nil {
+		d.getInt64() // offset
+		size := d.getInt32()
+		body := &decoder{data: d.next(int(size))}
+		crc := uint32(body.getInt32())
+		if d.err == nil && crc != crc32.ChecksumIEEE(body.data) {
+			return nil, errors.New("kafka: message crc mismatch")
+		}
+		body.getInt8() // magic
+		attributes := body.getInt8()
+		key := body.getBytes()
+		value := body.getBytes()
+		if body.err != nil {
+			return nil, body.err
+		}
+
+		if attributes&0x07 == compressionGZIP {
+			r, err := gzip.NewReader(bytes.NewReader(value))
+			if err != nil {
+				return nil, err
+			}
+			inner, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			nested, err := decodeMessageSet(inner)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, nested...)
+			continue
+		}
+		messages = append(messages, &message{key: key, value: value})
+	}
+	return messages, d.err
+}
+
+// produceSet 是一个 Produce 请求中的所有消息，按照 topic 和分区分组
+type produceSet map[string]map[int32][]byte
+
+// encodeProduceRequest 编码 Produce v0 请求:
+// acks int16, timeout int32, [topic string, [partition int32, message_set_size int32, message_set]]
+func encodeProduceRequest(acks int16, timeoutMs int32, set produceSet) []byte {
+	var e encoder
+	e.putInt16(acks)
+	e.putInt32(timeoutMs)
+
+	topics := make([]string, 0, len(set))
+	for topic := range set {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	e.putInt32(int32(len(topics)))
+	for _, topic := range topics {
+		e.putString(topic)
+
+		partitions := set[topic]
+		ids := make([]int, 0, len(partitions))
+		for id := range partitions {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+
+		e.putInt32(int32(len(ids)))
+		for _, id := range ids {
+			e.putInt32(int32(id))
+			e.putBytes(partitions[int32(id)])
+		}
+	}
+	return e.Bytes()
+}
+
+// decodeProduceResponse 解析 Produce v0 的返回结果，返回每个分区的错误码
+func decodeProduceResponse(data []byte) (map[string]map[int32]int16, error) {
+	d := &decoder{data: data}
+	result := map[string]map[int32]int16{}
+	for i, n := 0, d.getInt32(); int32(i) < n && d.err == nil; i++ {
+		topic := d.getString()
+		result[topic] = map[int32]int16{}
+		for j, m := 0, d.getInt32(); int32(j) < m && d.err == nil; j++ {
+			partition := d.getInt32()
+			result[topic][partition] = d.getInt16()
+			d.getInt64() // offset
+		}
+	}
+	return result, d.err
+}
+
+// brokerMeta 和 partitionMeta 是 Metadata 请求返回的 broker 和分区信息
+type brokerMeta struct {
+	id   int32
+	host string
+	port int32
+}
+
+type partitionMeta struct {
+	id     int32
+	leader int32 // 没有 leader 时为 -1
+	err    int16
+}
+
+type topicMeta struct {
+	err        int16
+	partitions []partitionMeta
+}
+
+// encodeMetadataRequest 编码 Metadata v0 请求: [topic string]
+func encodeMetadataRequest(topics []string) []byte {
+	var e encoder
+	e.putInt32(int32(len(topics)))
+	for _, topic := range topics {
+		e.putString(topic)
+	}
+	return e.Bytes()
+}
+
+// decodeMetadataResponse 解析 Metadata v0 的返回结果
+func decodeMetadataResponse(data []byte) ([]brokerMeta, map[string]topicMeta, error) {
+	d := &decoder{data: data}
+
+	var brokers []brokerMeta
+	for i, n := 0, d.getInt32(); int32(i) < n && d.err == nil; i++ {
+		brokers = append(brokers, brokerMeta{id: d.getInt32(), host: d.getString(), port: d.getInt32()})
+	}
+
+	topics := map[string]topicMeta{}
+	for i, n := 0, d.getInt32(); int32(i) < n && d.err == nil; i++ {
+		meta := topicMeta{err: d.getInt16()}
+		name := d.getString()
+		for j, m := 0, d.getInt32(); int32(j) < m && d.err == nil; j++ {
+			p := partitionMeta{err: d.getInt16(), id: d.getInt32(), leader: d.getInt32()}
+			d.getInt32Array() // replicas
+			d.getInt32Array() // isr
+			meta.partitions = append(meta.partitions, p)
+		}
+		sort.Slice(meta.partitions, func(a, b int) bool { return meta.partitions[a].id < meta.partitions[b].id })
+		topics[name] = meta
+	}
+	return brokers, topics, d.err
+}
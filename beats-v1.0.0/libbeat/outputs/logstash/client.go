@@ -0,0 +1,230 @@
+package logstash
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"io"
+	"net"
+	"time"
+)
+
+// lumberjack v2 协议的帧类型，每一帧都以版本号 '2' 和帧类型开头:
+//
+//	window:     '2' 'W' uint32(窗口大小)
+//	json:       '2' 'J' uint32(序号) uint32(长度) payload
+//	compressed: '2' 'C' uint32(长度) zlib 压缩后的帧
+//	ack:        '2' 'A' uint32(序号)
+//
+// 每个窗口中的事件序号从 1 开始，服务端确认的序号等于窗口大小时整个窗口发送完成
+const (
+	codeVersion    byte = '2'
+	codeWindowSize byte = 'W'
+	codeJSONData   byte = 'J'
+	codeCompressed byte = 'C'
+	codeAck        byte = 'A'
+)
+
+var errProtocol = errors.New("lumberjack protocol error")
+
+// lumberjackClient 是到一个 logstash 节点的连接
+// lumberjackClient publishes events to a single logstash host using the
+// lumberjack v2 protocol
+type lumberjackClient struct {
+	address          string
+	tls              *tls.Config
+	timeout          time.Duration
+	compressionLevel int
+
+	conn   net.Conn
+	window window
+}
+
+func newLumberjackClient(
+	address string,
+	tlsConfig *tls.Config,
+	timeout time.Duration,
+	compressionLevel int,
+	maxWindowSize int,
+) *lumberjackClient {
+	c := &lumberjackClient{
+		address:          address,
+		tls:              tlsConfig,
+		timeout:          timeout,
+		compressionLevel: compressionLevel,
+	}
+	c.window.init(defaultStartWindowSize, maxWindowSize)
+	return c
+}
+
+// Connect 建立连接，已经连接时什么都不做
+func (c *lumberjackClient) Connect() error {
+	if c.conn != nil {
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: c.timeout}
+	var conn net.Conn
+	var err error
+	if c.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, c.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", c.address)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.conn = conn
+	return nil
+}
+
+// IsConnected 返回 TCP (或 TLS) 连接是否已经建立
+func (c *lumberjackClient) IsConnected() bool {
+	return c.conn != nil
+}
+
+// Close 关闭连接，没有收到 ACK 的事件由 ConnectionMode 交给其他的 worker 重新发送
+func (c *lumberjackClient) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// PublishEvents 按照当前的窗口大小分批发送事件，返回没有被服务端确认的事件。
+// 出错时连接会被关闭，没有确认的事件需要重新发送
+func (c *lumberjackClient) PublishEvents(events []common.MapStr) ([]common.MapStr, error) {
+	if err := c.Connect(); err != nil {
+		return events, err
+	}
+
+	published := 0
+	for published < len(events) {
+		n, err := c.publishWindowed(events[published:])
+		published += n
+		if err != nil {
+			c.window.shrink()
+			c.Close()
+			return events[published:], fmt.Errorf("failed to publish events to %s: %v", c.address, err)
+		}
+	}
+	return nil, nil
+}
+
+// publishWindowed 发送一个窗口的事件并等待服务端的确认
+func (c *lumberjackClient) publishWindowed(events []common.MapStr) (int, error) {
+	windowSize := c.window.get()
+	if len(events) > windowSize {
+		events = events[:windowSize]
+	}
+
+	payload, count := encodeEvents(events)
+	if count == 0 {
+		// 所有事件都无法编码，直接丢弃
+		return len(events), nil
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{codeVersion, codeWindowSize})
+	writeUint32(&buf, uint32(count))
+
+	if c.compressionLevel > 0 {
+		var compressed bytes.Buffer
+		w, err := zlib.NewWriterLevel(&compressed, c.compressionLevel)
+		if err != nil {
+			return 0, err
+		}
+		w.Write(payload)
+		if err := w.Close(); err != nil {
+			return 0, err
+		}
+
+		buf.Write([]byte{codeVersion, codeCompressed})
+		writeUint32(&buf, uint32(compressed.Len()))
+		buf.Write(compressed.Bytes())
+	} else {
+		buf.Write(payload)
+	}
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	acked, err := c.awaitACK(uint32(count))
+	if err != nil {
+		if count != len(events) {
+			// 有事件被丢弃时序号和事件对应不上，整个窗口都需要重新发送
+			return 0, err
+		}
+		return int(acked), err
+	}
+
+	c.window.tryGrow(len(events))
+	return len(events), nil
+}
+
+// awaitACK 读取服务端的确认，直到序号等于 count。
+// logstash 处理较慢时会先发送较小的序号，表示连接仍然可用
+func (c *lumberjackClient) awaitACK(count uint32) (uint32, error) {
+	var acked uint32
+	for acked < count {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return acked, err
+		}
+
+		var header [6]byte
+		if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+			return acked, err
+		}
+		if header[0] != codeVersion || header[1] != codeAck {
+			return acked, errProtocol
+		}
+
+		seq := binary.BigEndian.Uint32(header[2:])
+		if seq > count {
+			return acked, fmt.Errorf("%v: ack %d for window of %d events", errProtocol, seq, count)
+		}
+		if seq > acked {
+			acked = seq
+		}
+	}
+	return acked, nil
+}
+
+// encodeEvents 将事件编码为 json 帧，序号从 1 开始。无法编码的事件会被丢弃
+func encodeEvents(events []common.MapStr) ([]byte, int) {
+	var buf bytes.Buffer
+	var seq uint32
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			logp.Err("logstash: failed to encode event, dropping it: %v", err)
+			continue
+		}
+
+		seq++
+		buf.Write([]byte{codeVersion, codeJSONData})
+		writeUint32(&buf, seq)
+		writeUint32(&buf, uint32(len(data)))
+		buf.Write(data)
+	}
+	return buf.Bytes(), int(seq)
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
@@ -0,0 +1,131 @@
+package logstash
+
+import (
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/mode"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPort             = 5044
+	defaultBulkMaxSize      = 2048
+	defaultMaxRetries       = 3
+	defaultTimeout          = 30 * time.Second
+	defaultCompressionLevel = 3
+	maxBackoff              = 60 * time.Second
+)
+
+// defaultBackoff 是连接断开或者等待 ACK 超时后，worker 重新连接之前第一次等待的时间，测试中会改小
+var defaultBackoff = 1 * time.Second
+
+func init() {
+	outputs.RegisterOutputPlugin("logstash", New)
+}
+
+// logstashOutput 使用 lumberjack v2 协议将事件发送到 logstash。
+// loadbalance 为 true 时每个节点都有自己的 worker，事件会分散的发送到所有节点；
+// 否则同一时间只使用一个节点，节点失败时切换到下一个节点
+// logstashOutput publishes events to logstash using the lumberjack protocol
+type logstashOutput struct {
+	mode *mode.ConnectionMode
+}
+
+// New 创建一个新的 logstash output，需要调用 Init 进行初始化
+func New() outputs.Outputer {
+	return &logstashOutput{}
+}
+
+// Init 检查配置并启动 worker，到 logstash 的连接 (以及 TLS 握手) 在第一次发送事件的时候建立
+func (out *logstashOutput) Init(beatName string, config *outputs.MothershipConfig, topologyExpire int) error {
+	hosts := config.Hosts
+	if len(hosts) == 0 && config.Host != "" {
+		hosts = []string{config.Host}
+	}
+	if len(hosts) == 0 {
+		return errors.New("no hosts configured")
+	}
+
+	port := defaultPort
+	if config.Port > 0 {
+		port = config.Port
+	}
+	timeout := defaultTimeout
+	if config.Timeout > 0 {
+		timeout = time.Duration(config.Timeout) * time.Second
+	}
+	compressionLevel := defaultCompressionLevel
+	if config.CompressionLevel != nil {
+		compressionLevel = *config.CompressionLevel
+	}
+	if compressionLevel < zlib.NoCompression || compressionLevel > zlib.BestCompression {
+		return fmt.Errorf("compression_level must be between 0 and 9, got %d", compressionLevel)
+	}
+
+	tlsConfig, err := outputs.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return err
+	}
+
+	settings := mode.Settings{
+		Name:        "logstash",
+		BulkMaxSize: defaultBulkMaxSize,
+		MaxRetries:  defaultMaxRetries,
+		Backoff:     defaultBackoff,
+		MaxBackoff:  maxBackoff,
+	}
+	if config.BulkMaxSize != nil && *config.BulkMaxSize > 0 {
+		settings.BulkMaxSize = *config.BulkMaxSize
+	}
+	if config.MaxRetries != nil {
+		settings.MaxRetries = *config.MaxRetries
+	}
+	if config.ReconnectInterval > 0 {
+		settings.Backoff = time.Duration(config.ReconnectInterval) * time.Second
+	}
+
+	factory := func(host string) (mode.ProtocolClient, error) {
+		address := hostAddress(host, port)
+		return newLumberjackClient(address, tlsConfig, timeout, compressionLevel, settings.BulkMaxSize), nil
+	}
+	loadBalance := config.LoadBalance != nil && *config.LoadBalance
+	out.mode, err = mode.NewConnectionMode(hosts, loadBalance, config.Worker, factory, settings)
+	if err != nil {
+		return err
+	}
+
+	logp.Info("logstash: output to %v, loadbalance %v, tls %v", hosts, loadBalance, tlsConfig != nil)
+	return nil
+}
+
+// hostAddress 没有端口时加上默认的端口
+func hostAddress(host string, port int) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// PublishEvents 将事件交给 worker 按照 lumberjack 的窗口大小分批发送，所有事件都收到 ACK 后通知 signal
+func (out *logstashOutput) PublishEvents(signal outputs.Signaler, events []common.MapStr) error {
+	return out.mode.PublishEvents(signal, events)
+}
+
+// TestConnection 使用发送事件时同样的 lumberjack client 连接每个节点，配置了 TLS 时会完成握手
+func (out *logstashOutput) TestConnection() []outputs.HostResult {
+	return out.mode.TestConnection()
+}
+
+// Close 停止所有的 worker 并关闭到 logstash 的连接，还没有收到 ACK 的事件会被当做发送失败
+func (out *logstashOutput) Close() error {
+	if out.mode == nil {
+		return nil
+	}
+	return out.mode.Close()
+}
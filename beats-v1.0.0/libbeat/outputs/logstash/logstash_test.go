@@ -0,0 +1,392 @@
+package logstash
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/internal/outputtest"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer 是一个简单的 lumberjack v2 服务端，收到完整的窗口后发送确认
+type fakeServer struct {
+	ln net.Listener
+
+	mutex       sync.Mutex
+	events      []common.MapStr
+	windows     []uint32
+	failWindows int           // 前 failWindows 个窗口不确认，直接关闭连接
+	ackDelay    time.Duration // 发送确认之前等待的时间
+}
+
+func newFakeServer(t *testing.T, tlsConfig *tls.Config) *fakeServer {
+	var ln net.Listener
+	var err error
+	if tlsConfig != nil {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeServer{ln: ln}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) Addr() string { return s.ln.Addr().String() }
+
+func (s *fakeServer) Close() { s.ln.Close() }
+
+func (s *fakeServer) Events() []common.MapStr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]common.MapStr(nil), s.events...)
+}
+
+func (s *fakeServer) Windows() []uint32 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]uint32(nil), s.windows...)
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var window, received uint32
+	var pending []common.MapStr
+	onEvent := func(event common.MapStr) bool {
+		pending = append(pending, event)
+		received++
+		if received < window {
+			return true
+		}
+
+		s.mutex.Lock()
+		fail := s.failWindows > 0
+		if fail {
+			s.failWindows--
+		} else {
+			s.events = append(s.events, pending...)
+			s.windows = append(s.windows, window)
+		}
+		delay := s.ackDelay
+		s.mutex.Unlock()
+
+		pending, received = nil, 0
+		if fail {
+			return false
+		}
+		time.Sleep(delay)
+		writeAck(conn, window/2)
+		return writeAck(conn, window) == nil
+	}
+
+	for {
+		code, err := readHeader(conn)
+		if err != nil {
+			return
+		}
+		switch code {
+		case codeWindowSize:
+			window, _ = readUint32(conn)
+		case codeJSONData:
+			event, err := readJSON(conn)
+			if err != nil || !onEvent(event) {
+				return
+			}
+		case codeCompressed:
+			size, _ := readUint32(conn)
+			data := make([]byte, size)
+			if _, err := io.ReadFull(conn, data); err != nil {
+				return
+			}
+			r, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return
+			}
+			frames, _ := ioutil.ReadAll(r)
+			in := bytes.NewReader(frames)
+			for in.Len() > 0 {
+				if code, err := readHeader(in); err != nil || code != codeJSONData {
+					return
+				}
+				event, err := readJSON(in)
+				if err != nil || !onEvent(event) {
+					return
+				}
+			}
+		default:
+			return
+		}
+	}
+}
+
+func readHeader(r io.Reader) (byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+	if header[0] != codeVersion {
+		return 0, errProtocol
+	}
+	return header[1], nil
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	var b [4]byte
+	_, err := io.ReadFull(r, b[:])
+	return binary.BigEndian.Uint32(b[:]), err
+}
+
+func readJSON(r io.Reader) (common.MapStr, error) {
+	readUint32(r) // sequence number
+	size, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	event := common.MapStr{}
+	return event, json.Unmarshal(data, &event)
+}
+
+func writeAck(conn net.Conn, seq uint32) error {
+	var buf bytes.Buffer
+	buf.Write([]byte{codeVersion, codeAck})
+	writeUint32(&buf, seq)
+	_, err := conn.Write(buf.Bytes())
+	return err
+}
+
+func init() {
+	defaultBackoff = time.Millisecond
+}
+
+// newTestOutput 返回初始化好的 logstash output，没有配置超时时间时等待 ACK 最多 5 秒
+func newTestOutput(t *testing.T, config outputs.MothershipConfig) *logstashOutput {
+	out := New().(*logstashOutput)
+	if config.Timeout == 0 {
+		config.Timeout = 5
+	}
+	outputtest.Init(t, out, config)
+	return out
+}
+
+func TestWindow(t *testing.T) {
+	var w window
+	w.init(10, 25)
+
+	w.tryGrow(5)
+	if w.get() != 10 {
+		t.Errorf("window must not grow on partial send, got %d", w.get())
+	}
+	w.tryGrow(10)
+	w.tryGrow(20)
+	if w.get() != 25 {
+		t.Errorf("window must grow up to the max, got %d", w.get())
+	}
+	for i := 0; i < 10; i++ {
+		w.shrink()
+	}
+	if w.get() != 1 {
+		t.Errorf("window must not shrink below 1, got %d", w.get())
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	for _, level := range []int{0, 3} {
+		server := newFakeServer(t, nil)
+
+		out := newTestOutput(t, outputs.MothershipConfig{
+			Hosts:            []string{server.Addr()},
+			CompressionLevel: outputtest.IntPtr(level),
+		})
+
+		if !outputtest.Publish(t, out, outputtest.Events(100)) {
+			t.Fatalf("compression %d: publish failed", level)
+		}
+		out.Close()
+		server.Close()
+
+		outputtest.CheckEvents(t, server.Events(), 100)
+
+		// 窗口从 10 开始，每次成功后加倍
+		expected := []uint32{10, 20, 40, 30}
+		if windows := server.Windows(); fmt.Sprint(windows) != fmt.Sprint(expected) {
+			t.Errorf("compression %d: expected windows %v, got %v", level, expected, windows)
+		}
+	}
+}
+
+func TestPublishEvents_Retry(t *testing.T) {
+	server := newFakeServer(t, nil)
+	defer server.Close()
+	server.failWindows = 2
+
+	out := newTestOutput(t, outputs.MothershipConfig{Hosts: []string{server.Addr()}})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(5)) {
+		t.Fatal("publish failed")
+	}
+	outputtest.CheckEvents(t, server.Events(), 5)
+}
+
+func TestPublishEvents_MaxRetries(t *testing.T) {
+	server := newFakeServer(t, nil)
+	defer server.Close()
+	server.failWindows = 100
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:      []string{server.Addr()},
+		MaxRetries: outputtest.IntPtr(2),
+	})
+	defer out.Close()
+
+	if outputtest.Publish(t, out, outputtest.Events(5)) {
+		t.Fatal("expected publish to fail")
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.failWindows != 97 {
+		t.Errorf("expected 3 attempts, got %d", 100-server.failWindows)
+	}
+}
+
+func TestPublishEvents_Failover(t *testing.T) {
+	down := newFakeServer(t, nil)
+	down.Close()
+	server := newFakeServer(t, nil)
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{Hosts: []string{down.Addr(), server.Addr()}})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(5)) {
+		t.Fatal("publish failed")
+	}
+	outputtest.CheckEvents(t, server.Events(), 5)
+}
+
+func TestPublishEvents_LoadBalance(t *testing.T) {
+	servers := []*fakeServer{newFakeServer(t, nil), newFakeServer(t, nil)}
+	for _, server := range servers {
+		defer server.Close()
+		server.ackDelay = 20 * time.Millisecond
+	}
+
+	loadBalance := true
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:       []string{servers[0].Addr(), servers[1].Addr()},
+		LoadBalance: &loadBalance,
+		BulkMaxSize: outputtest.IntPtr(1),
+	})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(10)) {
+		t.Fatal("publish failed")
+	}
+
+	n0, n1 := len(servers[0].Events()), len(servers[1].Events())
+	if n0+n1 != 10 || n0 == 0 || n1 == 0 {
+		t.Errorf("expected events to be distributed over both hosts, got %d and %d", n0, n1)
+	}
+}
+
+func TestPublishEvents_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logstash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, certFile := generateCert(t, dir)
+	server := newFakeServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts: []string{server.Addr()},
+		TLS:   &outputs.TLSConfig{CAs: []string{certFile}},
+	})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(5)) {
+		t.Fatal("publish failed")
+	}
+	outputtest.CheckEvents(t, server.Events(), 5)
+
+	// 不信任服务端证书时发送失败
+	untrusted := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:      []string{server.Addr()},
+		TLS:        &outputs.TLSConfig{},
+		MaxRetries: outputtest.IntPtr(0),
+	})
+	defer untrusted.Close()
+	if outputtest.Publish(t, untrusted, outputtest.Events(1)) {
+		t.Fatal("expected certificate verification to fail")
+	}
+}
+
+// generateCert 生成 127.0.0.1 的自签名证书，返回证书和证书文件的路径
+func generateCert(t *testing.T, dir string) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, certFile
+}
@@ -0,0 +1,41 @@
+package logstash
+
+const defaultStartWindowSize = 10
+
+// window 根据发送结果动态的调整每次发送的事件数量。
+// 从较小的窗口开始，每次整个窗口发送成功后窗口加倍，直到 bulk_max_size；
+// 发送失败时窗口减半，避免 logstash 处理不过来时一直超时
+type window struct {
+	windowSize    int
+	maxWindowSize int
+}
+
+func (w *window) init(start, max int) {
+	if start > max {
+		start = max
+	}
+	w.windowSize = start
+	w.maxWindowSize = max
+}
+
+func (w *window) get() int {
+	return w.windowSize
+}
+
+// tryGrow 在发送了完整的窗口之后增大窗口
+func (w *window) tryGrow(sent int) {
+	if sent < w.windowSize || w.windowSize >= w.maxWindowSize {
+		return
+	}
+	w.windowSize *= 2
+	if w.windowSize > w.maxWindowSize {
+		w.windowSize = w.maxWindowSize
+	}
+}
+
+func (w *window) shrink() {
+	w.windowSize /= 2
+	if w.windowSize < 1 {
+		w.windowSize = 1
+	}
+}
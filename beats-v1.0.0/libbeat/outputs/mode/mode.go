@@ -0,0 +1,323 @@
+package mode
+
+import (
+	"errors"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"sync"
+	"time"
+)
+
+// ProtocolClient 是到一个节点的连接，每个 client 只会被一个 worker 使用，不需要支持并发调用
+// ProtocolClient is the connection to a single host used by a ConnectionMode
+type ProtocolClient interface {
+	// Connect 建立连接，连接失败时返回 error
+	Connect() error
+
+	// IsConnected 返回连接是否可用，不可用时发送之前会先调用 Connect
+	IsConnected() bool
+
+	// Close 关闭连接，之后可以再次调用 Connect
+	Close() error
+
+	// PublishEvents 发送事件，返回需要重试的事件。返回 error 表示连接出现了问题，
+	// 连接会被关闭，需要重试的事件会交给其他的 worker 发送
+	PublishEvents(events []common.MapStr) ([]common.MapStr, error)
+}
+
+// ClientFactory 为一个节点创建 client，这里不应该建立连接
+type ClientFactory func(host string) (ProtocolClient, error)
+
+// Pinger 是 Connect 不会检查节点是否可用的 client (比如 http) 实现的接口，
+// TestConnection 使用 Ping 代替 Connect
+type Pinger interface {
+	// Ping 发送一个不会修改服务端状态的请求，检查节点是否可用以及认证是否正确
+	Ping() error
+}
+
+// RetryAfterer 是服务端可以指定重试前等待时间的 client (比如 http 的 Retry-After) 实现的接口，
+// 发送失败后 worker 优先按照它返回的时间等待
+type RetryAfterer interface {
+	// RetryAfter 返回上一次发送失败时服务端要求等待的时间，没有要求时返回 0
+	RetryAfter() time.Duration
+}
+
+// Settings 是所有连接模式共用的配置
+type Settings struct {
+	Name        string        // output 的名称，用于日志
+	BulkMaxSize int           // 一次交给 client 发送的最大事件数量
+	MaxRetries  int           // 一批事件最多重试的次数，小于 0 时一直重试，直到 output 被关闭
+	Backoff     time.Duration // 发送失败后 worker 等待的时间，连续失败时每次翻倍
+	MaxBackoff  time.Duration // 等待时间的上限
+}
+
+var (
+	errNoHosts = errors.New("no hosts configured")
+	errClosed  = errors.New("output closed")
+)
+
+// ConnectionMode 将事件按照 bulk_max_size 分批交给 worker 发送。
+//
+// failover 模式下每个 worker 拥有所有节点的 client，同一时间只使用其中一个，
+// 发送失败时切换到下一个节点；loadbalance 模式下每个节点都有自己的 worker，
+// 事件由空闲的 worker 发送，从而分散到所有可用的节点上。
+// 两种模式下没有发送成功的事件都会被重新放回队列，由其他的 worker (节点) 继续发送，
+// 发送失败的 worker 等待一段时间后才会继续接收事件。
+// 一批事件在所有节点上都失败过一次，或者节点返回了需要重试的事件时，才算作一次重试
+// ConnectionMode distributes batches of events to the workers of an output
+type ConnectionMode struct {
+	settings  Settings
+	hosts     int
+	hostNames []string
+	factory   ClientFactory
+
+	work chan *batch
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// batch 是交给 worker 发送的一批事件
+type batch struct {
+	signal   outputs.Signaler
+	events   []common.MapStr
+	attempts int
+	failed   map[int]bool // 这次重试中已经失败过的节点
+}
+
+// hostClient 是 worker 使用的一个 client 以及它对应的节点的序号
+type hostClient struct {
+	ProtocolClient
+	host int
+}
+
+// NewConnectionMode 为 hosts 创建 client 并启动 worker。
+// loadBalance 为 true 时为每个节点启动 workers 个 worker，否则一共启动 workers 个 worker
+// NewConnectionMode creates the clients for hosts and starts the workers
+func NewConnectionMode(
+	hosts []string,
+	loadBalance bool,
+	workers int,
+	factory ClientFactory,
+	settings Settings,
+) (*ConnectionMode, error) {
+	if len(hosts) == 0 {
+		return nil, errNoHosts
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	if settings.BulkMaxSize <= 0 {
+		settings.BulkMaxSize = 1
+	}
+	if settings.MaxBackoff < settings.Backoff {
+		settings.MaxBackoff = settings.Backoff
+	}
+
+	var groups [][]hostClient
+	for i := 0; i < workers; i++ {
+		clients := make([]hostClient, len(hosts))
+		for j, host := range hosts {
+			client, err := factory(host)
+			if err != nil {
+				return nil, err
+			}
+			clients[j] = hostClient{client, j}
+		}
+
+		if loadBalance {
+			for _, client := range clients {
+				groups = append(groups, []hostClient{client})
+			}
+		} else {
+			groups = append(groups, clients)
+		}
+	}
+
+	m := &ConnectionMode{
+		settings:  settings,
+		hosts:     len(hosts),
+		hostNames: hosts,
+		factory:   factory,
+		work:      make(chan *batch),
+		done:      make(chan struct{}),
+	}
+	for _, clients := range groups {
+		m.wg.Add(1)
+		go m.worker(clients)
+	}
+	return m, nil
+}
+
+// PublishEvents 将事件分批交给 worker 发送，所有批次发送完成后通知 signal。
+// 只有部分批次失败时，signal 支持的话只报告这些批次中没有发送成功的事件
+func (m *ConnectionMode) PublishEvents(signal outputs.Signaler, events []common.MapStr) error {
+	if len(events) == 0 {
+		outputs.SignalCompleted(signal)
+		return nil
+	}
+
+	bulkMaxSize := m.settings.BulkMaxSize
+	count := (len(events) + bulkMaxSize - 1) / bulkMaxSize
+	signal = outputs.NewSplitSignaler(signal, count)
+
+	for len(events) > 0 {
+		n := len(events)
+		if n > bulkMaxSize {
+			n = bulkMaxSize
+		}
+
+		select {
+		case m.work <- &batch{signal: signal, events: events[:n]}:
+		case <-m.done:
+			// 已经交给 worker 的批次由 worker 通知，这里只报告还没有交出去的事件
+			for ; len(events) > 0; events = events[n:] {
+				if n = len(events); n > bulkMaxSize {
+					n = bulkMaxSize
+				}
+				outputs.SignalFailedEvents(signal, events[:n])
+			}
+			return errClosed
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+// TestConnection 为每个节点创建一个新的 client 并连接，不会影响 worker 使用的连接
+func (m *ConnectionMode) TestConnection() []outputs.HostResult {
+	results := make([]outputs.HostResult, len(m.hostNames))
+	for i, host := range m.hostNames {
+		results[i] = outputs.HostResult{Host: host, Err: testClient(m.factory, host)}
+	}
+	return results
+}
+
+func testClient(factory ClientFactory, host string) error {
+	client, err := factory(host)
+	if err != nil {
+		return err
+	}
+	if pinger, ok := client.(Pinger); ok {
+		return pinger.Ping()
+	}
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+// Close 停止所有的 worker 并关闭它们的 client，队列中和等待重试的批次中没有发送成功的事件会被通知发送失败
+func (m *ConnectionMode) Close() error {
+	select {
+	case <-m.done:
+	default:
+		close(m.done)
+	}
+	m.wg.Wait()
+	return nil
+}
+
+// worker 使用 clients 中的一个发送事件，发送失败时切换到下一个，并等待一段时间后再继续
+func (m *ConnectionMode) worker(clients []hostClient) {
+	defer m.wg.Done()
+	defer func() {
+		for _, client := range clients {
+			if client.IsConnected() {
+				client.Close()
+			}
+		}
+	}()
+
+	name := m.settings.Name
+	active := 0
+	var backoff time.Duration
+	for {
+		var b *batch
+		select {
+		case <-m.done:
+			return
+		case b = <-m.work:
+		}
+
+		client := clients[active]
+		var err error
+		if !client.IsConnected() {
+			err = client.Connect()
+		}
+		rest := b.events
+		if err == nil {
+			rest, err = client.PublishEvents(b.events)
+		}
+		if err == nil && len(rest) == 0 {
+			backoff = 0
+			outputs.SignalCompleted(b.signal)
+			continue
+		}
+
+		if err != nil {
+			logp.Warn("%s: %v", name, err)
+			client.Close()
+			active = (active + 1) % len(clients)
+		}
+		m.countAttempt(b, client.host, rest, err)
+		b.events = rest
+
+		if m.settings.MaxRetries >= 0 && b.attempts > m.settings.MaxRetries {
+			logp.Err("%s: dropping %d event(s) after %d retries", name, len(b.events), m.settings.MaxRetries)
+			outputs.SignalFailedEvents(b.signal, b.events)
+		} else {
+			m.requeue(b)
+		}
+
+		if backoff == 0 {
+			backoff = m.settings.Backoff
+		} else if backoff *= 2; backoff > m.settings.MaxBackoff {
+			backoff = m.settings.MaxBackoff
+		}
+		wait := backoff
+		if r, ok := client.ProtocolClient.(RetryAfterer); ok && r.RetryAfter() > 0 {
+			wait = r.RetryAfter()
+		}
+		select {
+		case <-time.After(wait):
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// countAttempt 更新一批事件的重试次数。有事件发送成功时重新计算；
+// 连接出错时只有所有的节点都失败过之后才算作一次重试，这样一个节点不可用不会消耗掉重试次数
+func (m *ConnectionMode) countAttempt(b *batch, host int, rest []common.MapStr, err error) {
+	if len(rest) < len(b.events) {
+		b.attempts = 0
+		b.failed = nil
+	}
+	if err == nil {
+		b.attempts++
+		return
+	}
+
+	if b.failed == nil {
+		b.failed = map[int]bool{}
+	}
+	b.failed[host] = true
+	if len(b.failed) >= m.hosts {
+		b.attempts++
+		b.failed = nil
+	}
+}
+
+// requeue 将没有发送成功的事件放回队列，交给下一个空闲的 worker 发送
+func (m *ConnectionMode) requeue(b *batch) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		select {
+		case m.work <- b:
+		case <-m.done:
+			outputs.SignalFailedEvents(b.signal, b.events)
+		}
+	}()
+}
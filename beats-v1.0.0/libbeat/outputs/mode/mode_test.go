@@ -0,0 +1,331 @@
+package mode
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/internal/outputtest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer 记录收到的事件，down 时所有的连接和请求都会失败。
+// n 字段在 reject 中的事件不会被接收，作为需要重试的事件返回
+type fakeServer struct {
+	mutex    sync.Mutex
+	down     bool
+	delay    time.Duration
+	reject   map[int]bool
+	events   []common.MapStr
+	connects int
+}
+
+func (s *fakeServer) SetDown(down bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.down = down
+}
+
+func (s *fakeServer) Events() []common.MapStr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.events
+}
+
+func (s *fakeServer) Connects() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.connects
+}
+
+type fakeClient struct {
+	server    *fakeServer
+	connected bool
+}
+
+func (c *fakeClient) Connect() error {
+	c.server.mutex.Lock()
+	defer c.server.mutex.Unlock()
+	c.server.connects++
+	if c.server.down {
+		return errors.New("connection refused")
+	}
+	c.connected = true
+	return nil
+}
+
+func (c *fakeClient) IsConnected() bool { return c.connected }
+
+func (c *fakeClient) Close() error {
+	c.connected = false
+	return nil
+}
+
+func (c *fakeClient) PublishEvents(events []common.MapStr) ([]common.MapStr, error) {
+	c.server.mutex.Lock()
+	delay := c.server.delay
+	c.server.mutex.Unlock()
+	time.Sleep(delay)
+
+	c.server.mutex.Lock()
+	defer c.server.mutex.Unlock()
+	if c.server.down {
+		return events, errors.New("connection reset")
+	}
+	var rest []common.MapStr
+	for _, event := range events {
+		if c.server.reject[event["n"].(int)] {
+			rest = append(rest, event)
+		} else {
+			c.server.events = append(c.server.events, event)
+		}
+	}
+	return rest, nil
+}
+
+// newServers 创建 n 个 fake server，host 名称为 host0, host1, ...
+func newServers(n int) ([]string, []*fakeServer, ClientFactory) {
+	hosts := make([]string, n)
+	servers := make([]*fakeServer, n)
+	byHost := map[string]*fakeServer{}
+	for i := range servers {
+		hosts[i] = fmt.Sprintf("host%d", i)
+		servers[i] = &fakeServer{}
+		byHost[hosts[i]] = servers[i]
+	}
+	factory := func(host string) (ProtocolClient, error) {
+		return &fakeClient{server: byHost[host]}, nil
+	}
+	return hosts, servers, factory
+}
+
+func testSettings(maxRetries int) Settings {
+	return Settings{
+		Name:        "test",
+		BulkMaxSize: 2,
+		MaxRetries:  maxRetries,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+	}
+}
+
+func countEvents(servers []*fakeServer) int {
+	n := 0
+	for _, server := range servers {
+		n += len(server.Events())
+	}
+	return n
+}
+
+func TestFailOver(t *testing.T) {
+	hosts, servers, factory := newServers(2)
+	m, err := NewConnectionMode(hosts, false, 1, factory, testSettings(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// 第一个节点可用时只使用第一个节点
+	if !outputtest.Publish(t, m, outputtest.Events(5)) {
+		t.Fatal("publish failed")
+	}
+	if len(servers[0].Events()) != 5 || len(servers[1].Events()) != 0 {
+		t.Fatalf("expected all events on host0, got %d/%d", len(servers[0].Events()), len(servers[1].Events()))
+	}
+
+	// 第一个节点失败后切换到第二个节点
+	servers[0].SetDown(true)
+	if !outputtest.Publish(t, m, outputtest.Events(5)) {
+		t.Fatal("publish failed")
+	}
+	if len(servers[1].Events()) != 5 {
+		t.Fatalf("expected 5 events on host1, got %d", len(servers[1].Events()))
+	}
+
+	// 第二个节点失败，第一个节点恢复后切换回第一个节点
+	servers[0].SetDown(false)
+	servers[1].SetDown(true)
+	if !outputtest.Publish(t, m, outputtest.Events(5)) {
+		t.Fatal("publish failed")
+	}
+	if len(servers[0].Events()) != 10 {
+		t.Fatalf("expected 10 events on host0, got %d", len(servers[0].Events()))
+	}
+}
+
+func TestLoadBalance(t *testing.T) {
+	hosts, servers, factory := newServers(3)
+	for _, server := range servers {
+		server.delay = 5 * time.Millisecond
+	}
+	m, err := NewConnectionMode(hosts, true, 1, factory, testSettings(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if !outputtest.Publish(t, m, outputtest.Events(30)) {
+		t.Fatal("publish failed")
+	}
+	if n := countEvents(servers); n != 30 {
+		t.Fatalf("expected 30 events, got %d", n)
+	}
+	for i, server := range servers {
+		if len(server.Events()) == 0 {
+			t.Errorf("host%d received no events", i)
+		}
+	}
+}
+
+func TestLoadBalance_Requeue(t *testing.T) {
+	hosts, servers, factory := newServers(3)
+	servers[1].SetDown(true)
+	m, err := NewConnectionMode(hosts, true, 1, factory, testSettings(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// 发送到 host1 的事件被重新放回队列，由其他节点发送
+	if !outputtest.Publish(t, m, outputtest.Events(20)) {
+		t.Fatal("publish failed")
+	}
+	if n := countEvents(servers); n != 20 {
+		t.Fatalf("expected 20 events, got %d", n)
+	}
+	if len(servers[1].Events()) != 0 {
+		t.Errorf("host1 is down but received events")
+	}
+
+	// 所有节点依次失败并恢复，事件不会丢失
+	for i := range servers {
+		servers[i].SetDown(true)
+		servers[(i+1)%len(servers)].SetDown(false)
+		if !outputtest.Publish(t, m, outputtest.Events(10)) {
+			t.Fatal("publish failed")
+		}
+	}
+	if n := countEvents(servers); n != 50 {
+		t.Fatalf("expected 50 events, got %d", n)
+	}
+}
+
+func TestLoadBalance_HostDownKeepsRetries(t *testing.T) {
+	hosts, servers, factory := newServers(2)
+	servers[0].SetDown(true)
+	m, err := NewConnectionMode(hosts, true, 1, factory, testSettings(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// 一个节点不可用不算作重试，事件由另一个节点发送
+	for i := 0; i < 10; i++ {
+		if !outputtest.Publish(t, m, outputtest.Events(1)) {
+			t.Fatal("publish failed")
+		}
+	}
+	if n := len(servers[1].Events()); n != 10 {
+		t.Fatalf("expected 10 events on host1, got %d", n)
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	hosts, servers, factory := newServers(2)
+	servers[0].SetDown(true)
+	servers[1].SetDown(true)
+	m, err := NewConnectionMode(hosts, false, 1, factory, testSettings(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if outputtest.Publish(t, m, outputtest.Events(1)) {
+		t.Fatal("expected publish to fail")
+	}
+	// 每次重试都会尝试所有的节点
+	if n := servers[0].Connects() + servers[1].Connects(); n != 6 {
+		t.Errorf("expected 6 connection attempts, got %d", n)
+	}
+}
+
+func TestMaxRetries_ReportsOnlyFailedEvents(t *testing.T) {
+	hosts, servers, factory := newServers(1)
+	servers[0].reject = map[int]bool{2: true, 3: true}
+	m, err := NewConnectionMode(hosts, false, 2, factory, testSettings(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// 两个批次中只有第二个失败，已经发送成功的第一个批次不能被报告为失败
+	signal := outputtest.NewEventsSignal()
+	if err := m.PublishEvents(signal, outputtest.Events(4)); err != nil {
+		t.Fatal(err)
+	}
+	if signal.Wait() {
+		t.Fatal("expected publish to fail")
+	}
+	if len(signal.Events) != 2 || signal.Events[0]["n"] != 2 || signal.Events[1]["n"] != 3 {
+		t.Errorf("expected events 2 and 3 to be reported as failed, got %v", signal.Events)
+	}
+	if n := len(servers[0].Events()); n != 2 {
+		t.Errorf("expected 2 events on host0, got %d", n)
+	}
+}
+
+func TestClose(t *testing.T) {
+	hosts, servers, factory := newServers(1)
+	servers[0].SetDown(true)
+	m, err := NewConnectionMode(hosts, false, 2, factory, testSettings(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signal := outputs.NewSyncSignal()
+	if err := m.PublishEvents(signal, outputtest.Events(5)); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	if signal.Wait() {
+		t.Fatal("expected publish to fail after close")
+	}
+
+	signal = outputs.NewSyncSignal()
+	if err := m.PublishEvents(signal, outputtest.Events(1)); err == nil {
+		t.Fatal("expected error after close")
+	}
+	if signal.Wait() {
+		t.Fatal("expected publish to fail after close")
+	}
+}
+
+func TestNoHosts(t *testing.T) {
+	_, _, factory := newServers(0)
+	if _, err := NewConnectionMode(nil, false, 1, factory, testSettings(0)); err == nil {
+		t.Fatal("expected error without hosts")
+	}
+}
+
+func TestTestConnection(t *testing.T) {
+	hosts, servers, factory := newServers(2)
+	m, err := NewConnectionMode(hosts, false, 1, factory, testSettings(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	servers[1].SetDown(true)
+
+	results := m.TestConnection()
+	if len(results) != 2 || results[0].Host != "host0" || results[1].Host != "host1" {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if results[0].Err != nil {
+		t.Errorf("host0: %v", results[0].Err)
+	}
+	if results[1].Err == nil {
+		t.Error("expected host1 to fail")
+	}
+}
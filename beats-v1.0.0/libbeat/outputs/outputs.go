@@ -1,8 +1,16 @@
 package outputs
 
+import (
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs/codec"
+	"sort"
+)
+
 // 定义日志输入时需要的一些基础信息
 type MothershipConfig struct {
-	SaveTopology      bool // 是否保存拓扑结构
+	Enabled           *bool // 是否启用该 output，默认启用
+	SaveTopology      bool  `yaml:"save_topology"` // 是否保存拓扑结构
 	Host              string
 	Port              int
 	Hosts             []string
@@ -13,16 +21,159 @@ type MothershipConfig struct {
 	Index             string
 	Path              string
 	Db                int
-	DbTopology        int
+	DbTopology        int `yaml:"db_topology"` // redis 中保存拓扑结构的数据库
 	Timeout           int
-	ReconnectInterval int
+	ReconnectInterval int `yaml:"reconnect_interval"`
 	Filename          string
-	RotateEveryKb     int
-	NumberOfFiles     int
+	RotateEveryKb     int  `yaml:"rotate_every_kb"`
+	NumberOfFiles     int  `yaml:"number_of_files"`
+	CompressRotated   bool `yaml:"compress_rotated"`
 	DataType          string
 	FlushInterval     *int
 	BulkMaxSize       *int `yaml:"bulk_max_size"`
-	MaxRetries        *int
+	MaxRetries        *int `yaml:"max_retries"`
 	Pretty            *bool
+	Codec             codec.Config
 	Worker            int
+	CompressionLevel  *int `yaml:"compression_level"`
+	Compression       string
+	Topic             string
+	TopicField        string `yaml:"topic_field"`
+	Partition         string
+	HashField         string            `yaml:"hash_field"`
+	KeyField          string            `yaml:"key_field"`
+	RequiredAcks      *int              `yaml:"required_acks"`
+	URL               string            `yaml:"url"`
+	BatchFormat       string            `yaml:"batch_format"`
+	BearerToken       string            `yaml:"bearer_token"`
+	Headers           map[string]string `yaml:"headers"`
+	TLS               *TLSConfig
+	Template          Template
+}
+
+// Template 是 output 启动后需要加载的模板，比如 elasticsearch 的索引模板
+// Template configures the index template loaded by an output
+type Template struct {
+	Name      string // 模板的名称
+	Path      string // 模板文件的路径
+	Overwrite bool   // 模板已经存在时是否覆盖
+}
+
+// Outputer 是所有 output 插件都需要实现的接口
+// Outputer is the interface every output plugin implements
+type Outputer interface {
+	// Init 根据配置初始化 output。Init 不应该因为连接不上服务端而失败，连接应该在发送的时候建立
+	// Init initializes the output from its config
+	Init(beatName string, config *MothershipConfig, topologyExpire int) error
+
+	// 发送一批事件。所有事件发送成功后调用 signal.Completed()，否则调用 signal.Failed()，
+	// 不论是否返回错误，signal 都只会被调用一次
+	// PublishEvents sends a batch of events. The signaler is notified exactly
+	// once when the batch has been published or dropped
+	PublishEvents(signal Signaler, events []common.MapStr) error
+
+	// Close 关闭 output，释放所有的连接
+	Close() error
+}
+
+// TopologyOutputer 是可以保存拓扑结构 (每个 shipper 的名称和它的 IP 地址) 的 output 需要实现的接口。
+// 配置了 save_topology 的 output 会被 publisher 用来定期发布自己的 IP 地址，
+// 并根据其他 shipper 发布的地址给事件中的 IP 加上对应的 shipper 名称
+// TopologyOutputer is implemented by outputs that can store the shipper topology
+type TopologyOutputer interface {
+	// PublishIPs 保存 shipper 的名称和它的 IP 地址，并刷新本地缓存的拓扑结构
+	PublishIPs(name string, localAddrs []string) error
+
+	// GetNameByIP 返回 IP 地址所属的 shipper 的名称，不知道时返回空字符串
+	GetNameByIP(ip string) string
+}
+
+// ConnectionTester 是可以检查到服务端的连接的 output 实现的接口，"test output" 子命令会使用它
+// ConnectionTester is implemented by outputs that can check the connection to their hosts
+type ConnectionTester interface {
+	// TestConnection 使用发送事件时同样的 client (包括 TLS、认证和协议) 连接每个节点，
+	// 按配置的顺序返回每个节点的结果，不会发送任何事件
+	TestConnection() []HostResult
+}
+
+// HostResult 是连接一个节点的结果，Err 为 nil 表示连接成功
+type HostResult struct {
+	Host string
+	Err  error
+}
+
+// OutputBuilder 创建一个新的 output 插件实例
+type OutputBuilder func() Outputer
+
+// OutputPlugin 是一个初始化好的 output
+type OutputPlugin struct {
+	Name   string
+	Config MothershipConfig
+	Output Outputer
+}
+
+// 所有注册了的 output 插件，key 为配置中 output 的名称
+var outputsPlugins = make(map[string]OutputBuilder)
+
+// RegisterOutputPlugin 注册一个 output 插件，output 插件应该在 init 函数中注册自己
+// RegisterOutputPlugin registers an output plugin under the name used in the
+// output section of the config file
+func RegisterOutputPlugin(name string, builder OutputBuilder) {
+	if _, exists := outputsPlugins[name]; exists {
+		panic(fmt.Sprintf("output plugin '%s' registered twice", name))
+	}
+	outputsPlugins[name] = builder
+}
+
+// FindOutputPlugin returns the builder of the named output plugin or nil
+func FindOutputPlugin(name string) OutputBuilder {
+	return outputsPlugins[name]
+}
+
+// IsEnabled 没有设置 enabled 时 output 默认是启用的
+func (config *MothershipConfig) IsEnabled() bool {
+	return config.Enabled == nil || *config.Enabled
+}
+
+// 根据配置初始化所有启用了的 output，output 按名称排序
+// InitOutputs initializes all enabled outputs of the config
+func InitOutputs(
+	beatName string,
+	configs map[string]MothershipConfig,
+	topologyExpire int,
+) ([]OutputPlugin, error) {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var plugins []OutputPlugin
+	for _, name := range names {
+		config := configs[name]
+		if !config.IsEnabled() {
+			continue
+		}
+
+		builder := FindOutputPlugin(name)
+		if builder == nil {
+			closeOutputs(plugins)
+			return nil, fmt.Errorf("unknown output type: %s", name)
+		}
+
+		output := builder()
+		if err := output.Init(beatName, &config, topologyExpire); err != nil {
+			closeOutputs(plugins)
+			return nil, fmt.Errorf("failed to initialize %s output: %v", name, err)
+		}
+
+		plugins = append(plugins, OutputPlugin{Name: name, Config: config, Output: output})
+	}
+	return plugins, nil
+}
+
+func closeOutputs(plugins []OutputPlugin) {
+	for _, plugin := range plugins {
+		plugin.Output.Close()
+	}
 }
@@ -0,0 +1,129 @@
+package outputs
+
+import (
+	"errors"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"testing"
+)
+
+type testOutput struct {
+	beatName string
+	config   *MothershipConfig
+	closed   bool
+	initErr  error
+}
+
+func (t *testOutput) Init(beatName string, config *MothershipConfig, topologyExpire int) error {
+	t.beatName = beatName
+	t.config = config
+	return t.initErr
+}
+
+func (t *testOutput) PublishEvents(signal Signaler, events []common.MapStr) error {
+	SignalCompleted(signal)
+	return nil
+}
+
+func (t *testOutput) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestInitOutputs(t *testing.T) {
+	var created []*testOutput
+	RegisterOutputPlugin("test_init", func() Outputer {
+		out := &testOutput{}
+		created = append(created, out)
+		return out
+	})
+	defer delete(outputsPlugins, "test_init")
+
+	disabled := false
+	plugins, err := InitOutputs("testbeat", map[string]MothershipConfig{
+		"test_init": {Index: "test"},
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugins) != 1 || plugins[0].Name != "test_init" || created[0].beatName != "testbeat" {
+		t.Fatalf("unexpected outputs: %v", plugins)
+	}
+	if created[0].config.Index != "test" {
+		t.Errorf("config not passed to output: %v", created[0].config)
+	}
+
+	plugins, err = InitOutputs("testbeat", map[string]MothershipConfig{
+		"test_init": {Enabled: &disabled},
+	}, 0)
+	if err != nil || len(plugins) != 0 {
+		t.Errorf("disabled output initialized: %v, %v", plugins, err)
+	}
+}
+
+func TestInitOutputs_Errors(t *testing.T) {
+	var created []*testOutput
+	RegisterOutputPlugin("test_a", func() Outputer {
+		out := &testOutput{}
+		created = append(created, out)
+		return out
+	})
+	RegisterOutputPlugin("test_b", func() Outputer {
+		return &testOutput{initErr: errors.New("boom")}
+	})
+	defer delete(outputsPlugins, "test_a")
+	defer delete(outputsPlugins, "test_b")
+
+	_, err := InitOutputs("testbeat", map[string]MothershipConfig{
+		"test_a": {},
+		"test_b": {},
+	}, 0)
+	if err == nil {
+		t.Fatal("expected init error")
+	}
+	if !created[0].closed {
+		t.Error("already initialized outputs not closed on error")
+	}
+
+	_, err = InitOutputs("testbeat", map[string]MothershipConfig{"unknown": {}}, 0)
+	if err == nil {
+		t.Error("expected error for unknown output")
+	}
+}
+
+func TestSplitSignal(t *testing.T) {
+	sig := NewSyncSignal()
+	split := NewSplitSignaler(sig, 3)
+	split.Completed()
+	split.Failed()
+	split.Completed()
+	if sig.Wait() {
+		t.Error("expected failed signal")
+	}
+
+	sig = NewSyncSignal()
+	split = NewSplitSignaler(sig, 2)
+	split.Completed()
+	split.Completed()
+	if !sig.Wait() {
+		t.Error("expected completed signal")
+	}
+	// 只有通过 FailedEvents 报告的事件被转发
+	events := &failedEventsSignal{SyncSignal: NewSyncSignal()}
+	split = NewSplitSignaler(events, 3)
+	split.Completed()
+	SignalFailedEvents(split, []common.MapStr{{"n": 1}})
+	SignalFailedEvents(split, []common.MapStr{{"n": 2}})
+	if events.Wait() || len(events.events) != 2 {
+		t.Errorf("expected 2 failed events, got %v", events.events)
+	}
+}
+
+type failedEventsSignal struct {
+	*SyncSignal
+	events []common.MapStr
+}
+
+func (s *failedEventsSignal) FailedEvents(events []common.MapStr) {
+	s.events = events
+	s.Failed()
+}
@@ -0,0 +1,173 @@
+package redis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisError 是 redis 返回的错误 (以 '-' 开头的回复)，表示命令本身有问题，重试也不会成功
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+var errProtocol = errors.New("redis: protocol error")
+
+// conn 是使用 RESP 协议的 redis 连接，多个命令可以一次写入，然后依次读取回复 (pipeline)
+// conn is a connection to a redis server speaking RESP
+type conn struct {
+	addr    string
+	timeout time.Duration
+
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// dial 建立连接，设置了密码时进行认证，并选择数据库
+func dial(addr string, tlsConfig *tls.Config, timeout time.Duration, password string, db int) (*conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	var nc net.Conn
+	var err error
+	if tlsConfig != nil {
+		nc, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		nc, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{
+		addr:    addr,
+		timeout: timeout,
+		conn:    nc,
+		r:       bufio.NewReader(nc),
+		w:       bufio.NewWriter(nc),
+	}
+
+	if password != "" {
+		if _, err := c.Do("AUTH", password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if db != 0 {
+		if _, err := c.Do("SELECT", strconv.Itoa(db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Close 关闭到 redis 的 TCP (或 TLS) 连接
+func (c *conn) Close() error {
+	return c.conn.Close()
+}
+
+// Do 发送一个命令并读取回复
+func (c *conn) Do(args ...string) (interface{}, error) {
+	c.Send(args...)
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+	reply, err := c.Receive()
+	if err != nil {
+		return nil, err
+	}
+	if rerr, ok := reply.(redisError); ok {
+		return nil, rerr
+	}
+	return reply, nil
+}
+
+// Send 将命令写入缓冲区，调用 Flush 之后才会真正发送
+func (c *conn) Send(args ...string) {
+	c.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		c.w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		c.w.WriteString(arg)
+		c.w.WriteString("\r\n")
+	}
+}
+
+// Flush 发送缓冲区中的所有命令
+func (c *conn) Flush() error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// Receive 读取一个回复。redis 返回的错误以 redisError 类型的回复返回，而不是 error，
+// 这样 pipeline 中一个命令失败不会影响其他命令的结果
+func (c *conn) Receive() (interface{}, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// readReply 解析一个 RESP 回复:
+// '+' 简单字符串, '-' 错误, ':' 整数, '$' 字符串 (-1 为 nil), '*' 数组
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%v: unexpected reply '%s'", errProtocol, line)
+}
@@ -0,0 +1,316 @@
+package redis
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/codec"
+	"github.com/ssp4599815/beat/libbeat/outputs/mode"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPort        = 6379
+	defaultBulkMaxSize = 2048
+	defaultMaxRetries  = 3
+	defaultTimeout     = 5 * time.Second
+	maxBackoff         = 60 * time.Second
+)
+
+// defaultBackoff 是连接失败或者 pipeline 中断后 worker 第一次等待的时间 (reconnect_interval 的默认值)，
+// 测试中会改小
+var defaultBackoff = 1 * time.Second
+
+func init() {
+	outputs.RegisterOutputPlugin("redis", New)
+}
+
+// redisOutput 使用 codec 编码 (默认为 json) 后写入 redis 的 list (RPUSH) 或者 channel (PUBLISH)。
+// key 默认为 index (没有配置时为 beat 的名称)，配置了 key_field 时取自事件中的字段。
+// 一批事件的所有命令通过 pipeline 一次发送；loadbalance 为 true 时事件分散的发送到所有节点，
+// 否则同一时间只使用一个节点，连接失败时切换到下一个节点
+// redisOutput publishes events to redis lists or channels
+type redisOutput struct {
+	dataType string
+	key      string
+	keyField string
+	codec    codec.Codec
+
+	mode     *mode.ConnectionMode
+	topology *topology // 配置了 save_topology 时在第一个节点上保存拓扑结构
+}
+
+// New 创建一个新的 redis output，需要调用 Init 进行初始化
+func New() outputs.Outputer {
+	return &redisOutput{}
+}
+
+// Init 检查配置、加载 codec 并启动 worker，到 redis 的连接和认证在第一次发送事件的时候进行
+func (out *redisOutput) Init(beatName string, config *outputs.MothershipConfig, topologyExpire int) error {
+	configHosts := config.Hosts
+	if len(configHosts) == 0 && config.Host != "" {
+		configHosts = []string{config.Host}
+	}
+	if len(configHosts) == 0 {
+		return errors.New("no hosts configured")
+	}
+	port := defaultPort
+	if config.Port > 0 {
+		port = config.Port
+	}
+	hosts := make([]string, len(configHosts))
+	for i, host := range configHosts {
+		hosts[i] = host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			hosts[i] = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+	}
+
+	switch config.DataType {
+	case "", "list":
+		out.dataType = "list"
+	case "channel":
+		out.dataType = "channel"
+	default:
+		return fmt.Errorf("unknown datatype '%s', must be one of list, channel", config.DataType)
+	}
+
+	tlsConfig, err := outputs.LoadTLSConfig(config.TLS)
+	if err != nil {
+		return err
+	}
+
+	encoder, err := outputs.LoadCodec(config)
+	if err != nil {
+		return err
+	}
+
+	out.codec = encoder
+	out.key = config.Index
+	if out.key == "" {
+		out.key = beatName
+	}
+	out.keyField = config.KeyField
+
+	timeout := defaultTimeout
+	if config.Timeout > 0 {
+		timeout = time.Duration(config.Timeout) * time.Second
+	}
+
+	settings := mode.Settings{
+		Name:        "redis",
+		BulkMaxSize: defaultBulkMaxSize,
+		MaxRetries:  defaultMaxRetries,
+		Backoff:     defaultBackoff,
+		MaxBackoff:  maxBackoff,
+	}
+	if config.BulkMaxSize != nil && *config.BulkMaxSize > 0 {
+		settings.BulkMaxSize = *config.BulkMaxSize
+	}
+	if config.MaxRetries != nil {
+		settings.MaxRetries = *config.MaxRetries
+	}
+	if config.ReconnectInterval > 0 {
+		settings.Backoff = time.Duration(config.ReconnectInterval) * time.Second
+	}
+
+	factory := func(addr string) (mode.ProtocolClient, error) {
+		return &client{
+			out:      out,
+			addr:     addr,
+			tls:      tlsConfig,
+			timeout:  timeout,
+			password: config.Password,
+			db:       config.Db,
+		}, nil
+	}
+	if config.SaveTopology {
+		out.topology = &topology{
+			addr:     hosts[0],
+			tls:      tlsConfig,
+			timeout:  timeout,
+			password: config.Password,
+			db:       defaultDbTopology,
+			expire:   defaultTopologyExpire,
+		}
+		if config.DbTopology > 0 {
+			out.topology.db = config.DbTopology
+		}
+		if topologyExpire > 0 {
+			out.topology.expire = topologyExpire
+		}
+	}
+
+	loadBalance := config.LoadBalance != nil && *config.LoadBalance
+	out.mode, err = mode.NewConnectionMode(hosts, loadBalance, config.Worker, factory, settings)
+	if err != nil {
+		return err
+	}
+
+	logp.Info("redis: output to %v, %s '%s', key_field '%s', loadbalance %v",
+		hosts, out.dataType, out.key, out.keyField, loadBalance)
+	return nil
+}
+
+// PublishEvents 将事件交给 worker，一批事件的 RPUSH 或 PUBLISH 命令在一个 pipeline 中发送，
+// 所有事件都写入 redis 后通知 signal
+func (out *redisOutput) PublishEvents(signal outputs.Signaler, events []common.MapStr) error {
+	return out.mode.PublishEvents(signal, events)
+}
+
+// PublishIPs 保存 shipper 的地址，没有配置 save_topology 时返回错误
+func (out *redisOutput) PublishIPs(name string, localAddrs []string) error {
+	if out.topology == nil {
+		return errors.New("save_topology is not enabled")
+	}
+	return out.topology.PublishIPs(name, localAddrs)
+}
+
+// GetNameByIP 返回 ip 所属的 shipper 的名称
+func (out *redisOutput) GetNameByIP(ip string) string {
+	if out.topology == nil {
+		return ""
+	}
+	return out.topology.GetNameByIP(ip)
+}
+
+// TestConnection 使用发送事件时同样的 client 连接每个节点，包括认证和选择数据库
+func (out *redisOutput) TestConnection() []outputs.HostResult {
+	return out.mode.TestConnection()
+}
+
+// Close 停止所有的 worker 并关闭到 redis 和拓扑数据库的连接，还没有写入的事件会被当做发送失败
+func (out *redisOutput) Close() error {
+	if out.topology != nil {
+		out.topology.Close()
+	}
+	if out.mode == nil {
+		return nil
+	}
+	return out.mode.Close()
+}
+
+// client 是到一个 redis 节点的连接
+type client struct {
+	out      *redisOutput
+	addr     string
+	tls      *tls.Config
+	timeout  time.Duration
+	password string
+	db       int
+
+	conn *conn
+}
+
+// Connect 建立连接，设置了密码时进行认证，并选择数据库
+func (c *client) Connect() error {
+	conn, err := dial(c.addr, c.tls, c.timeout, c.password, c.db)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", c.addr, err)
+	}
+	c.conn = conn
+	return nil
+}
+
+// IsConnected 返回是否已经连接并且完成了认证和选择数据库
+func (c *client) IsConnected() bool {
+	return c.conn != nil
+}
+
+// Close 关闭这个 worker 的连接，下次发送之前会重新连接
+func (c *client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// PublishEvents 使用 pipeline 发送所有事件，返回没有被确认的事件
+func (c *client) PublishEvents(events []common.MapStr) ([]common.MapStr, error) {
+	return c.out.send(c.conn, events)
+}
+
+// command 是 pipeline 中的一个命令以及它包含的事件
+type command struct {
+	args   []string
+	events []common.MapStr
+}
+
+// send 使用 pipeline 发送所有事件，返回没有被确认的事件。
+// list 类型时同一个 key 的事件合并为一个 RPUSH 命令；channel 类型时每个事件一个 PUBLISH 命令。
+// redis 返回错误的命令重试也不会成功，这些事件会被丢弃
+func (out *redisOutput) send(c *conn, events []common.MapStr) ([]common.MapStr, error) {
+	commands := out.commands(events)
+
+	for _, cmd := range commands {
+		c.Send(cmd.args...)
+	}
+	if err := c.Flush(); err != nil {
+		return events, err
+	}
+
+	for i, cmd := range commands {
+		reply, err := c.Receive()
+		if err != nil {
+			var pending []common.MapStr
+			for _, cmd := range commands[i:] {
+				pending = append(pending, cmd.events...)
+			}
+			return pending, err
+		}
+		if rerr, ok := reply.(redisError); ok {
+			logp.Err("redis: dropping %d event(s) rejected by %s %s: %v",
+				len(cmd.events), cmd.args[0], cmd.args[1], rerr)
+		}
+	}
+	return nil, nil
+}
+
+func (out *redisOutput) commands(events []common.MapStr) []*command {
+	var commands []*command
+	lists := map[string]*command{}
+
+	for _, event := range events {
+		data, err := out.codec.Encode(event)
+		if err != nil {
+			logp.Err("redis: failed to encode event, dropping it: %v", err)
+			continue
+		}
+		key := out.selectKey(event)
+
+		if out.dataType == "channel" {
+			commands = append(commands, &command{
+				args:   []string{"PUBLISH", key, string(data)},
+				events: []common.MapStr{event},
+			})
+			continue
+		}
+
+		cmd, ok := lists[key]
+		if !ok {
+			cmd = &command{args: []string{"RPUSH", key}}
+			lists[key] = cmd
+			commands = append(commands, cmd)
+		}
+		cmd.args = append(cmd.args, string(data))
+		cmd.events = append(cmd.events, event)
+	}
+	return commands
+}
+
+// selectKey 返回事件的 key，配置了 key_field 并且事件中有这个字段时使用字段的值
+func (out *redisOutput) selectKey(event common.MapStr) string {
+	if out.keyField != "" {
+		if key, ok := event[out.keyField].(string); ok && key != "" {
+			return key
+		}
+	}
+	return out.key
+}
@@ -0,0 +1,431 @@
+package redis
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"github.com/ssp4599815/beat/libbeat/outputs/internal/outputtest"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer 是一个只支持 AUTH、SELECT、RPUSH、PUBLISH 以及保存拓扑结构使用的命令的 redis 服务端
+type fakeServer struct {
+	ln       net.Listener
+	password string
+
+	mutex     sync.Mutex
+	lists     map[string][]string
+	channels  map[string][]string
+	hashes    map[string]map[string]string
+	expires   map[string]string
+	dbs       []string
+	flushes   []int // 每次读取到的命令数量，用来检查 pipeline
+	failConns int   // 前 failConns 个连接在收到第一个命令后直接关闭
+	scans     int   // 收到的 SCAN 命令的数量
+}
+
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{
+		ln:       ln,
+		password: password,
+		lists:    map[string][]string{},
+		channels: map[string][]string{},
+		hashes:   map[string]map[string]string{},
+		expires:  map[string]string{},
+	}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) Addr() string { return s.ln.Addr().String() }
+
+func (s *fakeServer) Close() { s.ln.Close() }
+
+func (s *fakeServer) List(key string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lists[key]
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *fakeServer) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	authed := s.password == ""
+
+	s.mutex.Lock()
+	fail := s.failConns > 0
+	if fail {
+		s.failConns--
+	}
+	s.mutex.Unlock()
+
+	for {
+		// 读取缓冲区中所有的命令，再一起回复
+		var replies []string
+		for {
+			reply, err := readReply(r)
+			if err != nil || fail {
+				return
+			}
+			args, ok := reply.([]interface{})
+			if !ok || len(args) == 0 {
+				return
+			}
+			replies = append(replies, s.execute(args, &authed))
+			if r.Buffered() == 0 {
+				break
+			}
+		}
+
+		s.mutex.Lock()
+		s.flushes = append(s.flushes, len(replies))
+		s.mutex.Unlock()
+
+		if _, err := c.Write([]byte(strings.Join(replies, ""))); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) execute(args []interface{}, authed *bool) string {
+	cmd := strings.ToUpper(args[0].(string))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if cmd == "AUTH" {
+		if len(args) != 2 || args[1] != s.password {
+			return "-ERR invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+	if !*authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+
+	switch cmd {
+	case "SELECT":
+		s.dbs = append(s.dbs, args[1].(string))
+		return "+OK\r\n"
+	case "RPUSH":
+		key := args[1].(string)
+		if key == "wrongtype" {
+			return "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+		}
+		for _, value := range args[2:] {
+			s.lists[key] = append(s.lists[key], value.(string))
+		}
+		return fmt.Sprintf(":%d\r\n", len(s.lists[key]))
+	case "PUBLISH":
+		key := args[1].(string)
+		s.channels[key] = append(s.channels[key], args[2].(string))
+		return ":1\r\n"
+	case "HSET":
+		key := args[1].(string)
+		if s.hashes[key] == nil {
+			s.hashes[key] = map[string]string{}
+		}
+		s.hashes[key][args[2].(string)] = args[3].(string)
+		return ":1\r\n"
+	case "HGET":
+		value, ok := s.hashes[args[1].(string)][args[2].(string)]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "EXPIRE":
+		s.expires[args[1].(string)] = args[2].(string)
+		return ":1\r\n"
+	case "SCAN":
+		// 每次只返回一个 key，cursor 是下一个 key 的序号，这样多个 key 需要多次 SCAN
+		var keys []string
+		for key := range s.hashes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		i, _ := strconv.Atoi(args[1].(string))
+		s.scans++
+		if i >= len(keys) {
+			return "*2\r\n$1\r\n0\r\n*0\r\n"
+		}
+		next := strconv.Itoa(i + 1)
+		if i+1 == len(keys) {
+			next = "0"
+		}
+		return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*1\r\n$%d\r\n%s\r\n", len(next), next, len(keys[i]), keys[i])
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func init() {
+	defaultBackoff = time.Millisecond
+}
+
+// newTestOutput 返回初始化好的 redis output，没有配置 index 时 key 为 testbeat
+func newTestOutput(t *testing.T, config outputs.MothershipConfig) *redisOutput {
+	out := New().(*redisOutput)
+	outputtest.Init(t, out, config)
+	return out
+}
+
+func checkList(t *testing.T, values []string, n int) {
+	if len(values) != n {
+		t.Fatalf("expected %d values, got %d", n, len(values))
+	}
+	for i, value := range values {
+		event := common.MapStr{}
+		if err := json.Unmarshal([]byte(value), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event["n"] != float64(i) {
+			t.Fatalf("value %d out of order: %v", i, value)
+		}
+	}
+}
+
+func TestPublishEvents_List(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:    []string{server.Addr()},
+		Password: "secret",
+		Db:       3,
+	})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(10)) {
+		t.Fatal("publish failed")
+	}
+	checkList(t, server.List("testbeat"), 10)
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.dbs) != 1 || server.dbs[0] != "3" {
+		t.Errorf("expected SELECT 3, got %v", server.dbs)
+	}
+}
+
+func TestPublishEvents_KeyFieldPipeline(t *testing.T) {
+	server := newFakeServer(t, "")
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:    []string{server.Addr()},
+		Index:    "default",
+		KeyField: "type",
+		DataType: "channel",
+	})
+	defer out.Close()
+
+	events := []common.MapStr{
+		{"type": "nginx", "message": "a"},
+		{"message": "b"},
+		{"type": "nginx", "message": "c"},
+	}
+	if !outputtest.Publish(t, out, events) {
+		t.Fatal("publish failed")
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if len(server.channels["nginx"]) != 2 || len(server.channels["default"]) != 1 {
+		t.Errorf("unexpected channels: %v", server.channels)
+	}
+	// 所有的 PUBLISH 命令在一次请求中发送
+	if len(server.flushes) != 1 || server.flushes[0] != 3 {
+		t.Errorf("expected 3 pipelined commands, got %v", server.flushes)
+	}
+}
+
+func TestPublishEvents_Reconnect(t *testing.T) {
+	server := newFakeServer(t, "")
+	defer server.Close()
+	server.mutex.Lock()
+	server.failConns = 2
+	server.mutex.Unlock()
+
+	out := newTestOutput(t, outputs.MothershipConfig{Hosts: []string{server.Addr()}})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(5)) {
+		t.Fatal("publish failed")
+	}
+	checkList(t, server.List("testbeat"), 5)
+}
+
+func TestPublishEvents_Failover(t *testing.T) {
+	down := newFakeServer(t, "")
+	down.Close()
+	server := newFakeServer(t, "")
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{Hosts: []string{down.Addr(), server.Addr()}})
+	defer out.Close()
+
+	if !outputtest.Publish(t, out, outputtest.Events(5)) {
+		t.Fatal("publish failed")
+	}
+	checkList(t, server.List("testbeat"), 5)
+}
+
+func TestPublishEvents_LoadBalance(t *testing.T) {
+	down := newFakeServer(t, "")
+	down.Close()
+	servers := []*fakeServer{newFakeServer(t, ""), newFakeServer(t, "")}
+	for _, server := range servers {
+		defer server.Close()
+	}
+
+	loadBalance := true
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:       []string{servers[0].Addr(), down.Addr(), servers[1].Addr()},
+		LoadBalance: &loadBalance,
+		BulkMaxSize: outputtest.IntPtr(1),
+		MaxRetries:  outputtest.IntPtr(0),
+	})
+	defer out.Close()
+
+	// 发送到不可用节点的事件由其他节点发送，不会被丢弃
+	for i := 0; i < 20; i++ {
+		if !outputtest.Publish(t, out, outputtest.Events(1)) {
+			t.Fatal("publish failed")
+		}
+	}
+	if n := len(servers[0].List("testbeat")) + len(servers[1].List("testbeat")); n != 20 {
+		t.Fatalf("expected 20 events, got %d", n)
+	}
+}
+
+func TestPublishEvents_MaxRetries(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	defer server.Close()
+
+	// 密码错误时连接失败
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:      []string{server.Addr()},
+		Password:   "wrong",
+		MaxRetries: outputtest.IntPtr(1),
+	})
+	defer out.Close()
+
+	if outputtest.Publish(t, out, outputtest.Events(1)) {
+		t.Fatal("expected publish to fail")
+	}
+}
+
+func TestPublishEvents_CommandError(t *testing.T) {
+	server := newFakeServer(t, "")
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{
+		Hosts:    []string{server.Addr()},
+		KeyField: "key",
+	})
+	defer out.Close()
+
+	// 写入 wrongtype 的事件被 redis 拒绝，不影响其他事件，也不会重试
+	events := []common.MapStr{{"key": "wrongtype", "n": 0}, {"n": 0}, {"n": 1}}
+	if !outputtest.Publish(t, out, events) {
+		t.Fatal("publish failed")
+	}
+	checkList(t, server.List("testbeat"), 2)
+}
+
+func TestInit_Errors(t *testing.T) {
+	config := outputs.MothershipConfig{Hosts: []string{"localhost"}, DataType: "set"}
+	if err := New().Init("testbeat", &config, 0); err == nil {
+		t.Error("expected error for unknown datatype")
+	}
+	config = outputs.MothershipConfig{}
+	if err := New().Init("testbeat", &config, 0); err == nil {
+		t.Error("expected error without hosts")
+	}
+}
+
+func TestTopology(t *testing.T) {
+	server := newFakeServer(t, "")
+	defer server.Close()
+	server.mutex.Lock()
+	server.hashes["other"] = map[string]string{"ipaddrs": "10.0.0.3"}
+	server.mutex.Unlock()
+
+	config := outputs.MothershipConfig{Hosts: []string{server.Addr()}, SaveTopology: true}
+	out := New().(*redisOutput)
+	if err := out.Init("testbeat", &config, 30); err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	if err := out.PublishIPs("shipper", []string{"10.0.0.1", "10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	for ip, name := range map[string]string{"10.0.0.1": "shipper", "10.0.0.2": "shipper", "10.0.0.3": "other", "10.0.0.4": ""} {
+		if n := out.GetNameByIP(ip); n != name {
+			t.Errorf("%s: expected '%s', got '%s'", ip, name, n)
+		}
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.scans != 2 {
+		t.Errorf("expected the 2 shippers to be read with 2 SCAN commands, got %d", server.scans)
+	}
+	if server.expires["shipper"] != "30" {
+		t.Errorf("expected expire 30, got '%s'", server.expires["shipper"])
+	}
+	if len(server.dbs) != 1 || server.dbs[0] != "1" {
+		t.Errorf("expected SELECT 1, got %v", server.dbs)
+	}
+}
+
+func TestTopology_Disabled(t *testing.T) {
+	out := newTestOutput(t, outputs.MothershipConfig{Hosts: []string{"localhost"}})
+	defer out.Close()
+
+	if err := out.PublishIPs("shipper", []string{"10.0.0.1"}); err == nil {
+		t.Error("expected error without save_topology")
+	}
+	if name := out.GetNameByIP("10.0.0.1"); name != "" {
+		t.Errorf("expected no name, got '%s'", name)
+	}
+}
+
+func TestTestConnection(t *testing.T) {
+	server := newFakeServer(t, "secret")
+	defer server.Close()
+
+	out := newTestOutput(t, outputs.MothershipConfig{Hosts: []string{server.Addr()}, Password: "wrong"})
+	defer out.Close()
+	if results := out.TestConnection(); len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("expected authentication error, got %v", results)
+	}
+
+	out = newTestOutput(t, outputs.MothershipConfig{Hosts: []string{server.Addr()}, Password: "secret"})
+	defer out.Close()
+	if results := out.TestConnection(); len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("unexpected results %v", results)
+	}
+}
@@ -0,0 +1,145 @@
+package redis
+
+import (
+	"crypto/tls"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDbTopology     = 1
+	defaultTopologyExpire = 15  // 秒
+	scanCount             = 100 // 每次 SCAN 建议返回的 key 的数量
+)
+
+// topology 将每个 shipper 的 IP 地址保存在单独的数据库中，每个 shipper 一个 hash，
+// 字段 ipaddrs 为逗号分隔的地址，并设置过期时间，shipper 停止后它的地址会自动过期
+// topology stores the addresses of all shippers in a redis database
+type topology struct {
+	addr     string
+	tls      *tls.Config
+	timeout  time.Duration
+	password string
+	db       int
+	expire   int
+
+	// mutex 保护 conn，发布地址时可能比较慢，所以和 names 使用不同的锁
+	mutex sync.Mutex
+	conn  *conn
+
+	namesMutex sync.RWMutex
+	names      map[string]string // IP 地址 -> shipper 名称
+}
+
+// PublishIPs 保存 name 的地址，然后重新读取所有 shipper 的地址。
+// GetNameByIP 只读取这里缓存的结果，不会访问 redis
+func (t *topology) PublishIPs(name string, localAddrs []string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.conn == nil {
+		conn, err := dial(t.addr, t.tls, t.timeout, t.password, t.db)
+		if err != nil {
+			return err
+		}
+		t.conn = conn
+	}
+
+	err := t.publish(name, localAddrs)
+	if err != nil {
+		// redis 返回的错误不影响连接，其他错误时重新建立连接
+		if _, ok := err.(redisError); !ok {
+			t.conn.Close()
+			t.conn = nil
+		}
+	}
+	return err
+}
+
+func (t *topology) publish(name string, localAddrs []string) error {
+	if _, err := t.conn.Do("HSET", name, "ipaddrs", strings.Join(localAddrs, ",")); err != nil {
+		return err
+	}
+	if _, err := t.conn.Do("EXPIRE", name, strconv.Itoa(t.expire)); err != nil {
+		return err
+	}
+
+	keys, err := t.scanKeys()
+	if err != nil {
+		return err
+	}
+
+	names := map[string]string{}
+	for _, shipper := range keys {
+		reply, err := t.conn.Do("HGET", shipper, "ipaddrs")
+		if err != nil {
+			return err
+		}
+		addrs, ok := reply.(string)
+		if !ok || addrs == "" {
+			continue
+		}
+		for _, addr := range strings.Split(addrs, ",") {
+			names[addr] = shipper
+		}
+	}
+
+	t.namesMutex.Lock()
+	t.names = names
+	t.namesMutex.Unlock()
+	return nil
+}
+
+// scanKeys 使用 SCAN 分批读取拓扑数据库中所有的 key。KEYS 会在遍历所有 key 的过程中阻塞 redis，
+// SCAN 每次只遍历一小部分，同一个 key 可能被返回多次
+func (t *topology) scanKeys() ([]string, error) {
+	seen := map[string]bool{}
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := t.conn.Do("SCAN", cursor, "COUNT", strconv.Itoa(scanCount))
+		if err != nil {
+			return nil, err
+		}
+		values, ok := reply.([]interface{})
+		if !ok || len(values) != 2 {
+			return nil, errProtocol
+		}
+		cursor, ok = values[0].(string)
+		page, _ := values[1].([]interface{})
+		if !ok {
+			return nil, errProtocol
+		}
+
+		for _, value := range page {
+			if key, ok := value.(string); ok && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// GetNameByIP 返回上一次读取到的拓扑结构中 ip 所属的 shipper
+func (t *topology) GetNameByIP(ip string) string {
+	t.namesMutex.RLock()
+	defer t.namesMutex.RUnlock()
+	return t.names[ip]
+}
+
+// Close 关闭保存拓扑结构使用的连接，它和发送事件的连接是分开的
+func (t *topology) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
@@ -0,0 +1,186 @@
+package outputs
+
+import (
+	"github.com/ssp4599815/beat/libbeat/common"
+	"sync"
+	"sync/atomic"
+)
+
+// Signaler 用来通知发送方一批事件是否发送完成
+// Signaler signals the completion of potentially asynchronous output operation.
+// Completed is called by the output plugin when all events have been sent. On
+// failure or if only a subset of the data is published then Failed will be
+// invoked.
+type Signaler interface {
+	Completed()
+
+	Failed()
+}
+
+// EventsSignaler 是可以只重新发送一部分事件的 Signaler 实现的接口。
+// output 只有部分事件最终发送失败时调用 FailedEvents 代替 Failed，已经发送成功的事件不会被重新发送
+// EventsSignaler is a Signaler that can be told which events of a batch failed
+type EventsSignaler interface {
+	Signaler
+
+	FailedEvents(events []common.MapStr)
+}
+
+// SyncSignal blocks waiting for a signal.
+type SyncSignal struct {
+	ch chan bool
+}
+
+// SplitSignal guards one output signaler from multiple calls by using a simple
+// reference counting scheme. If one Signaler consumer reports a Failed event,
+// the Failed event will be send to the guarded Signaler once the reference
+// count becomes zero.
+//
+// 每一部分通过 FailedEvents 报告的失败事件会被收集起来，最后只把这些事件报告给被保护的 Signaler，
+// 只要有一部分调用了 Failed，整批事件都被当做失败
+//
+// Example use cases:
+//   - Push signaler to multiple outputers
+//   - split data to be send in smaller batches
+type SplitSignal struct {
+	count    int32
+	failed   int32
+	signaler Signaler
+
+	mutex  sync.Mutex
+	events []common.MapStr // 通过 FailedEvents 报告的失败事件
+}
+
+// CompositeSignal combines multiple signalers into one Signaler forwarding an
+// event to to all signalers.
+type CompositeSignal struct {
+	signalers []Signaler
+}
+
+// NewSyncSignal create a new SyncSignal signaler. Use Wait() method to wait for
+// a signal from the publisher
+func NewSyncSignal() *SyncSignal {
+	return &SyncSignal{ch: make(chan bool, 1)}
+}
+
+// Wait blocks waiting for a signal from the outputer. Wait return true if
+// Completed was signaled and false if a Failed signal was received
+func (s *SyncSignal) Wait() bool {
+	return <-s.ch
+}
+
+// Completed sends a completed signal to the blocked Wait()-call
+func (s *SyncSignal) Completed() {
+	s.ch <- true
+}
+
+// Failed sends a failed signal to the blocked Wait()-call
+func (s *SyncSignal) Failed() {
+	s.ch <- false
+}
+
+// NewSplitSignaler creates a new SplitSignal if s is not nil.
+// If s is nil, nil will be returned. The count is the number of events to be
+// received before publishing the final event to the guarded Signaler.
+func NewSplitSignaler(
+	s Signaler,
+	count int,
+) Signaler {
+	if s == nil {
+		return nil
+	}
+
+	return &SplitSignal{
+		count:    int32(count),
+		signaler: s,
+	}
+}
+
+// Completed signals a Completed event to s.
+func (s *SplitSignal) Completed() {
+	s.onEvent()
+}
+
+// Failed signals a Failed event to s.
+func (s *SplitSignal) Failed() {
+	atomic.StoreInt32(&s.failed, 1)
+	s.onEvent()
+}
+
+// FailedEvents 记录这一部分中发送失败的事件
+func (s *SplitSignal) FailedEvents(events []common.MapStr) {
+	s.mutex.Lock()
+	s.events = append(s.events, events...)
+	s.mutex.Unlock()
+	s.onEvent()
+}
+
+func (s *SplitSignal) onEvent() {
+	res := atomic.AddInt32(&s.count, -1)
+	if res == 0 {
+		s.mutex.Lock()
+		events := s.events
+		s.mutex.Unlock()
+
+		if atomic.LoadInt32(&s.failed) == 1 {
+			s.signaler.Failed()
+		} else if len(events) > 0 {
+			SignalFailedEvents(s.signaler, events)
+		} else {
+			s.signaler.Completed()
+		}
+	}
+}
+
+// NewCompositeSignaler creates a new composite signaler.
+func NewCompositeSignaler(signalers ...Signaler) Signaler {
+	if len(signalers) == 0 {
+		return nil
+	}
+	return &CompositeSignal{signalers}
+}
+
+// Completed sends the Completed signal to all signalers.
+func (cs *CompositeSignal) Completed() {
+	if cs != nil {
+		for _, s := range cs.signalers {
+			if s != nil {
+				s.Completed()
+			}
+		}
+	}
+}
+
+// Failed sends the Failed signal to all signalers.
+func (cs *CompositeSignal) Failed() {
+	if cs != nil {
+		for _, s := range cs.signalers {
+			if s != nil {
+				s.Failed()
+			}
+		}
+	}
+}
+
+// SignalCompleted sends the Completed event to s if s is not nil.
+func SignalCompleted(s Signaler) {
+	if s != nil {
+		s.Completed()
+	}
+}
+
+// SignalFailed sends the Failed event to s if s is not nil
+func SignalFailed(s Signaler) {
+	if s != nil {
+		s.Failed()
+	}
+}
+
+// SignalFailedEvents 报告 events 发送失败，s 没有实现 EventsSignaler 时整批事件都被当做失败
+func SignalFailedEvents(s Signaler, events []common.MapStr) {
+	if es, ok := s.(EventsSignaler); ok {
+		es.FailedEvents(events)
+		return
+	}
+	SignalFailed(s)
+}
@@ -0,0 +1,228 @@
+package outputs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// TLSConfig 是 output 连接服务端时使用的 TLS 配置
+// TLSConfig configures the TLS connection of an output
+type TLSConfig struct {
+	Certificate      string   `yaml:"certificate"`             // 客户端证书
+	CertificateKey   string   `yaml:"certificate_key"`         // 客户端证书的私钥
+	CAs              []string `yaml:"certificate_authorities"` // 用来校验服务端证书的 CA
+	Insecure         bool     `yaml:"insecure"`                // 不校验服务端证书，等同于 verification_mode: none
+	MinVersion       string   `yaml:"min_version"`             // 最低的 TLS 版本，比如 1.2
+	MaxVersion       string   `yaml:"max_version"`             // 最高的 TLS 版本
+	CipherSuites     []string `yaml:"cipher_suites"`           // 允许的加密套件 (TLS 1.3 的套件不能配置)
+	VerificationMode string   `yaml:"verification_mode"`       // full (默认)、certificate 或者 none
+	Fingerprints     []string `yaml:"fingerprints"`            // 服务端证书链中必须包含的证书的 sha256 指纹
+}
+
+// 服务端证书的校验方式
+const (
+	// VerifyFull 校验证书链和主机名
+	VerifyFull = "full"
+	// VerifyCertificate 只校验证书链，不校验主机名
+	VerifyCertificate = "certificate"
+	// VerifyNone 不校验证书，配置了 fingerprints 时仍然会检查指纹
+	VerifyNone = "none"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":                 tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":                  tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":                  tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":               tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":               tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":               tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":          tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256":       tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384":       tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// LoadTLSConfig 读取证书文件，生成 tls.Config。config 为 nil 时返回 nil，表示不使用 TLS
+// LoadTLSConfig creates a tls.Config from the given TLSConfig
+func LoadTLSConfig(config *TLSConfig) (*tls.Config, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	mode := config.VerificationMode
+	if mode == "" {
+		mode = VerifyFull
+		if config.Insecure {
+			mode = VerifyNone
+		}
+	}
+	switch mode {
+	case VerifyFull, VerifyCertificate, VerifyNone:
+	default:
+		return nil, fmt.Errorf("unknown verification_mode '%s', must be one of %s, %s, %s",
+			mode, VerifyFull, VerifyCertificate, VerifyNone)
+	}
+	if config.Insecure && mode != VerifyNone {
+		return nil, fmt.Errorf("insecure can not be used with verification_mode %s", mode)
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if config.Certificate != "" || config.CertificateKey != "" {
+		if config.Certificate == "" || config.CertificateKey == "" {
+			return nil, errors.New("both certificate and certificate_key must be configured")
+		}
+		cert, err := tls.LoadX509KeyPair(config.Certificate, config.CertificateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(config.CAs) > 0 {
+		pool := x509.NewCertPool()
+		for _, path := range config.CAs {
+			pem, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read certificate authority: %v", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in %s", path)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	var err error
+	if tlsConfig.MinVersion, err = parseTLSVersion(config.MinVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.MaxVersion, err = parseTLSVersion(config.MaxVersion); err != nil {
+		return nil, err
+	}
+	if tlsConfig.MinVersion != 0 && tlsConfig.MaxVersion != 0 && tlsConfig.MinVersion > tlsConfig.MaxVersion {
+		return nil, fmt.Errorf("min_version %s is greater than max_version %s", config.MinVersion, config.MaxVersion)
+	}
+
+	for _, name := range config.CipherSuites {
+		suite, ok := tlsCipherSuites[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite '%s'", name)
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, suite)
+	}
+
+	fingerprints := make([][]byte, len(config.Fingerprints))
+	for i, fingerprint := range config.Fingerprints {
+		if fingerprints[i], err = parseFingerprint(fingerprint); err != nil {
+			return nil, err
+		}
+	}
+
+	// full 模式使用 go 默认的校验，另外两种模式跳过默认的校验，在 VerifyPeerCertificate 中自己校验
+	tlsConfig.InsecureSkipVerify = mode != VerifyFull
+	if mode == VerifyCertificate || len(fingerprints) > 0 {
+		roots := tlsConfig.RootCAs
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+			if mode == VerifyCertificate {
+				var err error
+				if chains, err = verifyCertificateChain(rawCerts, roots); err != nil {
+					return err
+				}
+			}
+			return verifyFingerprints(rawCerts, chains, fingerprints)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// parseTLSVersion 解析 1.2 或者 TLSv1.2 形式的版本号，为空时返回 0，表示使用 go 的默认值
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[strings.TrimPrefix(version, "TLSv")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version '%s', must be one of 1.0, 1.1, 1.2, 1.3", version)
+	}
+	return v, nil
+}
+
+// parseFingerprint 解析十六进制的 sha256 指纹，可以使用 ':' 分隔，不区分大小写
+func parseFingerprint(fingerprint string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.Replace(fingerprint, ":", "", -1))
+	if err != nil || len(b) != sha256.Size {
+		return nil, fmt.Errorf("invalid fingerprint '%s', must be the hex encoded sha256 of the certificate", fingerprint)
+	}
+	return b, nil
+}
+
+// verifyCertificateChain 使用 roots (为 nil 时使用系统的 CA) 校验服务端的证书链，不校验主机名
+func verifyCertificateChain(rawCerts [][]byte, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	if len(rawCerts) == 0 {
+		return nil, errors.New("server did not present a certificate")
+	}
+
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse server certificate: %v", err)
+		}
+		certs[i] = cert
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	return certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+}
+
+// verifyFingerprints 检查服务端发送的证书以及校验过的证书链中是否有 fingerprints 中的一个，
+// 既可以固定服务端的证书，也可以固定签发它的 CA。没有配置 fingerprints 时不检查
+func verifyFingerprints(rawCerts [][]byte, chains [][]*x509.Certificate, fingerprints [][]byte) error {
+	if len(fingerprints) == 0 {
+		return nil
+	}
+
+	certs := append([][]byte{}, rawCerts...)
+	for _, chain := range chains {
+		for _, cert := range chain {
+			certs = append(certs, cert.Raw)
+		}
+	}
+	for _, raw := range certs {
+		sum := sha256.Sum256(raw)
+		for _, fingerprint := range fingerprints {
+			if bytes.Equal(sum[:], fingerprint) {
+				return nil
+			}
+		}
+	}
+	return errors.New("no server certificate matches the configured fingerprints")
+}
@@ -0,0 +1,287 @@
+package outputs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert 是测试中生成的证书以及写入的文件
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func (c *testCert) fingerprint() string {
+	sum := sha256.Sum256(c.cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// newTestCert 生成证书，parent 为 nil 时生成自签名的 CA
+func newTestCert(t *testing.T, dir, name string, parent *testCert, ips ...string) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, ip := range ips {
+		template.IPAddresses = append(template.IPAddresses, net.ParseIP(ip))
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	if err := ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// startTLSServer 启动一个 TLS 服务端，握手成功时发送一个字节，然后关闭连接
+func startTLSServer(t *testing.T, config *tls.Config) net.Listener {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if err := conn.(*tls.Conn).Handshake(); err == nil {
+				conn.Write([]byte{1})
+			}
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+func dialTLS(t *testing.T, addr string, config *TLSConfig) error {
+	tlsConfig, err := LoadTLSConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", addr, tlsConfig)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func TestLoadTLSConfig_Verification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, dir, "ca", nil)
+	otherCA := newTestCert(t, dir, "other-ca", nil)
+	server := newTestCert(t, dir, "server", ca, "127.0.0.1")
+	wrongHost := newTestCert(t, dir, "wrong-host", ca, "10.0.0.1")
+
+	good := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{server.tlsCertificate()}})
+	defer good.Close()
+	bad := startTLSServer(t, &tls.Config{Certificates: []tls.Certificate{wrongHost.tlsCertificate()}})
+	defer bad.Close()
+
+	tests := []struct {
+		name   string
+		addr   string
+		config TLSConfig
+		ok     bool
+	}{
+		{"full", good.Addr().String(), TLSConfig{CAs: []string{ca.certFile}}, true},
+		{"full unknown ca", good.Addr().String(), TLSConfig{CAs: []string{otherCA.certFile}}, false},
+		{"full wrong host", bad.Addr().String(), TLSConfig{CAs: []string{ca.certFile}}, false},
+		{"certificate wrong host", bad.Addr().String(),
+			TLSConfig{CAs: []string{ca.certFile}, VerificationMode: "certificate"}, true},
+		{"certificate unknown ca", bad.Addr().String(),
+			TLSConfig{CAs: []string{otherCA.certFile}, VerificationMode: "certificate"}, false},
+		{"none", bad.Addr().String(), TLSConfig{VerificationMode: "none"}, true},
+		{"insecure", bad.Addr().String(), TLSConfig{Insecure: true}, true},
+		{"pin server", good.Addr().String(),
+			TLSConfig{VerificationMode: "none", Fingerprints: []string{server.fingerprint()}}, true},
+		{"pin other", good.Addr().String(),
+			TLSConfig{VerificationMode: "none", Fingerprints: []string{otherCA.fingerprint()}}, false},
+		{"pin ca in verified chain", good.Addr().String(),
+			TLSConfig{CAs: []string{ca.certFile}, Fingerprints: []string{ca.fingerprint()}}, true},
+		{"pin ca wrong host", bad.Addr().String(),
+			TLSConfig{CAs: []string{ca.certFile}, VerificationMode: "certificate", Fingerprints: []string{ca.fingerprint()}}, true},
+	}
+
+	for _, test := range tests {
+		err := dialTLS(t, test.addr, &test.config)
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: expected handshake to fail", test.name)
+		}
+	}
+}
+
+func TestLoadTLSConfig_ClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca, "127.0.0.1")
+	client := newTestCert(t, dir, "client", ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	ln := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	defer ln.Close()
+
+	config := TLSConfig{CAs: []string{ca.certFile}, Certificate: client.certFile, CertificateKey: client.keyFile}
+	tlsConfig, err := LoadTLSConfig(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := readAccepted(ln.Addr().String(), tlsConfig); err != nil {
+		t.Fatalf("client certificate rejected: %v", err)
+	}
+
+	// 没有客户端证书时被服务端拒绝
+	config = TLSConfig{CAs: []string{ca.certFile}}
+	if tlsConfig, err = LoadTLSConfig(&config); err != nil {
+		t.Fatal(err)
+	}
+	if err := readAccepted(ln.Addr().String(), tlsConfig); err == nil {
+		t.Fatal("expected connection without client certificate to be rejected")
+	}
+}
+
+// readAccepted 连接服务端并读取握手成功后发送的字节。
+// TLS 1.3 中服务端在客户端握手完成之后才校验客户端证书，所以需要读取一次才能知道是否被拒绝
+func readAccepted(addr string, tlsConfig *tls.Config) error {
+	conn, err := tls.Dial("tcp", addr, tlsConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	return err
+}
+
+func TestLoadTLSConfig_Versions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, dir, "ca", nil)
+	server := newTestCert(t, dir, "server", ca, "127.0.0.1")
+	ln := startTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		MaxVersion:   tls.VersionTLS12,
+	})
+	defer ln.Close()
+
+	config := TLSConfig{CAs: []string{ca.certFile}, MinVersion: "1.2", MaxVersion: "TLSv1.2",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}
+	if err := dialTLS(t, ln.Addr().String(), &config); err != nil {
+		t.Errorf("expected TLS 1.2 handshake to succeed: %v", err)
+	}
+
+	config = TLSConfig{CAs: []string{ca.certFile}, MinVersion: "1.3"}
+	if err := dialTLS(t, ln.Addr().String(), &config); err == nil {
+		t.Error("expected handshake to fail with min_version 1.3")
+	}
+
+	config = TLSConfig{CAs: []string{ca.certFile}, MaxVersion: "1.2",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}
+	if err := dialTLS(t, ln.Addr().String(), &config); err == nil {
+		t.Error("expected handshake to fail without a common cipher suite")
+	}
+}
+
+func TestLoadTLSConfig_Errors(t *testing.T) {
+	configs := []TLSConfig{
+		{VerificationMode: "strict"},
+		{VerificationMode: "full", Insecure: true},
+		{MinVersion: "2.0"},
+		{MinVersion: "1.3", MaxVersion: "1.2"},
+		{CipherSuites: []string{"TLS_NULL"}},
+		{Fingerprints: []string{"abcd"}},
+		{Certificate: "cert.pem"},
+		{CAs: []string{"/does/not/exist"}},
+	}
+	for i, config := range configs {
+		if _, err := LoadTLSConfig(&config); err == nil {
+			t.Errorf("%d: expected error for %+v", i, config)
+		}
+	}
+
+	if config, err := LoadTLSConfig(nil); config != nil || err != nil {
+		t.Errorf("expected nil config without tls, got %v, %v", config, err)
+	}
+}
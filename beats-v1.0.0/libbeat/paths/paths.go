@@ -0,0 +1,89 @@
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Path 定义了 beat 使用的目录:
+// - Home: beat 的主目录，默认为当前的工作目录
+// - Data: 持久化数据 (比如 filebeat 的 registry 文件) 的目录，默认为 Home
+// - Logs: 日志文件的目录，默认为 Home/logs
+// Path contains the directories a beat reads from and writes to
+type Path struct {
+	Home string
+	Data string
+	Logs string
+}
+
+// FileType 表示一个文件属于哪个目录
+type FileType string
+
+const (
+	Home FileType = "home"
+	Data FileType = "data"
+	Logs FileType = "logs"
+)
+
+// Paths is the Path used by the beat, initialised from the -path.* flags
+var Paths = &Path{}
+
+// InitPaths sets the default paths. Empty paths are set to their default
+func InitPaths(home, data, logs string) error {
+	return Paths.InitPaths(home, data, logs)
+}
+
+// InitPaths sets the paths of p. Empty paths are set to their default and all
+// paths are made absolute
+func (p *Path) InitPaths(home, data, logs string) error {
+	var err error
+
+	if home == "" {
+		home, err = os.Getwd()
+		if err != nil {
+			return fmt.Errorf("Failed to get the working directory: %v", err)
+		}
+	}
+	if data == "" {
+		data = home
+	}
+	if logs == "" {
+		logs = filepath.Join(home, "logs")
+	}
+
+	for _, path := range []*string{&home, &data, &logs} {
+		*path, err = filepath.Abs(*path)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.Home, p.Data, p.Logs = home, data, logs
+	return nil
+}
+
+// Resolve resolves a path relative to the directory of the given file type
+func Resolve(fileType FileType, path string) string {
+	return Paths.Resolve(fileType, path)
+}
+
+// 绝对路径和未知的 fileType 直接返回 path，相对路径则相对于 fileType 对应的目录
+// Resolve returns path as is if it is absolute or the file type is unknown,
+// otherwise it is joined with the directory of the given file type
+func (p *Path) Resolve(fileType FileType, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	switch fileType {
+	case Home:
+		return filepath.Join(p.Home, path)
+	case Data:
+		return filepath.Join(p.Data, path)
+	case Logs:
+		return filepath.Join(p.Logs, path)
+	default:
+		return path
+	}
+}
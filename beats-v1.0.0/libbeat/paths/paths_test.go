@@ -0,0 +1,66 @@
+package paths
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitPaths_Defaults(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Path{}
+	if err := p.InitPaths("", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if p.Home != wd || p.Data != wd || p.Logs != filepath.Join(wd, "logs") {
+		t.Errorf("unexpected default paths %+v", p)
+	}
+
+	// data 和 logs 默认在 home 中
+	if err := p.InitPaths("/opt/beat", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if p.Home != "/opt/beat" || p.Data != "/opt/beat" || p.Logs != "/opt/beat/logs" {
+		t.Errorf("unexpected paths %+v", p)
+	}
+}
+
+func TestInitPaths_Overrides(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Path{}
+	if err := p.InitPaths("/opt/beat", "/var/lib/beat", "logs"); err != nil {
+		t.Fatal(err)
+	}
+	// 相对路径相对于工作目录
+	if p.Home != "/opt/beat" || p.Data != "/var/lib/beat" || p.Logs != filepath.Join(wd, "logs") {
+		t.Errorf("unexpected paths %+v", p)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	p := &Path{Home: "/opt/beat", Data: "/var/lib/beat", Logs: "/var/log/beat"}
+
+	tests := []struct {
+		fileType       FileType
+		path, expected string
+	}{
+		{Home, "beat.yml", "/opt/beat/beat.yml"},
+		{Data, "registry", "/var/lib/beat/registry"},
+		{Logs, "beat.log", "/var/log/beat/beat.log"},
+		{Data, "/tmp/registry", "/tmp/registry"},
+		{FileType("unknown"), "registry", "registry"},
+	}
+	for _, test := range tests {
+		if actual := p.Resolve(test.fileType, test.path); actual != test.expected {
+			t.Errorf("%s %s: expected %s, got %s", test.fileType, test.path, test.expected, actual)
+		}
+	}
+}
@@ -1,14 +1,104 @@
 package publisher
 
-import "github.com/ssp4599815/beat/libbeat/common"
+import (
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/monitoring"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+)
 
 // ClientOPtion allows API users to set additional options when publishing events
 type ClientOption func(option *publishOptions)
+
+// Client 是 beat 用来发送事件的接口。
+// 默认是异步模式：事件放入 publisher 的队列后立即返回，队列满时会阻塞调用方；
+// 返回值表示事件是否被接收 (使用 Confirm 或者 Sync 时表示是否发送成功)
+// Client is used by beats to publish events to all configured outputs
 type Client interface {
+	// PublishEvent 发送单个事件，配合 Signal 可以得到每一个事件的发送结果
+	PublishEvent(event common.MapStr, opts ...ClientOption) bool
+
+	// PublishEvents 发送一批事件，所有 output 都处理完这一批事件后才算完成
 	PublishEvents(events []common.MapStr, opts ...ClientOption) bool
 }
 
+// Confirm 等待所有的 output 发送完成，事件仍然经过队列，返回是否发送成功
+func Confirm(options *publishOptions) {
+	options.confirm = true
+}
+
+// Sync 不经过队列，由调用方直接将事件交给 output，并等待发送完成
 func Sync(options *publishOptions) {
 	options.confirm = true
 	options.sync = true
 }
+
+// Guaranteed 发送失败时一直重试，直到发送成功或者 publisher 被停止
+func Guaranteed(options *publishOptions) {
+	options.guaranteed = true
+}
+
+// Signal 在所有的 output 发送完成或者失败后通知 signal，可以和其他选项一起使用
+func Signal(signal outputs.Signaler) ClientOption {
+	return func(options *publishOptions) {
+		options.signal = outputs.NewCompositeSignaler(options.signal, signal)
+	}
+}
+
+// client 是 Client 的实现，所有的 client 共用一个 publisher
+type client struct {
+	publisher *PublisherType
+}
+
+func (c *client) PublishEvent(event common.MapStr, opts ...ClientOption) bool {
+	return c.PublishEvents([]common.MapStr{event}, opts...)
+}
+
+func (c *client) PublishEvents(events []common.MapStr, opts ...ClientOption) bool {
+	var options publishOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var sync *outputs.SyncSignal
+	signal := options.signal
+	if options.confirm {
+		sync = outputs.NewSyncSignal()
+		signal = outputs.NewCompositeSignaler(signal, sync)
+	}
+
+	events = c.publisher.filterEvents(events)
+	eventsPublished.Add(int64(len(events)))
+	signal = outputs.NewCompositeSignaler(signal, &metricsSignal{count: int64(len(events))})
+
+	msg := message{events: events, signal: signal, guaranteed: options.guaranteed}
+	var ok bool
+	if options.sync {
+		ok = c.publisher.forwardSync(msg)
+	} else {
+		ok = c.publisher.enqueue(msg)
+	}
+	if !ok || sync == nil {
+		return ok
+	}
+	return sync.Wait()
+}
+
+// publisher 的内部指标
+var (
+	eventsPublished = monitoring.NewCounter("libbeat.publisher.events_published")
+	eventsAcked     = monitoring.NewCounter("libbeat.publisher.events_acked")
+	eventsFailed    = monitoring.NewCounter("libbeat.publisher.events_failed")
+)
+
+// metricsSignal 根据一批事件的发送结果更新 events_acked 或者 events_failed
+type metricsSignal struct {
+	count int64
+}
+
+func (s *metricsSignal) Completed() {
+	eventsAcked.Add(s.count)
+}
+
+func (s *metricsSignal) Failed() {
+	eventsFailed.Add(s.count)
+}
@@ -1,16 +1,279 @@
 package publisher
 
+import (
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/geoip"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"os"
+	"sync"
+	"time"
+)
+
 // 发货人
 type ShipperConfig struct {
-	Name                string
-	RefreshTopologyFreq int // 刷新拓扑结构的频率
-	IgnoreOutgoing      bool
-	TopologyExpire      int // 拓扑结构的存活时间
-	Tags                []string
-	// Geoip 根据ip获取地址位置
+	Name                string       // shipper 的名称，默认为主机名
+	RefreshTopologyFreq int          `yaml:"refresh_topology_freq"` // 刷新拓扑结构的频率 (秒)
+	IgnoreOutgoing      bool         `yaml:"ignore_outgoing"`       // 丢弃由本机发出的、对端也会上报的事件
+	TopologyExpire      int          `yaml:"topology_expire"`       // 拓扑结构的存活时间 (秒)
+	Tags                []string     // 添加到每个事件中的标签
+	QueueSize           int          `yaml:"queue_size"` // 队列中最多缓存的批次数量，队列满时会阻塞调用方
+	Geoip               geoip.Config // Geoip 根据ip获取地址位置
 }
 
 type publishOptions struct {
-	confirm bool
-	sync    bool
+	confirm    bool
+	sync       bool
+	guaranteed bool
+	signal     outputs.Signaler
+}
+
+const defaultQueueSize = 1000
+
+// guaranteed 模式下 output 发送失败后等待多长时间再重新发送，测试中会改小
+var retryBackoff = 1 * time.Second
+
+// message 是队列中的一批事件
+type message struct {
+	events     []common.MapStr
+	signal     outputs.Signaler
+	guaranteed bool
+}
+
+// PublisherType 将 beat 产生的事件发送到所有启用的 output。
+// 每个事件都会加上 shipper 的名称、主机名和标签，配置了 geoip 时还会加上 IP 地址的地理位置；
+// 异步发送的事件先放入一个有界的队列，由一个 goroutine 按顺序交给每一个 output，
+// 队列满了之后 PublishEvents 会阻塞，从而将 output 的压力传递给 beat
+// PublisherType forwards the events of a beat to all configured outputs
+type PublisherType struct {
+	name           string   // shipper 的名称
+	hostname       string   // 主机名
+	tags           []string // 添加到每个事件中的标签
+	ignoreOutgoing bool
+	ipaddrs        []string // 本机的 IP 地址
+	geoip          *geoip.Processor
+
+	outputs         []outputs.OutputPlugin
+	topology        outputs.TopologyOutputer // 保存拓扑结构的 output，没有时为 nil
+	refreshTopology time.Duration
+
+	queue chan message
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	// 发送事件时持有读锁，Stop 持有写锁，保证 Stop 之后不会再有事件进入队列
+	mutex sync.RWMutex
+}
+
+// New 创建 publisher 并启动转发事件的 goroutine，有 output 配置了 save_topology 时还会定期更新拓扑结构
+// New creates a publisher forwarding events to plugins
+func New(config ShipperConfig, plugins []outputs.OutputPlugin) (*PublisherType, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the hostname: %v", err)
+	}
+	ipaddrs, err := localAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the local addresses: %v", err)
+	}
+
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+
+	p := &PublisherType{
+		name:           config.Name,
+		hostname:       hostname,
+		tags:           config.Tags,
+		ignoreOutgoing: config.IgnoreOutgoing,
+		ipaddrs:        ipaddrs,
+		outputs:        plugins,
+		queue:          make(chan message, queueSize),
+		done:           make(chan struct{}),
+	}
+	if p.name == "" {
+		p.name = hostname
+	}
+	if len(config.Geoip.Paths) > 0 {
+		if p.geoip, err = geoip.New(config.Geoip); err != nil {
+			return nil, err
+		}
+	}
+	p.initTopology(config)
+
+	p.wg.Add(1)
+	go p.run()
+	if p.topology != nil {
+		p.wg.Add(1)
+		go p.updateTopologyPeriodically()
+	}
+	return p, nil
+}
+
+// Client 返回一个用来发送事件的 client
+func (p *PublisherType) Client() Client {
+	return &client{publisher: p}
+}
+
+// Stop 停止 publisher，队列中的事件以及正在重试的事件都会被当做发送失败。
+// Stop 之后发送事件会直接失败，output 需要在 Stop 之后由调用方关闭
+func (p *PublisherType) Stop() {
+	select {
+	case <-p.done:
+		return
+	default:
+		close(p.done)
+	}
+
+	// 等待所有正在发送的调用返回
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.wg.Wait()
+
+	for {
+		select {
+		case msg := <-p.queue:
+			outputs.SignalFailed(msg.signal)
+		default:
+			return
+		}
+	}
+}
+
+// filterEvents 给事件加上 shipper 的信息，返回没有被丢弃的事件
+func (p *PublisherType) filterEvents(events []common.MapStr) []common.MapStr {
+	filtered := make([]common.MapStr, 0, len(events))
+	for _, event := range events {
+		if !p.updateEventAddresses(event) {
+			continue
+		}
+		if p.geoip != nil {
+			p.geoip.Run(event)
+		}
+
+		event["beat"] = common.MapStr{"name": p.name, "hostname": p.hostname}
+		if len(p.tags) > 0 {
+			tags, _ := event["tags"].([]string)
+			event["tags"] = append(append([]string{}, tags...), p.tags...)
+		}
+		filtered = append(filtered, event)
+	}
+	return filtered
+}
+
+// enqueue 将事件放入队列，队列满时阻塞。publisher 已经停止时返回 false
+func (p *PublisherType) enqueue(msg message) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.stopped() {
+		outputs.SignalFailed(msg.signal)
+		return false
+	}
+
+	select {
+	case p.queue <- msg:
+		return true
+	case <-p.done:
+		outputs.SignalFailed(msg.signal)
+		return false
+	}
+}
+
+// forwardSync 不经过队列，直接将事件交给 output
+func (p *PublisherType) forwardSync(msg message) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.stopped() {
+		outputs.SignalFailed(msg.signal)
+		return false
+	}
+
+	p.forward(msg)
+	return true
+}
+
+func (p *PublisherType) stopped() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run 按顺序将队列中的事件交给 output
+func (p *PublisherType) run() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.done:
+			return
+		case msg := <-p.queue:
+			p.forward(msg)
+		}
+	}
+}
+
+// forward 将一批事件交给所有的 output，所有的 output 都完成后通知 msg.signal。
+// 任何一个 output 失败都会被当做失败，guaranteed 模式下只会重新发送给失败的 output
+func (p *PublisherType) forward(msg message) {
+	if len(msg.events) == 0 || len(p.outputs) == 0 {
+		outputs.SignalCompleted(msg.signal)
+		return
+	}
+
+	signal := outputs.NewSplitSignaler(msg.signal, len(p.outputs))
+	for _, plugin := range p.outputs {
+		s := signal
+		if msg.guaranteed {
+			s = &retrySignal{publisher: p, plugin: plugin, events: msg.events, signal: signal}
+		}
+		publishTo(plugin, s, msg.events)
+	}
+}
+
+func publishTo(plugin outputs.OutputPlugin, signal outputs.Signaler, events []common.MapStr) {
+	if err := plugin.Output.PublishEvents(signal, events); err != nil {
+		logp.Err("publisher: failed to publish %d event(s) to %s: %v", len(events), plugin.Name, err)
+	}
+}
+
+// retrySignal 在 output 发送失败时将事件重新交给这个 output，直到发送成功或者 publisher 停止
+type retrySignal struct {
+	publisher *PublisherType
+	plugin    outputs.OutputPlugin
+	events    []common.MapStr
+	signal    outputs.Signaler
+}
+
+func (s *retrySignal) Completed() {
+	outputs.SignalCompleted(s.signal)
+}
+
+// Failed 在 output 的 goroutine 中被调用，这里直接调用 PublishEvents 可能会阻塞 output，
+// 所以在新的 goroutine 中等待并重新发送
+func (s *retrySignal) Failed() {
+	s.retry(s.events)
+}
+
+// FailedEvents 只重新发送 output 报告失败的事件，已经发送成功的事件不会重复发送
+func (s *retrySignal) FailedEvents(events []common.MapStr) {
+	s.retry(events)
+}
+
+func (s *retrySignal) retry(events []common.MapStr) {
+	go func() {
+		select {
+		case <-time.After(retryBackoff):
+		case <-s.publisher.done:
+			outputs.SignalFailed(s.signal)
+			return
+		}
+
+		logp.Debug("publish", "retrying %d event(s) on %s", len(events), s.plugin.Name)
+		retry := &retrySignal{publisher: s.publisher, plugin: s.plugin, events: events, signal: s.signal}
+		publishTo(s.plugin, retry, events)
+	}()
 }
@@ -0,0 +1,327 @@
+package publisher
+
+import (
+	"errors"
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	retryBackoff = time.Millisecond
+}
+
+// fakeOutput 记录收到的事件，前 fail 次发送会失败，block 不为 nil 时每次发送都会等待 block。
+// partial 为 true 时第一次发送只接收第一个事件，其余的事件通过 FailedEvents 报告失败
+type fakeOutput struct {
+	mutex   sync.Mutex
+	events  []common.MapStr
+	fail    int
+	partial bool
+	calls   int
+	block   chan struct{}
+}
+
+func (o *fakeOutput) Init(beatName string, config *outputs.MothershipConfig, topologyExpire int) error {
+	return nil
+}
+
+func (o *fakeOutput) PublishEvents(signal outputs.Signaler, events []common.MapStr) error {
+	if o.block != nil {
+		<-o.block
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.calls++
+	if o.fail != 0 {
+		o.fail--
+		outputs.SignalFailed(signal)
+		return errors.New("fake failure")
+	}
+	if o.partial && len(events) > 1 {
+		o.partial = false
+		o.events = append(o.events, events[0])
+		outputs.SignalFailedEvents(signal, events[1:])
+		return nil
+	}
+	o.events = append(o.events, events...)
+	outputs.SignalCompleted(signal)
+	return nil
+}
+
+func (o *fakeOutput) Close() error { return nil }
+
+func (o *fakeOutput) Events() []common.MapStr {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.events
+}
+
+func (o *fakeOutput) Calls() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.calls
+}
+
+func newTestPublisher(t *testing.T, queueSize int, outs ...*fakeOutput) *PublisherType {
+	var plugins []outputs.OutputPlugin
+	for _, out := range outs {
+		plugins = append(plugins, outputs.OutputPlugin{Name: "fake", Output: out})
+	}
+	p, err := New(ShipperConfig{QueueSize: queueSize}, plugins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func testEvents(n int) []common.MapStr {
+	events := make([]common.MapStr, n)
+	for i := range events {
+		events[i] = common.MapStr{"n": i}
+	}
+	return events
+}
+
+func TestPublishEvents_Async(t *testing.T) {
+	out1, out2 := &fakeOutput{}, &fakeOutput{}
+	p := newTestPublisher(t, 0, out1, out2)
+	defer p.Stop()
+	client := p.Client()
+
+	// 每一个事件都有自己的 signal
+	signals := make([]*outputs.SyncSignal, 5)
+	for i := range signals {
+		signals[i] = outputs.NewSyncSignal()
+		if !client.PublishEvent(common.MapStr{"n": i}, Signal(signals[i])) {
+			t.Fatal("publish failed")
+		}
+	}
+	for i, signal := range signals {
+		if !signal.Wait() {
+			t.Fatalf("event %d failed", i)
+		}
+	}
+
+	// 所有的 output 都收到了全部的事件，并且保持发送的顺序
+	for _, out := range []*fakeOutput{out1, out2} {
+		events := out.Events()
+		if len(events) != 5 {
+			t.Fatalf("expected 5 events, got %d", len(events))
+		}
+		for i, event := range events {
+			if event["n"] != i {
+				t.Fatalf("event %d out of order: %v", i, event)
+			}
+		}
+	}
+}
+
+func TestPublishEvents_Confirm(t *testing.T) {
+	out1, out2 := &fakeOutput{}, &fakeOutput{fail: 1}
+	p := newTestPublisher(t, 0, out1, out2)
+	defer p.Stop()
+	client := p.Client()
+
+	// 一个 output 失败时整批事件都算作失败
+	if client.PublishEvents(testEvents(3), Confirm) {
+		t.Fatal("expected publish to fail")
+	}
+	if !client.PublishEvents(testEvents(3), Sync) {
+		t.Fatal("publish failed")
+	}
+	if len(out1.Events()) != 6 || len(out2.Events()) != 3 {
+		t.Fatalf("unexpected events %d/%d", len(out1.Events()), len(out2.Events()))
+	}
+}
+
+func TestPublishEvents_GuaranteedPartialFailure(t *testing.T) {
+	out := &fakeOutput{partial: true}
+	p := newTestPublisher(t, 0, out)
+	defer p.Stop()
+	client := p.Client()
+
+	// 只有失败的事件被重新发送，已经发送成功的事件不会重复
+	if !client.PublishEvents(testEvents(3), Sync, Guaranteed) {
+		t.Fatal("publish failed")
+	}
+	events := out.Events()
+	if len(events) != 3 || out.Calls() != 2 {
+		t.Fatalf("expected 3 events in 2 calls, got %v in %d calls", events, out.Calls())
+	}
+	for i, event := range events {
+		if event["n"] != i {
+			t.Errorf("unexpected event %d: %v", i, event)
+		}
+	}
+}
+
+func TestPublishEvents_Guaranteed(t *testing.T) {
+	out1, out2 := &fakeOutput{}, &fakeOutput{fail: 3}
+	p := newTestPublisher(t, 0, out1, out2)
+	defer p.Stop()
+	client := p.Client()
+
+	if !client.PublishEvents(testEvents(2), Sync, Guaranteed) {
+		t.Fatal("publish failed")
+	}
+	// 只有失败的 output 会重新发送
+	if out1.Calls() != 1 || out2.Calls() != 4 {
+		t.Errorf("unexpected calls %d/%d", out1.Calls(), out2.Calls())
+	}
+	if len(out1.Events()) != 2 || len(out2.Events()) != 2 {
+		t.Fatalf("unexpected events %d/%d", len(out1.Events()), len(out2.Events()))
+	}
+
+	signal := outputs.NewSyncSignal()
+	out2.mutex.Lock()
+	out2.fail = 2
+	out2.mutex.Unlock()
+	if !client.PublishEvents(testEvents(2), Guaranteed, Signal(signal)) {
+		t.Fatal("publish failed")
+	}
+	if !signal.Wait() {
+		t.Fatal("expected guaranteed publish to succeed")
+	}
+}
+
+func TestPublishEvents_BackPressure(t *testing.T) {
+	out := &fakeOutput{block: make(chan struct{})}
+	p := newTestPublisher(t, 1, out)
+	defer p.Stop()
+	client := p.Client()
+
+	// 第一批事件被 output 阻塞，第二批留在队列中，第三批阻塞调用方
+	client.PublishEvents(testEvents(1))
+	client.PublishEvents(testEvents(1))
+	published := make(chan bool)
+	go func() {
+		published <- client.PublishEvents(testEvents(1))
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("expected publish to block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	out.block <- struct{}{}
+	if !<-published {
+		t.Fatal("publish failed")
+	}
+	close(out.block)
+}
+
+func TestStop(t *testing.T) {
+	out := &fakeOutput{fail: -1}
+	p := newTestPublisher(t, 0, out)
+	client := p.Client()
+
+	// 一直失败的事件在 publisher 停止时返回失败
+	result := make(chan bool)
+	go func() {
+		result <- client.PublishEvents(testEvents(1), Sync, Guaranteed)
+	}()
+	for out.Calls() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	p.Stop()
+	if <-result {
+		t.Fatal("expected publish to fail after stop")
+	}
+
+	// 停止之后的发送直接失败
+	signal := outputs.NewSyncSignal()
+	if client.PublishEvents(testEvents(1), Signal(signal)) {
+		t.Fatal("expected publish to fail after stop")
+	}
+	if signal.Wait() {
+		t.Fatal("expected signal to fail after stop")
+	}
+	p.Stop()
+}
+
+func TestPublishEvents_Enrich(t *testing.T) {
+	out := &fakeOutput{}
+	p, err := New(ShipperConfig{Name: "shipper", Tags: []string{"web", "prod"}},
+		[]outputs.OutputPlugin{{Name: "fake", Output: out}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	events := []common.MapStr{{"n": 0}, {"n": 1, "tags": []string{"nginx"}}}
+	if !p.Client().PublishEvents(events, Sync) {
+		t.Fatal("publish failed")
+	}
+
+	hostname, _ := os.Hostname()
+	published := out.Events()
+	for _, event := range published {
+		beat := event["beat"].(common.MapStr)
+		if beat["name"] != "shipper" || beat["hostname"] != hostname {
+			t.Errorf("unexpected beat field %v", beat)
+		}
+	}
+	if tags := published[0]["tags"].([]string); len(tags) != 2 || tags[0] != "web" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if tags := published[1]["tags"].([]string); len(tags) != 3 || tags[0] != "nginx" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+}
+
+func TestPublishEvents_Geoip(t *testing.T) {
+	out := &fakeOutput{}
+	config := ShipperConfig{}
+	config.Geoip.Paths = []string{"../geoip/testdata/GeoIP2-City-Test.mmdb"}
+	p, err := New(config, []outputs.OutputPlugin{{Name: "fake", Output: out}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	if !p.Client().PublishEvent(common.MapStr{"client_ip": "81.2.69.142"}, Sync) {
+		t.Fatal("publish failed")
+	}
+	geo, ok := out.Events()[0]["client_geoip"].(common.MapStr)
+	if !ok || geo["city_name"] != "London" {
+		t.Errorf("unexpected client_geoip %v", out.Events()[0]["client_geoip"])
+	}
+
+	config.Geoip.Paths = []string{"/does/not/exist.mmdb"}
+	if _, err := New(config, nil); err == nil {
+		t.Error("expected error without a database")
+	}
+}
+
+func TestPublishEvents_Metrics(t *testing.T) {
+	out := &fakeOutput{fail: 1}
+	p := newTestPublisher(t, 0, out)
+	defer p.Stop()
+	client := p.Client()
+
+	published, acked, failed := eventsPublished.Get(), eventsAcked.Get(), eventsFailed.Get()
+
+	// 第一批发送失败，第二批发送成功
+	if client.PublishEvents(testEvents(3), Sync) {
+		t.Fatal("expected first batch to fail")
+	}
+	if !client.PublishEvents(testEvents(2), Sync) {
+		t.Fatal("expected second batch to succeed")
+	}
+
+	if n := eventsPublished.Get() - published; n != 5 {
+		t.Errorf("expected 5 published events, got %d", n)
+	}
+	if n := eventsAcked.Get() - acked; n != 2 {
+		t.Errorf("expected 2 acked events, got %d", n)
+	}
+	if n := eventsFailed.Get() - failed; n != 3 {
+		t.Errorf("expected 3 failed events, got %d", n)
+	}
+}
@@ -0,0 +1,125 @@
+package publisher
+
+import (
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"net"
+	"time"
+)
+
+const defaultRefreshTopologyFreq = 10 * time.Second
+
+// initTopology 找到第一个配置了 save_topology 的 output，用它来保存和读取拓扑结构
+func (p *PublisherType) initTopology(config ShipperConfig) {
+	for _, plugin := range p.outputs {
+		if !plugin.Config.SaveTopology {
+			continue
+		}
+		topo, ok := plugin.Output.(outputs.TopologyOutputer)
+		if !ok {
+			logp.Warn("publisher: %s output does not support save_topology", plugin.Name)
+			continue
+		}
+		p.topology = topo
+		logp.Info("publisher: using %s output for the topology", plugin.Name)
+		break
+	}
+
+	p.refreshTopology = defaultRefreshTopologyFreq
+	if config.RefreshTopologyFreq > 0 {
+		p.refreshTopology = time.Duration(config.RefreshTopologyFreq) * time.Second
+	}
+}
+
+// updateTopologyPeriodically 定期发布本机的地址，同时刷新 output 中缓存的拓扑结构
+func (p *PublisherType) updateTopologyPeriodically() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.refreshTopology)
+	defer ticker.Stop()
+	for {
+		p.publishTopology()
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *PublisherType) publishTopology() {
+	var addrs []string
+	for _, addr := range p.ipaddrs {
+		if ip := net.ParseIP(addr); ip != nil && !ip.IsLoopback() {
+			addrs = append(addrs, addr)
+		}
+	}
+	if err := p.topology.PublishIPs(p.name, addrs); err != nil {
+		logp.Err("publisher: failed to publish topology: %v", err)
+	}
+}
+
+// isPublisherIP 返回 ip 是否是本机的地址
+func (p *PublisherType) isPublisherIP(ip string) bool {
+	for _, addr := range p.ipaddrs {
+		if addr == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// getServerName 返回 ip 所属的 shipper 的名称，本机的地址返回自己的名称
+func (p *PublisherType) getServerName(ip string) string {
+	if p.isPublisherIP(ip) {
+		return p.name
+	}
+	if p.topology == nil {
+		return ""
+	}
+	return p.topology.GetNameByIP(ip)
+}
+
+// updateEventAddresses 根据事件中的 client_ip (发送方) 和 ip (接收方) 加上对应的 shipper 名称。
+// 发送方是本机时事件方向为 out；配置了 ignore_outgoing 时丢弃这些事件，因为接收方的 shipper 也会上报。
+// 返回 false 表示事件需要被丢弃
+func (p *PublisherType) updateEventAddresses(event common.MapStr) bool {
+	if clientIP, ok := event["client_ip"].(string); ok && clientIP != "" {
+		if p.isPublisherIP(clientIP) {
+			if p.ignoreOutgoing {
+				return false
+			}
+			event["direction"] = "out"
+		}
+		if name := p.getServerName(clientIP); name != "" {
+			event["client_server"] = name
+		}
+	}
+
+	if ip, ok := event["ip"].(string); ok && ip != "" {
+		if name := p.getServerName(ip); name != "" {
+			event["server"] = name
+		}
+	}
+	return true
+}
+
+// localAddrs 返回本机的 IP 地址，测试中会替换
+var localAddrs = interfaceAddrs
+
+// interfaceAddrs 返回本机所有网卡的 IP 地址
+func interfaceAddrs() ([]string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []string
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			ips = append(ips, ipnet.IP.String())
+		}
+	}
+	return ips, nil
+}
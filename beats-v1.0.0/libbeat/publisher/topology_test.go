@@ -0,0 +1,131 @@
+package publisher
+
+import (
+	"github.com/ssp4599815/beat/libbeat/common"
+	"github.com/ssp4599815/beat/libbeat/outputs"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTopology 是一个可以保存拓扑结构的 fakeOutput
+type fakeTopology struct {
+	fakeOutput
+
+	mutex     sync.Mutex
+	names     map[string]string
+	published int
+}
+
+func (o *fakeTopology) PublishIPs(name string, localAddrs []string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for _, addr := range localAddrs {
+		o.names[addr] = name
+	}
+	o.published++
+	return nil
+}
+
+func (o *fakeTopology) GetNameByIP(ip string) string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.names[ip]
+}
+
+func (o *fakeTopology) Published() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.published
+}
+
+// newTopologyPublisher 创建本机地址为 10.0.0.1 的 publisher
+func newTopologyPublisher(t *testing.T, config ShipperConfig, out *fakeTopology, save bool) *PublisherType {
+	localAddrs = func() ([]string, error) { return []string{"127.0.0.1", "10.0.0.1"}, nil }
+	defer func() { localAddrs = interfaceAddrs }()
+
+	plugin := outputs.OutputPlugin{
+		Name:   "fake",
+		Config: outputs.MothershipConfig{SaveTopology: save},
+		Output: out,
+	}
+	p, err := New(config, []outputs.OutputPlugin{plugin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestTopology_Refresh(t *testing.T) {
+	out := &fakeTopology{names: map[string]string{"10.0.0.2": "other"}}
+	p := newTopologyPublisher(t, ShipperConfig{Name: "shipper", RefreshTopologyFreq: 1}, out, true)
+	defer p.Stop()
+
+	// 启动时立即发布，之后每隔 refresh_topology_freq 发布一次
+	for out.Published() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if out.GetNameByIP("10.0.0.1") != "shipper" || out.GetNameByIP("127.0.0.1") != "" {
+		t.Errorf("unexpected topology %v", out.names)
+	}
+
+	p.Stop()
+	published := out.Published()
+	time.Sleep(20 * time.Millisecond)
+	if out.Published() != published {
+		t.Error("topology published after stop")
+	}
+}
+
+func TestTopology_EventAddresses(t *testing.T) {
+	out := &fakeTopology{names: map[string]string{"10.0.0.2": "other"}}
+	p := newTopologyPublisher(t, ShipperConfig{Name: "shipper"}, out, true)
+	defer p.Stop()
+
+	events := []common.MapStr{
+		{"client_ip": "10.0.0.1", "ip": "10.0.0.2"},
+		{"client_ip": "10.0.0.2", "ip": "10.0.0.1"},
+		{"client_ip": "10.0.0.3", "ip": "10.0.0.4"},
+	}
+	if !p.Client().PublishEvents(events, Sync) {
+		t.Fatal("publish failed")
+	}
+
+	published := out.Events()
+	if len(published) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(published))
+	}
+	expected := []common.MapStr{
+		{"client_server": "shipper", "server": "other", "direction": "out"},
+		{"client_server": "other", "server": "shipper"},
+		{},
+	}
+	for i, event := range published {
+		for _, key := range []string{"client_server", "server", "direction"} {
+			if event[key] != expected[i][key] {
+				t.Errorf("event %d: expected %s '%v', got '%v'", i, key, expected[i][key], event[key])
+			}
+		}
+	}
+}
+
+func TestTopology_IgnoreOutgoing(t *testing.T) {
+	out := &fakeTopology{names: map[string]string{}}
+	p := newTopologyPublisher(t, ShipperConfig{IgnoreOutgoing: true}, out, false)
+	defer p.Stop()
+
+	// 本机发出的事件被丢弃
+	events := []common.MapStr{{"client_ip": "10.0.0.1"}, {"client_ip": "10.0.0.2"}}
+	if !p.Client().PublishEvents(events, Sync) {
+		t.Fatal("publish failed")
+	}
+	if n := len(out.Events()); n != 1 {
+		t.Fatalf("expected 1 event, got %d", n)
+	}
+
+	// 没有配置 save_topology 时不会发布拓扑结构
+	if out.Published() != 0 {
+		t.Error("expected topology not to be published")
+	}
+}
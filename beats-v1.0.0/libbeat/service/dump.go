@@ -0,0 +1,24 @@
+package service
+
+import (
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"runtime"
+)
+
+// dumpGoroutines 将所有 goroutine 的调用栈输出到日志中，用于排查没有退出的 harvester 等问题。
+// 以 error 级别输出，默认的日志级别下也能看到
+func dumpGoroutines() {
+	logp.Err("Dumping stacks of %d goroutines:\n%s", runtime.NumGoroutine(), goroutineStacks())
+}
+
+// goroutineStacks 返回所有 goroutine 的调用栈，buffer 不够时加倍
+func goroutineStacks() []byte {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
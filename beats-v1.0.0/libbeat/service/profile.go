@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"github.com/ssp4599815/beat/libbeat/logp"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+)
+
+// 正在写入的 CPU profile 文件，没有开启时为 nil
+var cpuProfile *os.File
+
+// StartProfiling 根据 -httpprof 和 -cpuprofile 参数开启 pprof，参数为空时不开启。
+// httpprof 是 net/http/pprof 监听的地址，比如 localhost:6060，接口的路径为 /debug/pprof/
+// StartProfiling starts the pprof http server and the CPU profiling if enabled
+func StartProfiling(httpprof, cpuprofile string) error {
+	if httpprof != "" {
+		listener, err := net.Listen("tcp", httpprof)
+		if err != nil {
+			return fmt.Errorf("failed to start pprof http server: %v", err)
+		}
+		logp.Info("Start pprof http server on %s", listener.Addr())
+		go func() {
+			if err := http.Serve(listener, pprofHandler()); err != nil {
+				logp.Err("pprof http server stopped: %v", err)
+			}
+		}()
+	}
+
+	if cpuprofile != "" {
+		f, err := os.Create(cpuprofile)
+		if err != nil {
+			return fmt.Errorf("failed to create CPU profile: %v", err)
+		}
+		if err := runtimepprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to start CPU profile: %v", err)
+		}
+		cpuProfile = f
+	}
+	return nil
+}
+
+// StopProfiling 在退出时停止 CPU profile，并将内存的 profile 写入 memprofile，参数为空时不写入
+// StopProfiling stops the CPU profiling and writes the memory profile if enabled
+func StopProfiling(memprofile string) {
+	if cpuProfile != nil {
+		runtimepprof.StopCPUProfile()
+		if err := cpuProfile.Close(); err != nil {
+			logp.Err("Failed to write CPU profile: %v", err)
+		}
+		cpuProfile = nil
+	}
+
+	if memprofile != "" {
+		if err := writeHeapProfile(memprofile); err != nil {
+			logp.Err("Failed to write memory profile: %v", err)
+		}
+	}
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// 先 GC 一次，得到最新的内存使用情况
+	runtime.GC()
+	if err := runtimepprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// pprofHandler 不使用 http.DefaultServeMux，避免其他导入了 net/http/pprof 的包影响监听的接口
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfiling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cpuprofile := filepath.Join(dir, "cpu.prof")
+	memprofile := filepath.Join(dir, "mem.prof")
+	if err := StartProfiling("127.0.0.1:0", cpuprofile); err != nil {
+		t.Fatal(err)
+	}
+	StopProfiling(memprofile)
+
+	for _, path := range []string{cpuprofile, memprofile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 {
+			t.Errorf("%s is empty", path)
+		}
+	}
+	if cpuProfile != nil {
+		t.Error("expected CPU profiling to be stopped")
+	}
+
+	if err := StartProfiling("invalid address", ""); err == nil {
+		t.Error("expected error for invalid pprof address")
+	}
+	if err := StartProfiling("", filepath.Join(dir, "missing", "cpu.prof")); err == nil {
+		t.Error("expected error for invalid CPU profile path")
+	}
+}
+
+func TestGoroutineStacks(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < 100; i++ {
+		go func() { <-done }()
+	}
+
+	stacks := string(goroutineStacks())
+	if !strings.Contains(stacks, "TestGoroutineStacks") || strings.Count(stacks, "goroutine ") < 100 {
+		t.Errorf("unexpected stacks:\n%s", stacks)
+	}
+}
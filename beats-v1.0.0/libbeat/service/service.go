@@ -1,22 +1,48 @@
 package service
 
 import (
+	"github.com/ssp4599815/beat/libbeat/logp"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 )
 
-func HandleSignals(stopFunction func()) {
-	var callback sync.Once
+// 测试时替换，避免测试进程退出
+var exit = os.Exit
 
+// HandleSignals 处理 beat 运行时收到的信号:
+//
+//	SIGINT, SIGTERM  调用 stopFunction 正常退出，正常退出卡住时再收到一次则直接退出
+//	SIGHUP           调用 reloadFunction 重新加载配置
+//	SIGUSR1          将所有 goroutine 的调用栈输出到日志中
+//	SIGUSR2          重新打开日志文件，用于外部工具切割了日志文件之后
+//
+// Windows 上只支持 SIGINT 和 SIGTERM
+// HandleSignals registers the handlers for stop, reload, goroutine dump and log reopen signals
+func HandleSignals(stopFunction func(), reloadFunction func()) {
 	// On ^C or SIGTERM, gracefully stop the sniffer
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
 
 	// 如果收到终止的信号，就执行 退出函数
 	go func() {
-		<-sigc
-		callback.Do(stopFunction)
+		sig := <-sigc
+		logp.Info("Received signal %v, stopping. Send it again to force exit", sig)
+		// stopFunction 可能会卡住，在新的 goroutine 中调用，这样才能收到第二个信号
+		go stopFunction()
+
+		sig = <-sigc
+		logp.Critical("Received signal %v again, exiting without graceful shutdown", sig)
+		exit(1)
 	}()
+
+	handleUnixSignals(reloadFunction)
+}
+
+// reopenLogs 重新打开日志文件
+func reopenLogs() {
+	logp.Info("Reopening log files")
+	if err := logp.Reopen(); err != nil {
+		logp.Err("Failed to reopen log files: %v", err)
+	}
 }
@@ -0,0 +1,8 @@
+//go:build windows || nacl || plan9
+// +build windows nacl plan9
+
+package service
+
+// handleUnixSignals 在没有 SIGHUP、SIGUSR1 和 SIGUSR2 的系统上什么都不做
+func handleUnixSignals(reloadFunction func()) {
+}
@@ -0,0 +1,29 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package service
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleUnixSignals 处理 SIGHUP、SIGUSR1 和 SIGUSR2，信号按顺序在同一个 goroutine 中处理
+func handleUnixSignals(reloadFunction func()) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range sigc {
+			switch sig {
+			case syscall.SIGHUP:
+				reloadFunction()
+			case syscall.SIGUSR1:
+				dumpGoroutines()
+			case syscall.SIGUSR2:
+				reopenLogs()
+			}
+		}
+	}()
+}
@@ -0,0 +1,67 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package service
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleSignals(t *testing.T) {
+	exited := make(chan int, 1)
+	exit = func(code int) { exited <- code }
+	defer func() {
+		exit = os.Exit
+		signal.Reset(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	}()
+
+	stopped := make(chan struct{}, 1)
+	reloaded := make(chan struct{}, 1)
+	HandleSignals(func() {
+		stopped <- struct{}{}
+		// 模拟卡住的退出流程
+		select {}
+	}, func() {
+		reloaded <- struct{}{}
+	})
+
+	kill := func(sig syscall.Signal) {
+		if err := syscall.Kill(syscall.Getpid(), sig); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait := func(c chan struct{}, name string) {
+		select {
+		case <-c:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not called", name)
+		}
+	}
+
+	kill(syscall.SIGHUP)
+	wait(reloaded, "reload")
+	kill(syscall.SIGUSR2)
+
+	kill(syscall.SIGTERM)
+	wait(stopped, "stop")
+	select {
+	case <-exited:
+		t.Fatal("unexpected exit after the first signal")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 退出流程卡住时，第二个信号直接退出
+	kill(syscall.SIGINT)
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("expected exit code 1, got %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit not called")
+	}
+}